@@ -3,11 +3,13 @@ package main
 import (
 	"flag"
 	"log"
+	"os"
 	"runtime"
 
 	"github.build.ge.com/PredixEdgeOS/container-app-service/cappsdversion"
 	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
 	"github.build.ge.com/PredixEdgeOS/container-app-service/handlers"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
 )
 
 func main() {
@@ -35,8 +37,19 @@ func main() {
 		if cfg, err = config.NewConfig(file); err != nil {
 			log.Fatalf("Error loading configuration: %s", err)
 		}
-
-		go handlers.Start(cfg)
+		if err = logging.Configure(cfg.LogLevel, cfg.LogFormat); err != nil {
+			log.Fatalf("Error configuring logging: %s", err)
+		}
+		// Libraries logging with the log package write info lines
+		log.SetFlags(0)
+		log.SetOutput(logging.Default().Writer(logging.InfoLevel))
+
+		go func() {
+			if err := handlers.Start(cfg); err != nil {
+				logging.Error("Error starting service", "error", err)
+				os.Exit(1)
+			}
+		}()
 
 		runtime.Goexit()
 
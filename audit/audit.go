@@ -0,0 +1,188 @@
+// Package audit keeps the append-only log of the management operations of
+// cappsd on the data volume, rotating it by size.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// Defaults of the audit log settings of the configuration
+const (
+	DefaultMaxSize   = 10
+	DefaultRetention = 5
+)
+
+// maxLine is the longest entry read back from the log
+const maxLine = 1024 * 1024
+
+// Log writes audit entries as JSON lines to Path.  Once the file would grow
+// past MaxSize bytes it is rotated to Path.1, the previous Path.1 to Path.2
+// and so on, the oldest of the Retention rotated files being dropped.
+type Log struct {
+	Path      string
+	MaxSize   int64
+	Retention int
+	lock      sync.Mutex
+	file      *os.File
+	size      int64
+}
+
+// Filter selects entries, empty fields select every entry
+type Filter struct {
+	Since     time.Time
+	Until     time.Time
+	Operation string
+	App       string
+	Limit     int
+}
+
+// NewLog returns the audit log of <data_volume>/audit, rotated at
+// audit_max_size megabytes and keeping audit_retention rotated files
+func NewLog(c config.Config) *Log {
+	l := &Log{
+		Path:      filepath.Join(c.DataVolume, "audit", "audit.log"),
+		MaxSize:   DefaultMaxSize * 1024 * 1024,
+		Retention: DefaultRetention,
+	}
+	if c.AuditMaxSize > 0 {
+		l.MaxSize = int64(c.AuditMaxSize) * 1024 * 1024
+	}
+	if c.AuditRetention > 0 {
+		l.Retention = c.AuditRetention
+	}
+	return l
+}
+
+// Record appends an entry to the log and syncs it to disk, stamping it with
+// the current time unless it has one
+func (l *Log) Record(entry types.AuditEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		if err = l.open(); err != nil {
+			return err
+		}
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.MaxSize {
+		if err = l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// open opens the log for appending, the caller must hold the lock
+func (l *Log) open() error {
+	if err := os.MkdirAll(filepath.Dir(l.Path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// rotated returns the path of the nth rotated file, the current one for 0
+func (l *Log) rotated(n int) string {
+	if n == 0 {
+		return l.Path
+	}
+	return l.Path + "." + strconv.Itoa(n)
+}
+
+// rotate moves every file one place up and reopens an empty log, the caller
+// must hold the lock
+func (l *Log) rotate() error {
+	l.file.Close()
+	l.file = nil
+	for n := l.Retention - 1; n >= 0; n-- {
+		if err := os.Rename(l.rotated(n), l.rotated(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return l.open()
+}
+
+// Close closes the log file, the next Record reopens it
+func (l *Log) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Query returns the entries the filter selects, oldest first.  With a limit
+// only the newest entries are returned.
+func (l *Log) Query(filter Filter) ([]types.AuditEntry, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	entries := []types.AuditEntry{}
+	for n := l.Retention; n >= 0; n-- {
+		file, err := os.Open(l.rotated(n))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), maxLine)
+		for scanner.Scan() {
+			var entry types.AuditEntry
+			// A line cut short by a crash is skipped
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && filter.selects(entry) {
+				entries = append(entries, entry)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+	return entries, nil
+}
+
+func (f Filter) selects(entry types.AuditEntry) bool {
+	return (f.Since.IsZero() || !entry.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || !entry.Time.After(f.Until)) &&
+		(f.Operation == "" || entry.Operation == f.Operation) &&
+		(f.App == "" || entry.App == f.App || entry.Name == f.App)
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappsd-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	log := NewLog(config.Config{DataVolume: dir, AuditRetention: 2})
+	if log.MaxSize != DefaultMaxSize*1024*1024 || log.Retention != 2 {
+		t.Fatal("Expected the default size and the configured retention, got ", log.MaxSize, log.Retention)
+	}
+	log.MaxSize = 400
+
+	start := time.Date(2018, 6, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		entry := types.AuditEntry{
+			Time:      start.Add(time.Duration(i) * time.Minute),
+			Operation: "deploy",
+			App:       []string{"appa", "appb", "appc"}[i%3],
+			Outcome:   types.AuditSuccess,
+		}
+		if i%4 == 3 {
+			entry.Operation = "purge"
+		}
+		if err = log.Record(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.Close()
+	for _, name := range []string{"audit.log", "audit.log.1", "audit.log.2"} {
+		info, err := os.Stat(filepath.Join(dir, "audit", name))
+		if err != nil || info.Size() > 400 {
+			t.Error("Expected ", name, " to be rotated at 400 bytes, got ", info, err)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(dir, "audit", "audit.log.3")); !os.IsNotExist(err) {
+		t.Error("Expected only 2 rotated files to be kept")
+	}
+
+	// Entries of the dropped file are gone, the others are read oldest first
+	all, err := log.Query(Filter{})
+	if err != nil || len(all) == 0 || len(all) >= 20 || !all[len(all)-1].Time.Equal(start.Add(19*time.Minute)) {
+		t.Fatal("Expected the newest entries, got ", all, err)
+	}
+	for i := 1; i < len(all); i++ {
+		if !all[i].Time.After(all[i-1].Time) {
+			t.Error("Expected entries in order, got ", all)
+		}
+	}
+
+	// A line cut short is skipped
+	file, _ := os.OpenFile(log.Path, os.O_APPEND|os.O_WRONLY, 0600)
+	file.WriteString(`{"time":"2018-06-01T11:00:00Z","oper`)
+	file.Close()
+	selected, err := log.Query(Filter{
+		Since:     start.Add(14 * time.Minute),
+		Until:     start.Add(17 * time.Minute),
+		Operation: "deploy",
+		App:       "appb",
+	})
+	if err != nil || len(selected) != 1 || !selected[0].Time.Equal(start.Add(16*time.Minute)) {
+		t.Error("Expected the filtered entry, got ", selected, err)
+	}
+	if newest, _ := log.Query(Filter{Limit: 2}); len(newest) != 2 || !newest[1].Time.Equal(start.Add(19*time.Minute)) {
+		t.Error("Expected the 2 newest entries, got ", newest)
+	}
+
+	// The log is reopened for appending
+	if err = log.Record(types.AuditEntry{Operation: "kill", Outcome: types.AuditFailure}); err != nil {
+		t.Fatal(err)
+	}
+	if killed, _ := log.Query(Filter{Operation: "kill"}); len(killed) != 1 || killed[0].Time.IsZero() {
+		t.Error("Expected the entry to be stamped and appended, got ", killed)
+	}
+	if info, _ := os.Stat(log.Path); info.Mode().Perm() != 0600 {
+		t.Error("Expected the log to be private, got ", info.Mode())
+	}
+}
@@ -8,12 +8,32 @@ import (
 //Config ... a struct for Configuration
 type Config struct {
 	Docker        dockerConfig
+	Containerd    containerdConfig
+	Provider      string `json:"provider,omitempty"`
 	ListenAddress string `json:"listen_address"`
 	DataVolume    string `json:"data_volume"`
 	ReadTimeout   int    `json:"read_timeout"`
 	WriteTimeout  int    `json:"write_timeout"`
 	KeyLocation   string `json:"key,omitempty"`
 	KeyName       string `json:"key_name,omitempty"`
+
+	UpgradeGracePeriod int `json:"upgrade_grace_period,omitempty"`
+	HistoryRetention   int `json:"history_retention,omitempty"`
+	UploadRetention    int `json:"upload_retention,omitempty"`
+
+	MetricsAddress string `json:"metrics_address,omitempty"`
+	LogLevel       string `json:"log_level,omitempty"`
+	LogFormat      string `json:"log_format,omitempty"`
+
+	AuditMaxSize   int `json:"audit_max_size,omitempty"`
+	AuditRetention int `json:"audit_retention,omitempty"`
+
+	TLSListenAddress string `json:"tls_listen_address,omitempty"`
+	TLSCert          string `json:"tls_cert,omitempty"`
+	TLSKey           string `json:"tls_key,omitempty"`
+	TLSClientCA      string `json:"tls_client_ca,omitempty"`
+
+	Authorization authorizationConfig `json:"authorization"`
 }
 
 type dockerConfig struct {
@@ -22,6 +42,25 @@ type dockerConfig struct {
 	SSLPort  int    `json:"reserved_ssl_port"`
 }
 
+type containerdConfig struct {
+	Address   string `json:"address"`
+	Namespace string `json:"namespace"`
+	Binary    string `json:"ctr"`
+}
+
+// authorizationConfig maps the callers of the API to roles.  Users and
+// groups are uids and gids of processes on the unix socket, subjects those
+// of client certificates on the TLS listener and tokens the hex sha256 of
+// bearer tokens.
+type authorizationConfig struct {
+	Enabled     bool              `json:"enabled"`
+	DefaultRole string            `json:"default_role,omitempty"`
+	Users       map[string]string `json:"users,omitempty"`
+	Groups      map[string]string `json:"groups,omitempty"`
+	Subjects    map[string]string `json:"subjects,omitempty"`
+	Tokens      map[string]string `json:"tokens,omitempty"`
+}
+
 //NewConfig ...
 func NewConfig(path string) (Config, error) {
 	cfg := Config{}
@@ -0,0 +1,511 @@
+package handlers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/provider"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+const apiTestCompose = `version: '2'
+services:
+  web:
+    image: web:1.0
+  db:
+    image: db:1.0
+`
+
+func apiTestTarGz(files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))})
+		tw.Write(data)
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func apiTestPackage() []byte {
+	return apiTestComposePackage(apiTestCompose)
+}
+
+func apiTestComposePackage(compose string) []byte {
+	payload := apiTestTarGz(map[string][]byte{"docker-compose.yml": []byte(compose)})
+	return apiTestTarGz(map[string][]byte{
+		"MANIFEST.JSON":  []byte("{}"),
+		"payload.tar.gz": payload,
+	})
+}
+
+// newAPITestServer serves the full REST API backed by the in-memory provider
+func newAPITestServer(t *testing.T) (*httptest.Server, *provider.Memory, func()) {
+	return newAPITestServerWithConfig(t, config.Config{})
+}
+
+func newAPITestServerWithConfig(t *testing.T, cfg config.Config) (*httptest.Server, *provider.Memory, func()) {
+	dir, err := ioutil.TempDir("", "cappsd-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Provider = "memory"
+	cfg.DataVolume = dir
+	h, err := NewHandler(cfg)
+	if err != nil {
+		t.Fatal("Failed to create handler: ", err)
+	}
+	server := httptest.NewUnstartedServer(newRouter(h))
+	server.Config.WriteTimeout = time.Duration(cfg.WriteTimeout) * time.Second
+	server.Start()
+	return server, h.provider.(*provider.Memory), func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func apiDeploy(t *testing.T, url string, metadata string, pkg []byte) (int, DeployResponse) {
+	return apiUpload(t, url+"/application/deploy", metadata, pkg)
+}
+
+func apiUpload(t *testing.T, url string, metadata string, pkg []byte) (int, DeployResponse) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	formWriter, _ := writer.CreateFormFile("artifact", "app.tar.gz")
+	formWriter.Write(pkg)
+	writer.WriteField("metadata", metadata)
+	writer.Close()
+
+	resp, err := http.Post(url, writer.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal("Upload request failed: ", err)
+	}
+	defer resp.Body.Close()
+	var response DeployResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	return resp.StatusCode, response
+}
+
+func apiCall(t *testing.T, method string, url string) (int, BasicResponse) {
+	req, _ := http.NewRequest(method, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Request failed: ", err)
+	}
+	defer resp.Body.Close()
+	var response BasicResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	return resp.StatusCode, response
+}
+
+func TestAPILifecycle(t *testing.T) {
+	server, _, cleanup := newAPITestServer(t)
+	defer cleanup()
+
+	code, app := apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0","monitor":"yes"}`, apiTestPackage())
+	if code != http.StatusOK || app.Status != Ok || app.UUID == "" {
+		t.Fatal("Deploy failed: ", code, app)
+	}
+
+	resp, err := http.Get(server.URL + "/applications")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Contains(body, []byte(app.UUID)) {
+		t.Error("Deployed app missing from list: ", string(body))
+	}
+
+	resp, err = http.Get(server.URL + "/application/" + app.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var details AppDetailsResponse
+	json.NewDecoder(resp.Body).Decode(&details)
+	resp.Body.Close()
+	if details.Status != Ok || len(details.Containers) != 2 {
+		t.Error("Unexpected app details: ", details)
+	}
+
+	steps := []struct {
+		method string
+		path   string
+		status string
+	}{
+		{"GET", "/application/status/", Running},
+		{"POST", "/application/stop/", Ok},
+		{"GET", "/application/status/", Stopped},
+		{"POST", "/application/start/", Ok},
+		{"GET", "/application/status/", Running},
+		{"POST", "/application/restart/", Ok},
+		{"GET", "/application/status/", Running},
+		{"POST", "/application/purge/", Ok},
+	}
+	for _, step := range steps {
+		code, response := apiCall(t, step.method, server.URL+step.path+app.UUID)
+		if code != http.StatusOK || response.Status != step.status {
+			t.Errorf("%s %s: got %d %v, want %s", step.method, step.path, code, response, step.status)
+		}
+	}
+
+	code, response := apiCall(t, "GET", server.URL+"/application/status/"+app.UUID)
+	if code != http.StatusInternalServerError || response.Status != Fail {
+		t.Error("Expected purged app to be gone, got ", code, response)
+	}
+}
+
+func TestAPIMonitorRestart(t *testing.T) {
+	server, fake, cleanup := newAPITestServer(t)
+	defer cleanup()
+
+	_, app := apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0","monitor":"yes"}`, apiTestPackage())
+	if err := fake.Crash(app.UUID, "web", 137); err != nil {
+		t.Fatal(err)
+	}
+	if code, response := apiCall(t, "GET", server.URL+"/application/status/"+app.UUID); response.Status != Running {
+		t.Error("Expected monitored app to be restarted, got ", code, response)
+	}
+	if fake.Apps[app.UUID].RestartCount["web"] != 1 {
+		t.Error("Expected one restart of web")
+	}
+
+	// Services depending on a recovered one are restarted after it
+	compose := apiTestCompose + "    depends_on:\n      - web\n"
+	_, app = apiDeploy(t, server.URL, `{"name":"linked","version":"1.0","monitor":"yes"}`, apiTestComposePackage(compose))
+	if err := fake.Crash(app.UUID, "web", 1); err != nil {
+		t.Fatal(err)
+	}
+	if restarts := fake.Apps[app.UUID].RestartCount; restarts["web"] != 1 || restarts["db"] != 1 {
+		t.Error("Expected db to be restarted with web, got ", restarts)
+	}
+}
+
+func TestAPIRestartPolicy(t *testing.T) {
+	server, fake, cleanup := newAPITestServer(t)
+	defer cleanup()
+
+	metadata := `{"name":"testapp","version":"1.0","monitor":"yes","restart":{"web":{"policy":"on-failure","max_retries":1}}}`
+	_, app := apiDeploy(t, server.URL, metadata, apiTestPackage())
+	fake.Crash(app.UUID, "web", 0)
+	fake.Crash(app.UUID, "web", 1)
+	if code, response := apiCall(t, "GET", server.URL+"/application/status/"+app.UUID); response.Status != Running {
+		t.Error("Expected web to be restarted after failing, got ", code, response)
+	}
+	fake.Crash(app.UUID, "web", 1)
+	if code, response := apiCall(t, "GET", server.URL+"/application/status/"+app.UUID); response.Status != Unhealthy {
+		t.Error("Expected web to be crash-looping, got ", code, response)
+	}
+
+	resp, err := http.Get(server.URL + "/application/" + app.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var details AppDetailsResponse
+	json.NewDecoder(resp.Body).Decode(&details)
+	web, db := details.Services["web"], details.Services["db"]
+	if web.State != types.ServiceCrashLooping || web.Restarts != 1 || web.Policy != types.RestartOnFailure {
+		t.Error("Expected web to be crash-looping after one restart, got ", web)
+	}
+	if db.State != types.ServiceOk || db.Policy != types.RestartAlways {
+		t.Error("Expected db to restart always, got ", db)
+	}
+
+	if code, _ := apiDeploy(t, server.URL, `{"name":"bad","restart":{"queue":{"policy":"never"}}}`, apiTestPackage()); code != http.StatusInternalServerError {
+		t.Error("Expected restart policy of unknown service to be rejected, got ", code)
+	}
+	if code, _ := apiDeploy(t, server.URL, `{"name":"bad","probes":{"web":{"livenessProbe":{"initialDelaySeconds":5}}}}`, apiTestPackage()); code != http.StatusInternalServerError {
+		t.Error("Expected probe without a handler to be rejected, got ", code)
+	}
+}
+
+func TestAPIHealth(t *testing.T) {
+	server, fake, cleanup := newAPITestServer(t)
+	defer cleanup()
+
+	health := func(id string) HealthResponse {
+		resp, err := http.Get(server.URL + "/application/" + id + "/health")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var response HealthResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		return response
+	}
+	expectStatus := func(id string, expected string) {
+		if code, response := apiCall(t, "GET", server.URL+"/application/status/"+id); response.Status != expected {
+			t.Error("Expected status ", expected, " got ", code, response)
+		}
+	}
+
+	metadata := `{"name":"testapp","version":"1.0","monitor":"yes","restart":{"db":{"policy":"never"}}}`
+	_, app := apiDeploy(t, server.URL, metadata, apiTestPackage())
+	if response := health(app.UUID); response.Health != Running || len(response.Services) != 2 {
+		t.Error("Expected a running app with two services, got ", response)
+	}
+
+	fake.Crash(app.UUID, "db", 1)
+	expectStatus(app.UUID, Degraded)
+	fake.SetHealth(app.UUID, "web", false)
+	fake.SetHealth(app.UUID, "web", false)
+	response := health(app.UUID)
+	web := response.Services["web"]
+	if response.Health != Unhealthy || web.Healthy || web.Failures != 2 || web.Restarts != 2 || web.LastCheck == nil {
+		t.Error("Expected web to have failed twice, got ", response)
+	}
+	expectStatus(app.UUID, Unhealthy)
+
+	apiCall(t, "POST", server.URL+"/application/stop/"+app.UUID)
+	expectStatus(app.UUID, Stopped)
+	if response := health("unknown"); response.Status != Fail {
+		t.Error("Expected unknown app to fail, got ", response)
+	}
+}
+
+func TestAPIFailures(t *testing.T) {
+	server, fake, cleanup := newAPITestServer(t)
+	defer cleanup()
+
+	code, app := apiDeploy(t, server.URL, `{"name":"broken","version":"1.0"}`, apiTestTarGz(map[string][]byte{"MANIFEST.JSON": []byte("{}")}))
+	if code != http.StatusInternalServerError || app.Status != Fail || app.Error == "" {
+		t.Error("Expected malformed package to fail, got ", code, app)
+	}
+
+	code, app = apiDeploy(t, server.URL, `not json`, apiTestPackage())
+	if code != http.StatusBadRequest {
+		t.Error("Expected bad metadata to be rejected, got ", code, app)
+	}
+
+	_, app = apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0"}`, apiTestPackage())
+	fake.FailNext(provider.OpStart, errors.New("simulated start failure"))
+	code, response := apiCall(t, "POST", server.URL+"/application/start/"+app.UUID)
+	if code != http.StatusInternalServerError || response.Error != "simulated start failure" {
+		t.Error("Expected injected start failure, got ", code, response)
+	}
+	if code, response = apiCall(t, "POST", server.URL+"/application/start/"+app.UUID); code != http.StatusOK {
+		t.Error("Expected injected failure to be consumed, got ", code, response)
+	}
+}
+
+func TestAPIUpgrade(t *testing.T) {
+	server, fake, cleanup := newAPITestServer(t)
+	defer cleanup()
+
+	_, app := apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0"}`, apiTestPackage())
+	v2 := apiTestCompose + "  cache:\n    image: cache:1.0\n"
+	code, upgraded := apiUpload(t, server.URL+"/application/upgrade/"+app.UUID, `{"name":"testapp","version":"2.0"}`, apiTestComposePackage(v2))
+	if code != http.StatusOK || upgraded.UUID != app.UUID || upgraded.Version != "2.0" {
+		t.Fatal("Upgrade failed: ", code, upgraded)
+	}
+	if services := fake.Apps[app.UUID].Services; len(services) != 3 {
+		t.Error("Expected upgraded app to have three services, got ", services)
+	}
+
+	fake.FailNext(provider.OpUpgrade, errors.New("cache exited"))
+	code, upgraded = apiUpload(t, server.URL+"/application/upgrade/"+app.UUID, `{"name":"testapp","version":"3.0"}`, apiTestPackage())
+	if code != http.StatusInternalServerError || upgraded.Status != Fail || upgraded.Error != "Upgrade failed, rolled back to version 2.0: cache exited" {
+		t.Error("Expected failed upgrade to roll back, got ", code, upgraded)
+	}
+	if code, response := apiCall(t, "GET", server.URL+"/application/status/"+app.UUID); response.Status != Running {
+		t.Error("Expected previous version to keep running, got ", code, response)
+	}
+
+	code, upgraded = apiUpload(t, server.URL+"/application/upgrade/unknown", `{"name":"testapp","version":"3.0"}`, apiTestPackage())
+	if code != http.StatusInternalServerError || upgraded.Error != "Application ID not found" {
+		t.Error("Expected upgrade of unknown app to fail, got ", code, upgraded)
+	}
+}
+
+func TestAPIHistoryRollback(t *testing.T) {
+	var cfg config.Config
+	cfg.HistoryRetention = 2
+	server, fake, cleanup := newAPITestServerWithConfig(t, cfg)
+	defer cleanup()
+
+	_, app := apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0"}`, apiTestPackage())
+	v2 := apiTestCompose + "  cache:\n    image: cache:1.0\n"
+	apiUpload(t, server.URL+"/application/upgrade/"+app.UUID, `{"name":"testapp","version":"2.0"}`, apiTestComposePackage(v2))
+
+	resp, err := http.Get(server.URL + "/application/" + app.UUID + "/history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var history HistoryResponse
+	json.NewDecoder(resp.Body).Decode(&history)
+	resp.Body.Close()
+	if history.Status != Ok || history.Version != "2.0" || len(history.Versions) != 1 || history.Versions[0].Version != "1.0" {
+		t.Fatal("Unexpected history: ", history)
+	}
+
+	fake.FailNext(provider.OpRollback, errors.New("db exited"))
+	if code, response := apiCall(t, "POST", server.URL+"/application/"+app.UUID+"/rollback/1.0"); code != http.StatusInternalServerError {
+		t.Error("Expected injected rollback failure, got ", code, response)
+	}
+	if code, response := apiCall(t, "POST", server.URL+"/application/"+app.UUID+"/rollback/1.0"); code != http.StatusOK || response.Status != Ok {
+		t.Fatal("Rollback failed: ", code, response)
+	}
+	if services := fake.Apps[app.UUID].Services; len(services) != 2 || fake.Apps[app.UUID].Info.Version != "1.0" {
+		t.Error("Expected version 1.0 with two services, got ", services)
+	}
+	if code, response := apiCall(t, "POST", server.URL+"/application/"+app.UUID+"/rollback/9.9"); code != http.StatusInternalServerError || response.Error != "Application Version not found" {
+		t.Error("Expected unknown version to fail, got ", code, response)
+	}
+}
+
+func apiWaitJob(t *testing.T, url string, id string) JobResponse {
+	var job JobResponse
+	for i := 0; i < 100; i++ {
+		resp, err := http.Get(url + "/jobs/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job = JobResponse{}
+		json.NewDecoder(resp.Body).Decode(&job)
+		resp.Body.Close()
+		if job.Phase == PhaseDone || job.Phase == PhaseFailed {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Job did not finish: ", job)
+	return job
+}
+
+func TestAPIAsyncDeploy(t *testing.T) {
+	server, fake, cleanup := newAPITestServer(t)
+	defer cleanup()
+
+	upload := func(pkg []byte) JobResponse {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		formWriter, _ := writer.CreateFormFile("artifact", "app.tar.gz")
+		formWriter.Write(pkg)
+		writer.WriteField("metadata", `{"name":"testapp","version":"1.0"}`)
+		writer.Close()
+		resp, err := http.Post(server.URL+"/application/deploy?async=true", writer.FormDataContentType(), &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var job JobResponse
+		json.NewDecoder(resp.Body).Decode(&job)
+		if resp.StatusCode != http.StatusAccepted || job.ID == "" || job.Status != Ok {
+			t.Fatal("Expected deploy to be accepted as a job, got ", resp.StatusCode, job)
+		}
+		return job
+	}
+
+	job := apiWaitJob(t, server.URL, upload(apiTestPackage()).ID)
+	if job.Percent != 100 || job.Result == nil || job.Result.Status != Ok {
+		t.Fatal("Expected job to succeed, got ", job)
+	}
+	if _, exists := fake.Apps[job.Result.UUID]; !exists {
+		t.Error("Expected job result to name the deployed app")
+	}
+
+	fake.FailNext(provider.OpDeploy, errors.New("disk full"))
+	job = apiWaitJob(t, server.URL, upload(apiTestPackage()).ID)
+	if job.Phase != PhaseFailed || job.Result == nil || job.Result.Error != "disk full" {
+		t.Error("Expected job to fail, got ", job)
+	}
+
+	resp, err := http.Get(server.URL + "/jobs/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Error("Expected unknown job to be not found, got ", resp.StatusCode)
+	}
+}
+
+// apiStartJob posts a request run as a job and returns the accepted job
+func apiStartJob(t *testing.T, url string, contentType string, body io.Reader) JobResponse {
+	resp, err := http.Post(url, contentType, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var job JobResponse
+	json.NewDecoder(resp.Body).Decode(&job)
+	if resp.StatusCode != http.StatusAccepted || job.ID == "" || job.Status != Ok {
+		t.Fatal("Expected ", url, " to be accepted as a job, got ", resp.StatusCode, job)
+	}
+	return job
+}
+
+func TestAPIAsyncUpgrade(t *testing.T) {
+	var cfg config.Config
+	cfg.HistoryRetention = 2
+	server, fake, cleanup := newAPITestServerWithConfig(t, cfg)
+	defer cleanup()
+
+	_, app := apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0"}`, apiTestPackage())
+	upgrade := func(id string) JobResponse {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		formWriter, _ := writer.CreateFormFile("artifact", "app.tar.gz")
+		formWriter.Write(apiTestComposePackage(apiTestCompose + "  cache:\n    image: cache:1.0\n"))
+		writer.WriteField("metadata", `{"name":"testapp","version":"2.0"}`)
+		writer.WriteField("async", "true")
+		writer.Close()
+		return apiStartJob(t, server.URL+"/application/upgrade/"+id, writer.FormDataContentType(), &body)
+	}
+
+	job := apiWaitJob(t, server.URL, upgrade(app.UUID).ID)
+	if job.Phase != PhaseDone || job.Result == nil || job.Result.UUID != app.UUID || job.Result.Version != "2.0" {
+		t.Fatal("Expected the upgrade job to succeed, got ", job)
+	}
+	if services := fake.Apps[app.UUID].Services; len(services) != 3 {
+		t.Error("Expected upgraded app to have three services, got ", services)
+	}
+
+	job = apiWaitJob(t, server.URL, apiStartJob(t, server.URL+"/application/"+app.UUID+"/rollback/1.0?async=true", "", nil).ID)
+	if job.Phase != PhaseDone || job.Result == nil || job.Result.Version != "1.0" || fake.Apps[app.UUID].Info.Version != "1.0" {
+		t.Error("Expected the rollback job to succeed, got ", job)
+	}
+
+	job = apiWaitJob(t, server.URL, upgrade("unknown").ID)
+	if job.Phase != PhaseFailed || job.Result == nil || job.Result.UUID != "unknown" || job.Result.Error != types.InvalidID {
+		t.Error("Expected the upgrade job of an unknown app to fail, got ", job)
+	}
+}
+
+func TestAPIPruneImages(t *testing.T) {
+	server, fake, cleanup := newAPITestServer(t)
+	defer cleanup()
+
+	prune := func() (int, PruneResponse) {
+		resp, err := http.Post(server.URL+"/images/prune", "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var response PruneResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		return resp.StatusCode, response
+	}
+	if code, response := prune(); code != http.StatusOK || response.Status != Ok || response.Images == nil {
+		t.Error("Expected prune to succeed, got ", code, response)
+	}
+	fake.FailNext(provider.OpPruneImages, errors.New("daemon gone"))
+	if code, response := prune(); code != http.StatusInternalServerError || response.Error != "daemon gone" {
+		t.Error("Expected injected prune failure, got ", code, response)
+	}
+}
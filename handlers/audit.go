@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/audit"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// maxAuditError is how much of an error response is kept to find its error
+const maxAuditError = 64 * 1024
+
+// AuditResponse ...
+type AuditResponse struct {
+	Entries []types.AuditEntry `json:"entries"`
+	Status  string             `json:"status"`
+	Error   string             `json:"error"`
+}
+
+type auditKey struct{}
+
+// auditEntry returns the entry of an audited request, for its handler to
+// add the app and package it is about.  Other requests get a throwaway one.
+func auditEntry(r *http.Request) *types.AuditEntry {
+	if entry, ok := r.Context().Value(auditKey{}).(*types.AuditEntry); ok {
+		return entry
+	}
+	return &types.AuditEntry{}
+}
+
+// auditRecorder keeps the start of error responses, which carry the error
+// of the operation
+type auditRecorder struct {
+	*statusRecorder
+	body bytes.Buffer
+}
+
+func (a *auditRecorder) Write(p []byte) (int, error) {
+	if a.status >= http.StatusBadRequest && a.body.Len() < maxAuditError {
+		a.body.Write(p)
+	}
+	return a.statusRecorder.Write(p)
+}
+
+// audited records the operation of each request to next in the audit log
+// once it is answered, with the caller, the app of the id or name of the
+// route and the outcome
+func (h *Handler) audited(operation string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		entry := &types.AuditEntry{
+			RequestID: w.Header().Get(RequestIDHeader),
+			Caller:    callerOf(r.RemoteAddr),
+			Subject:   clientSubject(r),
+			Operation: operation,
+			App:       vars["id"],
+			Name:      vars["name"],
+			Version:   vars["version"],
+		}
+		if entry.Caller == nil {
+			entry.Remote = r.RemoteAddr
+		}
+		if entry.App != "" {
+			for _, app := range h.provider.ListApplications().Apps {
+				if app.UUID == entry.App {
+					entry.Name = app.Name
+				}
+			}
+		}
+
+		recorder := &auditRecorder{statusRecorder: &statusRecorder{ResponseWriter: w, status: http.StatusOK}}
+		next(recorder, r.WithContext(context.WithValue(r.Context(), auditKey{}, entry)))
+		entry.Code = recorder.status
+		switch {
+		case recorder.status == http.StatusAccepted:
+			entry.Outcome = types.AuditAccepted
+		case recorder.status < http.StatusBadRequest:
+			entry.Outcome = types.AuditSuccess
+		default:
+			entry.Outcome = types.AuditFailure
+			var response BasicResponse
+			if json.Unmarshal(recorder.body.Bytes(), &response) == nil {
+				entry.Error = response.Error
+			}
+		}
+		h.record(*entry)
+	}
+}
+
+// record writes an entry to the audit log, failures are only logged as the
+// operation is done
+func (h *Handler) record(entry types.AuditEntry) {
+	if err := h.audit.Record(entry); err != nil {
+		logging.Error("Failed to write the audit log", "operation", entry.Operation, "app", entry.App, "error", err)
+	}
+}
+
+// packageHash returns the sha256 of a package and rewinds it
+func packageHash(file io.ReadSeeker) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// auditFilter reads the since, until, operation, app and limit parameters of
+// an audit query
+func auditFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{Operation: query.Get("operation"), App: query.Get("app")}
+	now := time.Now()
+	var err error
+	if filter.Since, err = parseSince(query.Get("since"), now); err != nil {
+		return filter, err
+	}
+	if filter.Until, err = parseSince(query.Get("until"), now); err != nil {
+		return filter, errors.New("Invalid until " + query.Get("until"))
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, errors.New("Invalid limit " + limit)
+		}
+	}
+	return filter, nil
+}
+
+// queryAudit returns the entries of the audit log selected by the query,
+// oldest first
+func (h *Handler) queryAudit(w http.ResponseWriter, r *http.Request) {
+	response := AuditResponse{Entries: []types.AuditEntry{}, Status: Fail, Error: ""}
+	filter, err := auditFilter(r)
+	if err != nil {
+		response.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	entries, err := h.audit.Query(filter)
+	if err != nil {
+		response.Error = err.Error()
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	response.Entries = entries
+	response.Status = Ok
+	json.NewEncoder(w).Encode(response)
+}
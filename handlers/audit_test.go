@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/provider"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+func apiAudit(t *testing.T, url string, query string) (int, AuditResponse) {
+	resp, err := http.Get(url + "/audit" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var response AuditResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	return resp.StatusCode, response
+}
+
+func TestAPIAudit(t *testing.T) {
+	server, fake, cleanup := newAPITestServer(t)
+	defer cleanup()
+	start := time.Now().Add(-time.Second)
+
+	pkg := apiTestPackage()
+	sum := sha256.Sum256(pkg)
+	hash := "sha256:" + hex.EncodeToString(sum[:])
+	_, app := apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0"}`, pkg)
+	fake.FailNext(provider.OpKill, errors.New("kill failed"))
+	apiCall(t, "POST", server.URL+"/application/kill/"+app.UUID)
+	apiCall(t, "POST", server.URL+"/application/purge/"+app.UUID)
+	apiCall(t, "GET", server.URL+"/applications")
+
+	code, response := apiAudit(t, server.URL, "")
+	if code != http.StatusOK || response.Status != Ok || len(response.Entries) != 3 {
+		t.Fatal("Expected the deploy, kill and purge to be audited, got ", code, response)
+	}
+	deploy, kill, purge := response.Entries[0], response.Entries[1], response.Entries[2]
+	if deploy.Operation != "deploy" || deploy.App != app.UUID || deploy.Name != "testapp" || deploy.Version != "1.0" ||
+		deploy.PackageHash != hash || deploy.Outcome != types.AuditSuccess || deploy.Code != http.StatusOK {
+		t.Error("Expected the deploy with its package hash, got ", deploy)
+	}
+	if deploy.RequestID == "" || deploy.Remote == "" || deploy.Caller != nil || deploy.Time.Before(start) {
+		t.Error("Expected the deploy to name its request and caller, got ", deploy)
+	}
+	if kill.Operation != "kill" || kill.Name != "testapp" || kill.Outcome != types.AuditFailure || kill.Error != "kill failed" {
+		t.Error("Expected the failed kill, got ", kill)
+	}
+	if purge.Operation != "purge" || purge.App != app.UUID || purge.Outcome != types.AuditSuccess {
+		t.Error("Expected the purge, got ", purge)
+	}
+
+	if _, response = apiAudit(t, server.URL, "?operation=kill&app=testapp"); len(response.Entries) != 1 || response.Entries[0].Operation != "kill" {
+		t.Error("Expected the kill, got ", response)
+	}
+	if _, response = apiAudit(t, server.URL, "?until="+start.Format(time.RFC3339Nano)); len(response.Entries) != 0 {
+		t.Error("Expected no entries before the test, got ", response)
+	}
+	if _, response = apiAudit(t, server.URL, "?since=1h&limit=1"); len(response.Entries) != 1 || response.Entries[0].Operation != "purge" {
+		t.Error("Expected the newest entry, got ", response)
+	}
+	if code, response = apiAudit(t, server.URL, "?until=tomorrow"); code != http.StatusBadRequest || response.Error != "Invalid until tomorrow" {
+		t.Error("Expected an invalid until to be rejected, got ", code, response)
+	}
+
+	// Deploys run as jobs are recorded when accepted and when done
+	if code, _ := apiUpload(t, server.URL+"/application/deploy?async=true", `{"name":"other","version":"2.0"}`, pkg); code != http.StatusAccepted {
+		t.Fatal("Expected a job, got ", code)
+	}
+	id := ""
+	_, response = apiAudit(t, server.URL, "?operation=deploy")
+	for _, entry := range response.Entries {
+		if entry.Outcome == types.AuditAccepted {
+			id = entry.Job
+		}
+	}
+	if id == "" {
+		t.Fatal("Expected the accepted deploy, got ", response)
+	}
+	apiWaitJob(t, server.URL, id)
+	_, response = apiAudit(t, server.URL, "?operation=deploy&app=other")
+	if len(response.Entries) != 2 || response.Entries[1].Job != id || response.Entries[1].Outcome != types.AuditSuccess ||
+		response.Entries[1].App == "" || response.Entries[1].PackageHash != hash {
+		t.Error("Expected the job to be audited when done, got ", response)
+	}
+}
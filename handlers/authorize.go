@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+)
+
+// Roles of the callers of the API
+const (
+	RoleViewer      = "viewer"
+	RoleOperator    = "operator"
+	RoleProvisioner = "provisioner"
+	RoleAdmin       = "admin"
+)
+
+// permission is what a route needs of the role of its caller
+type permission string
+
+const (
+	// permView reads apps, their logs, stats and events
+	permView permission = "view"
+	// permOperate deploys, upgrades, starts, stops and execs into apps
+	permOperate permission = "operate"
+	// permManage kills and purges apps and reads the audit log
+	permManage permission = "manage"
+	// permProvision creates and reads the machine key
+	permProvision permission = "provision"
+)
+
+var rolePermissions = map[string][]permission{
+	RoleViewer:      {permView},
+	RoleOperator:    {permView, permOperate},
+	RoleProvisioner: {permProvision},
+	RoleAdmin:       {permView, permOperate, permManage, permProvision},
+}
+
+// tokenPrefix is how much of the sha256 of a bearer token identifies it in
+// the audit log
+const tokenPrefix = 12
+
+// policy maps the callers of the API to roles, the first of the bearer
+// token, the subject of the client certificate, the uid and the gid of the
+// caller to be mapped giving the role
+type policy struct {
+	enabled     bool
+	defaultRole string
+	users       map[int]string
+	groups      map[int]string
+	subjects    map[string]string
+	tokens      map[string]string
+}
+
+func validRole(role string) error {
+	if _, ok := rolePermissions[role]; !ok {
+		return errors.New("Unknown role " + role)
+	}
+	return nil
+}
+
+func idRoles(kind string, roles map[string]string) (map[int]string, error) {
+	ids := map[int]string{}
+	for key, role := range roles {
+		id, err := strconv.Atoi(key)
+		if err != nil || id < 0 {
+			return nil, errors.New("Invalid " + kind + " " + key)
+		}
+		if err = validRole(role); err != nil {
+			return nil, err
+		}
+		ids[id] = role
+	}
+	return ids, nil
+}
+
+// newPolicy reads the authorization settings of the configuration
+func newPolicy(c config.Config) (*policy, error) {
+	settings := c.Authorization
+	p := &policy{enabled: settings.Enabled, defaultRole: settings.DefaultRole, subjects: map[string]string{}, tokens: map[string]string{}}
+	if !p.enabled {
+		return p, nil
+	}
+	if p.defaultRole != "" {
+		if err := validRole(p.defaultRole); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if p.users, err = idRoles("uid", settings.Users); err != nil {
+		return nil, err
+	}
+	if p.groups, err = idRoles("gid", settings.Groups); err != nil {
+		return nil, err
+	}
+	for subject, role := range settings.Subjects {
+		if err = validRole(role); err != nil {
+			return nil, err
+		}
+		p.subjects[subject] = role
+	}
+	for hash, role := range settings.Tokens {
+		hash = strings.ToLower(strings.TrimPrefix(hash, "sha256:"))
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return nil, errors.New("Invalid token hash " + hash)
+		}
+		if err = validRole(role); err != nil {
+			return nil, err
+		}
+		p.tokens[hash] = role
+	}
+	return p, nil
+}
+
+// role returns the role of the caller of a request and, for callers with a
+// bearer token, the start of its hash.  A token that is not configured is an
+// error even if the caller would have a role otherwise.
+func (p *policy) role(r *http.Request) (string, string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if !strings.HasPrefix(header, "Bearer ") {
+			return "", "", errors.New("Invalid authorization header")
+		}
+		sum := sha256.Sum256([]byte(strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))))
+		hash := hex.EncodeToString(sum[:])
+		role, ok := p.tokens[hash]
+		if !ok {
+			return "", "", errors.New("Unknown token")
+		}
+		return role, hash[:tokenPrefix], nil
+	}
+	if role, ok := p.subjects[clientSubject(r)]; ok {
+		return role, "", nil
+	}
+	if caller := callerOf(r.RemoteAddr); caller != nil {
+		if role, ok := p.users[caller.UID]; ok {
+			return role, "", nil
+		}
+		if role, ok := p.groups[caller.GID]; ok {
+			return role, "", nil
+		}
+	}
+	if p.defaultRole == "" {
+		return "", "", errors.New("Unknown caller")
+	}
+	return p.defaultRole, "", nil
+}
+
+func allows(role string, needed permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == needed {
+			return true
+		}
+	}
+	return false
+}
+
+// allow serves requests to next only when the role of their caller has the
+// permission, answering 401 to callers without a role and 403 to those whose
+// role lacks it.  Every request is served when authorization is disabled.
+func (h *Handler) allow(needed permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.policy.enabled {
+			next(w, r)
+			return
+		}
+		role, token, err := h.policy.role(r)
+		entry := auditEntry(r)
+		entry.Role, entry.Token = role, token
+		if err == nil && allows(role, needed) {
+			next(w, r)
+			return
+		}
+
+		response := BasicResponse{Status: Fail}
+		if err != nil {
+			response.Error = err.Error()
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cappsd"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			response.Error = "Role " + role + " is not allowed to " + string(needed)
+			w.WriteHeader(http.StatusForbidden)
+		}
+		logging.FromContext(r.Context()).Warn("Request denied", "path", r.URL.Path, "remote", r.RemoteAddr, "role", role, "error", response.Error)
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestPolicy(t *testing.T) {
+	for name, settings := range map[string]string{
+		"an unknown role":         `{"enabled":true,"default_role":"root"}`,
+		"a user name":             `{"enabled":true,"users":{"root":"admin"}}`,
+		"a short token":           `{"enabled":true,"tokens":{"abcd":"viewer"}}`,
+		"an unknown subject role": `{"enabled":true,"subjects":{"CN=a":"owner"}}`,
+	} {
+		var cfg config.Config
+		json.Unmarshal([]byte(settings), &cfg.Authorization)
+		if _, err := newPolicy(cfg); err == nil {
+			t.Error("Expected ", name, " to be rejected")
+		}
+	}
+
+	var cfg config.Config
+	cfg.Authorization.Enabled = true
+	cfg.Authorization.Users = map[string]string{"0": RoleAdmin, "1000": RoleOperator}
+	cfg.Authorization.Groups = map[string]string{"100": RoleViewer}
+	cfg.Authorization.Subjects = map[string]string{"CN=fleet-manager": RoleProvisioner}
+	cfg.Authorization.Tokens = map[string]string{"sha256:" + tokenHash("secret"): RoleOperator}
+	p, err := newPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "fleet-manager"}}}}
+	for _, test := range []struct {
+		remote string
+		tls    *tls.ConnectionState
+		header string
+		role   string
+	}{
+		{remote: "pid=1,uid=0,gid=0", role: RoleAdmin},
+		{remote: "pid=1,uid=1000,gid=100", role: RoleOperator},
+		{remote: "pid=1,uid=1001,gid=100", role: RoleViewer},
+		{remote: "pid=1,uid=1001,gid=1001"},
+		{remote: "127.0.0.1:4000", tls: subject, role: RoleProvisioner},
+		{remote: "127.0.0.1:4000"},
+		{remote: "pid=1,uid=0,gid=0", header: "Bearer secret", role: RoleOperator},
+		{remote: "pid=1,uid=0,gid=0", header: "Bearer wrong"},
+		{remote: "pid=1,uid=0,gid=0", header: "Basic secret"},
+	} {
+		r, _ := http.NewRequest("GET", "/applications", nil)
+		r.RemoteAddr, r.TLS = test.remote, test.tls
+		if test.header != "" {
+			r.Header.Set("Authorization", test.header)
+		}
+		role, token, err := p.role(r)
+		if role != test.role || (err == nil) != (test.role != "") {
+			t.Error("Expected ", test.remote, " ", test.header, " to have role ", test.role, ", got ", role, err)
+		}
+		if test.role != "" && test.header != "" && token != tokenHash("secret")[:tokenPrefix] {
+			t.Error("Expected the token to be identified by its hash, got ", token)
+		}
+	}
+
+	p.defaultRole = RoleViewer
+	r, _ := http.NewRequest("GET", "/applications", nil)
+	r.RemoteAddr = "pid=1,uid=1001,gid=1001"
+	if role, _, err := p.role(r); role != RoleViewer || err != nil {
+		t.Error("Expected unknown callers to get the default role, got ", role, err)
+	}
+}
+
+func authorizedRequest(t *testing.T, method string, url string, token string) (int, BasicResponse) {
+	req, _ := http.NewRequest(method, url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var response BasicResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	return resp.StatusCode, response
+}
+
+func TestAPIAuthorization(t *testing.T) {
+	var cfg config.Config
+	cfg.Authorization.Enabled = true
+	cfg.Authorization.Tokens = map[string]string{
+		tokenHash("view"):      RoleViewer,
+		tokenHash("operate"):   RoleOperator,
+		tokenHash("provision"): RoleProvisioner,
+		tokenHash("admin"):     RoleAdmin,
+	}
+	server, _, cleanup := newAPITestServerWithConfig(t, cfg)
+	defer cleanup()
+
+	for _, test := range []struct {
+		method string
+		path   string
+		token  string
+		code   int
+	}{
+		{"GET", "/ping", "", http.StatusOK},
+		{"GET", "/applications", "", http.StatusUnauthorized},
+		{"GET", "/applications", "unknown", http.StatusUnauthorized},
+		{"GET", "/applications", "view", http.StatusOK},
+		{"POST", "/application/stop/unknown", "view", http.StatusForbidden},
+		{"POST", "/application/stop/unknown", "operate", http.StatusInternalServerError},
+		{"POST", "/application/kill/unknown", "operate", http.StatusForbidden},
+		{"POST", "/application/purge/unknown", "operate", http.StatusForbidden},
+		{"POST", "/application/kill/unknown", "admin", http.StatusInternalServerError},
+		{"GET", "/provision/hasKey", "operate", http.StatusForbidden},
+		{"GET", "/provision/hasKey", "provision", http.StatusOK},
+		{"GET", "/applications", "provision", http.StatusForbidden},
+		{"GET", "/audit", "operate", http.StatusForbidden},
+	} {
+		code, response := authorizedRequest(t, test.method, server.URL+test.path, test.token)
+		if code != test.code {
+			t.Error("Expected ", test.method, " ", test.path, " with token ", test.token, " to give ", test.code, ", got ", code, response)
+		}
+		if (code == http.StatusForbidden || code == http.StatusUnauthorized) && (response.Status != Fail || response.Error == "") {
+			t.Error("Expected the denial to be explained, got ", response)
+		}
+	}
+
+	// Denied operations are audited with the role of the caller
+	req, _ := http.NewRequest("GET", server.URL+"/audit?operation=kill", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var response AuditResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	if len(response.Entries) != 2 {
+		t.Fatal("Expected both kills to be audited, got ", response)
+	}
+	denied := response.Entries[0]
+	if denied.Role != RoleOperator || denied.Token != tokenHash("operate")[:tokenPrefix] ||
+		denied.Code != http.StatusForbidden || denied.Outcome != types.AuditFailure || denied.Error == "" {
+		t.Error("Expected the denied kill to be audited, got ", denied)
+	}
+	if response.Entries[1].Role != RoleAdmin {
+		t.Error("Expected the kill of the admin to be audited with its role, got ", response.Entries[1])
+	}
+}
+
+func TestMetricsListenerAuthorization(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappsd-metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := config.Config{Provider: "memory", DataVolume: dir, MetricsAddress: "127.0.0.1:0"}
+	cfg.Authorization.Enabled = true
+	cfg.Authorization.Tokens = map[string]string{
+		tokenHash("view"):      RoleViewer,
+		tokenHash("provision"): RoleProvisioner,
+	}
+	s, err := setupServer(cfg)
+	if err != nil || s.metrics == nil {
+		t.Fatal("Expected the metrics server, got ", s, err)
+	}
+	server := httptest.NewServer(s.metrics.Handler)
+	defer server.Close()
+
+	for token, expected := range map[string]int{
+		"":          http.StatusUnauthorized,
+		"provision": http.StatusForbidden,
+		"view":      http.StatusOK,
+	} {
+		if code, _ := authorizedRequest(t, "GET", server.URL+"/metrics", token); code != expected {
+			t.Error("Expected metrics with token ", token, " to give ", expected, ", got ", code)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/provider"
+)
+
+// keepAliveInterval is how often an idle event stream sends a comment, so
+// proxies keep the connection open and clients notice when it drops
+var keepAliveInterval = 30 * time.Second
+
+// endlessResponse lifts the write timeout of the server from a response that
+// streams until the client disconnects, as exec sessions do
+func endlessResponse(w http.ResponseWriter) {
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
+
+// streamEvents sends the lifecycle events of applications as Server-Sent
+// Events until the client disconnects.  The id and name query parameters
+// limit the stream to one application.
+func (h *Handler) streamEvents(w http.ResponseWriter, r *http.Request) {
+	publisher, ok := h.provider.(provider.EventPublisher)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(BasicResponse{Status: Fail, Error: "Events are not supported by the provider"})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(BasicResponse{Status: Fail, Error: "Streaming is not supported"})
+		return
+	}
+	id := r.URL.Query().Get("id")
+	name := r.URL.Query().Get("name")
+
+	events, unsubscribe := publisher.Subscribe()
+	defer unsubscribe()
+	endlessResponse(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event, open := <-events:
+			if !open {
+				return
+			}
+			if (id != "" && event.UUID != id) || (name != "" && event.Name != name) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
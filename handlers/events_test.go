@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// eventStream reads the Server-Sent Events of a GET /events request
+func eventStream(t *testing.T, url string) (chan types.LifecycleEvent, func()) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatal("Expected an event stream, got ", resp.Status, resp.Header)
+	}
+	events := make(chan types.LifecycleEvent, 16)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		kind := ""
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				kind = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				var event types.LifecycleEvent
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event)
+				if event.Type != kind {
+					t.Error("Expected event ", kind, " got ", event)
+				}
+				events <- event
+			}
+		}
+	}()
+	return events, func() {
+		resp.Body.Close()
+	}
+}
+
+func expectEvent(t *testing.T, events chan types.LifecycleEvent, kind string, service string) types.LifecycleEvent {
+	select {
+	case event := <-events:
+		if event.Type != kind || event.Service != service {
+			t.Error("Expected ", kind, " event of ", service, " got ", event)
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("Expected ", kind, " event")
+	}
+	return types.LifecycleEvent{}
+}
+
+func TestAPIEvents(t *testing.T) {
+	server, fake, cleanup := newAPITestServer(t)
+	defer cleanup()
+
+	all, closeAll := eventStream(t, server.URL+"/events")
+	defer closeAll()
+	named, closeNamed := eventStream(t, server.URL+"/events?name=testapp")
+	defer closeNamed()
+
+	apiDeploy(t, server.URL, `{"name":"other","version":"1.0"}`, apiTestPackage())
+	_, app := apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0","monitor":"yes"}`, apiTestPackage())
+	apiDeploy(t, server.URL, `{"name":"testapp","restart":{"queue":{}}}`, apiTestPackage())
+	if event := expectEvent(t, all, types.EventDeployed, ""); event.Name != "other" {
+		t.Error("Expected other to be deployed first, got ", event)
+	}
+	if event := expectEvent(t, named, types.EventDeployed, ""); event.UUID != app.UUID || event.Version != "1.0" || event.Time.IsZero() {
+		t.Error("Expected testapp to be deployed, got ", event)
+	}
+	if event := expectEvent(t, named, types.EventDeployFailed, ""); event.Detail == "" {
+		t.Error("Expected the deploy error, got ", event)
+	}
+
+	fake.Crash(app.UUID, "web", 1)
+	expectEvent(t, named, types.EventUnhealthy, "web")
+	expectEvent(t, named, types.EventRestarted, "web")
+	apiCall(t, "POST", server.URL+"/application/stop/"+app.UUID)
+	expectEvent(t, named, types.EventStopped, "")
+	apiCall(t, "POST", server.URL+"/application/start/"+app.UUID)
+	expectEvent(t, named, types.EventStarted, "")
+
+	// Events of other apps are not sent on the filtered streams
+	id, closeID := eventStream(t, server.URL+"/events?id="+app.UUID)
+	defer closeID()
+	apiDeploy(t, server.URL, `{"name":"other","version":"2.0"}`, apiTestPackage())
+	apiCall(t, "POST", server.URL+"/application/restart/"+app.UUID)
+	apiCall(t, "POST", server.URL+"/application/purge/"+app.UUID)
+	for _, events := range []chan types.LifecycleEvent{named, id} {
+		expectEvent(t, events, types.EventRestarted, "")
+		expectEvent(t, events, types.EventPurged, "")
+	}
+}
+
+func TestAPIEventsOutliveWriteTimeout(t *testing.T) {
+	server, _, cleanup := newAPITestServerWithConfig(t, config.Config{WriteTimeout: 1})
+	defer cleanup()
+
+	events, closeEvents := eventStream(t, server.URL+"/events")
+	defer closeEvents()
+	time.Sleep(1500 * time.Millisecond)
+	apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0"}`, apiTestPackage())
+	expectEvent(t, events, types.EventDeployed, "")
+}
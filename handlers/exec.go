@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/provider"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// ExecResponse ...
+type ExecResponse struct {
+	UUID     string `json:"uuid"`
+	Service  string `json:"service"`
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	Status   string `json:"status"`
+	Error    string `json:"error"`
+}
+
+// execUpgrade hijacks the connection of an interactive exec the first time
+// the command reads or writes, so errors before it starts still get a JSON
+// response
+type execUpgrade struct {
+	w    http.ResponseWriter
+	once sync.Once
+	conn net.Conn
+	buf  *bufio.ReadWriter
+	err  error
+}
+
+func (u *execUpgrade) upgrade() error {
+	u.once.Do(func() {
+		if u.conn, u.buf, u.err = u.w.(http.Hijacker).Hijack(); u.err != nil {
+			return
+		}
+		// Sessions outlive the read and write timeouts of the server
+		u.conn.SetDeadline(time.Time{})
+		u.buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		u.err = u.buf.Flush()
+	})
+	return u.err
+}
+
+func (u *execUpgrade) Read(p []byte) (int, error) {
+	if err := u.upgrade(); err != nil {
+		return 0, err
+	}
+	return u.buf.Read(p)
+}
+
+func (u *execUpgrade) Write(p []byte) (int, error) {
+	if err := u.upgrade(); err != nil {
+		return 0, err
+	}
+	return u.conn.Write(p)
+}
+
+// close ends the session and tells if the connection was upgraded
+func (u *execUpgrade) close() bool {
+	u.once.Do(func() {
+		u.err = errors.New("Exec session closed")
+	})
+	if u.conn == nil {
+		return false
+	}
+	u.conn.Close()
+	return true
+}
+
+// execApplication runs a command in the container of a service of an
+// application.  Requests with an Upgrade header run it on a terminal and
+// switch the connection to a raw stream of its input and output, which is
+// closed when the command exits.
+func (h *Handler) execApplication(w http.ResponseWriter, r *http.Request) {
+	response := ExecResponse{Status: Fail, Error: ""}
+	response.UUID = mux.Vars(r)["id"]
+	executor, ok := h.provider.(provider.Executor)
+	if !ok {
+		response.Error = "Exec is not supported by the provider"
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	var request types.ExecRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		response.Error = "Invalid exec request: " + err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	response.Service = request.Service
+	auditEntry(r).Detail = request.Service + ": " + strings.Join(request.Command, " ")
+	interactive := strings.EqualFold(r.Header.Get("Upgrade"), "tcp")
+	if request.TTY && !interactive {
+		response.Error = "Interactive exec needs an Upgrade: tcp header"
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	if interactive {
+		if _, ok := w.(http.Hijacker); !ok {
+			response.Error = "Connection upgrades are not supported"
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		stream := &execUpgrade{w: w}
+		code, err := executor.ExecStream(r.Context(), response.UUID, request, stream)
+		if stream.close() {
+			logger := logging.FromContext(r.Context()).With("app", response.UUID, "service", request.Service, "command", strings.Join(request.Command, " "))
+			if err != nil {
+				logger.Warn("Exec session failed", "exit_code", code, "error", err)
+			} else {
+				logger.Info("Exec session exited", "exit_code", code)
+			}
+			return
+		}
+		if err == nil {
+			err = errors.New("Exec session ended before it started")
+		}
+		response.Error = err.Error()
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// The provider times out commands itself, later than the usual write
+	// timeout of the server
+	endlessResponse(w)
+	if result, err := executor.Exec(r.Context(), response.UUID, request); err == nil {
+		response.ExitCode = result.ExitCode
+		response.Stdout = result.Stdout
+		response.Stderr = result.Stderr
+		response.Status = Ok
+	} else {
+		response.Error = err.Error()
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(response)
+}
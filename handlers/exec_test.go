@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func apiExec(t *testing.T, url string, request string) (int, ExecResponse) {
+	resp, err := http.Post(url, "application/json", strings.NewReader(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var response ExecResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	return resp.StatusCode, response
+}
+
+func TestAPIExec(t *testing.T) {
+	server, fake, cleanup := newAPITestServer(t)
+	defer cleanup()
+
+	_, app := apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0"}`, apiTestPackage())
+	url := server.URL + "/application/" + app.UUID + "/exec"
+	if code, response := apiExec(t, url, `{"service":"web","command":["echo","hello","world"]}`); code != http.StatusOK || response.Stdout != "hello world\n" || response.ExitCode != 0 || response.Service != "web" {
+		t.Error("Expected echo to run, got ", code, response)
+	}
+	if _, response := apiExec(t, url, `{"service":"db","command":["cat"],"stdin":"SELECT 1;"}`); response.Stdout != "SELECT 1;" {
+		t.Error("Expected cat to read stdin, got ", response)
+	}
+	if code, response := apiExec(t, url, `{"service":"web","command":["top"]}`); code != http.StatusOK || response.ExitCode != 127 || response.Stderr != "top: not found\n" {
+		t.Error("Expected a missing command to exit with 127, got ", code, response)
+	}
+
+	for request, expected := range map[string]int{
+		`{"service":"web"`: http.StatusBadRequest,
+		`{"service":"web","command":["sh"],"tty":true}`: http.StatusBadRequest,
+		`{"service":"queue","command":["echo"]}`:        http.StatusInternalServerError,
+		`{"service":"web","command":[]}`:                http.StatusInternalServerError,
+	} {
+		if code, response := apiExec(t, url, request); code != expected || response.Status != Fail || response.Error == "" {
+			t.Error("Expected ", request, " to fail with ", expected, " got ", code, response)
+		}
+	}
+	if code, _ := apiExec(t, server.URL+"/application/unknown/exec", `{"service":"web","command":["echo"]}`); code != http.StatusInternalServerError {
+		t.Error("Expected unknown app to fail, got ", code)
+	}
+	fake.Crash(app.UUID, "web", 1)
+	if code, response := apiExec(t, url, `{"service":"web","command":["echo"]}`); code != http.StatusInternalServerError {
+		t.Error("Expected a stopped service to fail, got ", code, response)
+	}
+}
+
+func TestAPIExecInteractive(t *testing.T) {
+	server, _, cleanup := newAPITestServer(t)
+	defer cleanup()
+
+	_, app := apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0"}`, apiTestPackage())
+	session := func(request string) (*net.TCPConn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		var buf bytes.Buffer
+		buf.WriteString("POST /application/" + app.UUID + "/exec HTTP/1.1\r\nHost: cappsd\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n")
+		buf.WriteString("Content-Type: application/json\r\nContent-Length: " + strconv.Itoa(len(request)) + "\r\n\r\n" + request)
+		conn.Write(buf.Bytes())
+		return conn.(*net.TCPConn), bufio.NewReader(conn)
+	}
+
+	conn, reader := session(`{"service":"web","command":["cat"]}`)
+	defer conn.Close()
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "tcp" {
+		t.Fatal("Expected the connection to be upgraded, got ", resp.Status)
+	}
+	conn.Write([]byte("ls -l\n"))
+	if line, _ := reader.ReadString('\n'); line != "ls -l\n" {
+		t.Error("Expected the terminal to echo input, got ", line)
+	}
+	conn.CloseWrite()
+	if rest, err := ioutil.ReadAll(reader); err != nil || len(rest) != 0 {
+		t.Error("Expected the session to end, got ", string(rest), err)
+	}
+
+	failed, reader := session(`{"service":"queue","command":["sh"]}`)
+	defer failed.Close()
+	if resp, err = http.ReadResponse(reader, nil); err != nil || resp.StatusCode != http.StatusInternalServerError {
+		t.Error("Expected an unknown service to fail before upgrading, got ", resp, err)
+	}
+}
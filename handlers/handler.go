@@ -1,8 +1,8 @@
 package handlers
 
 import (
+	"crypto/tls"
 	"encoding/json"
-	"log"
 	"net"
 	"net/http"
 	"os"
@@ -11,12 +11,17 @@ import (
 	"time"
 	"os/exec"
 	"path/filepath"
+	"io"
 	"io/ioutil"
 	"fmt"
+	"mime/multipart"
 
 	"github.com/gorilla/mux"
 
+	"github.build.ge.com/PredixEdgeOS/container-app-service/audit"
 	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/metrics"
 	"github.build.ge.com/PredixEdgeOS/container-app-service/provider"
 	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
 	"github.build.ge.com/PredixEdgeOS/container-app-service/utils"
@@ -24,15 +29,17 @@ import (
 
 //Constants ...
 const (
-	Ok       = "Ok"
-	Fail     = "Fail"
-	Deployed = "Deployed"
-	Running  = "Running"
-	Stopped  = "Stopped"
-	NoID     = "No ID in request"
-	GenTPMKeyCommandFmt = "tpm2tss-genkey -a rsa -s 2048 %s"
-	GenOpensslKeyCommandFmt = "openssl genrsa -out %s 2048"
-	GenTPMPubKeyCommandFmt = "openssl rsa -engine tpm2tss -inform engine -in %s -pubout -outform pem"
+	Ok                         = "Ok"
+	Fail                       = "Fail"
+	Deployed                   = "Deployed"
+	Running                    = "Running"
+	Stopped                    = "Stopped"
+	Degraded                   = "Degraded"
+	Unhealthy                  = "Unhealthy"
+	NoID                       = "No ID in request"
+	GenTPMKeyCommandFmt        = "tpm2tss-genkey -a rsa -s 2048 %s"
+	GenOpensslKeyCommandFmt    = "openssl genrsa -out %s 2048"
+	GenTPMPubKeyCommandFmt     = "openssl rsa -engine tpm2tss -inform engine -in %s -pubout -outform pem"
 	GenOpensslPubKeyCommandFmt = "openssl rsa -in %s -pubout -outform pem"
 )
 
@@ -59,34 +66,82 @@ type PubKeyResponse struct {
 
 //DeployResponse ...
 type DeployResponse struct {
-	UUID    string `json:"uuid"`
-	Name    string `json:"name"`
-	Version string `json:"version"`
-	Status  string `json:"status"`
-	Error   string `json:"error"`
+	UUID    string        `json:"uuid"`
+	Name    string        `json:"name"`
+	Version string        `json:"version"`
+	Images  []types.Image `json:"images,omitempty"`
+	Status  string        `json:"status"`
+	Error   string        `json:"error"`
+}
+
+//PruneResponse ...
+type PruneResponse struct {
+	Images         []string `json:"images"`
+	SpaceReclaimed int64    `json:"space_reclaimed"`
+	Status         string   `json:"status"`
+	Error          string   `json:"error"`
 }
 
 //AppDetailsResponse ...
 type AppDetailsResponse struct {
-	UUID       string            `json:"uuid"`
-	Name       string            `json:"name"`
-	Version    string            `json:"version"`
-	Containers []types.Container `json:"containers"`
-	Status     string            `json:"status"`
-	Error      string            `json:"error"`
+	UUID       string                         `json:"uuid"`
+	Name       string                         `json:"name"`
+	Version    string                         `json:"version"`
+	Containers []types.Container              `json:"containers"`
+	Services   map[string]types.ServiceStatus `json:"services,omitempty"`
+	Status     string                         `json:"status"`
+	Error      string                         `json:"error"`
+}
+
+//HealthResponse ...
+type HealthResponse struct {
+	UUID     string                         `json:"uuid"`
+	Health   string                         `json:"health"`
+	Services map[string]types.ServiceHealth `json:"services"`
+	Status   string                         `json:"status"`
+	Error    string                         `json:"error"`
+}
+
+//HistoryResponse ...
+type HistoryResponse struct {
+	UUID     string             `json:"uuid"`
+	Name     string             `json:"name"`
+	Version  string             `json:"version"`
+	Versions []types.AppVersion `json:"versions"`
+	Status   string             `json:"status"`
+	Error    string             `json:"error"`
 }
 
 //Handler ...
 type Handler struct {
 	cfg      config.Config
 	provider provider.Provider
+	jobs     *jobs
+	uploads  *uploads
+	metrics  *metrics.Registry
+	audit    *audit.Log
+	policy   *policy
 }
 
 //NewHandler ...
-func NewHandler(c config.Config) *Handler {
-	return &Handler{
+func NewHandler(c config.Config) (*Handler, error) {
+	authorization, err := newPolicy(c)
+	if err != nil {
+		return nil, err
+	}
+	p, err := provider.NewProvider(c)
+	if err != nil {
+		return nil, err
+	}
+	h := &Handler{
 		cfg:      c,
-		provider: provider.NewProvider(c)}
+		provider: p,
+		jobs:     newJobs(),
+		uploads:  newUploads(c.DataVolume+"/uploads", uploadRetention(c)),
+		audit:    audit.NewLog(c),
+		policy:   authorization}
+	h.metrics = h.newMetrics()
+	return h, nil
 }
 
 func (h *Handler) ping(w http.ResponseWriter, r *http.Request) {
@@ -115,6 +170,7 @@ func (h *Handler) getApplication(w http.ResponseWriter, r *http.Request) {
 			response.Name = details.Name
 			response.Version = details.Version
 			response.Containers = details.Containers
+			response.Services = details.Services
 		} else {
 			response.Status = Fail
 			response.Error = err.Error()
@@ -132,17 +188,28 @@ func (h *Handler) getApplication(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) deployAppGeneric(w http.ResponseWriter, r *http.Request, persistent bool) {
 	response := DeployResponse{Status: Fail, Error: ""}
 	var metadata types.Metadata
+	entry := auditEntry(r)
 	if err := r.ParseMultipartForm(0); err == nil {
 		if err := json.Unmarshal([]byte(r.FormValue("metadata")), &metadata); err == nil {
+			entry.Name, entry.Version = metadata.Name, metadata.Version
 			m := r.MultipartForm
 			artifacts := m.File["artifact"]
+			if r.FormValue("async") == "true" {
+				h.deployAsync(w, entry, metadata, artifacts, persistent)
+				return
+			}
 			for i := range artifacts {
 				if file, err := artifacts[i].Open(); err == nil {
 					defer file.Close()
-					if app, err := h.provider.Deploy(metadata, file, persistent); err == nil {
+					if entry.PackageHash, err = packageHash(file); err != nil {
+						response.Error = err.Error()
+						w.WriteHeader(http.StatusInternalServerError)
+					} else if app, err := h.provider.Deploy(metadata, file, persistent); err == nil {
+						entry.App = app.UUID
 						response.UUID = app.UUID
 						response.Name = app.Name
 						response.Version = app.Version
+						response.Images = app.Images
 						response.Status = Ok
 					} else {
 						response.Error = err.Error()
@@ -166,6 +233,165 @@ func (h *Handler) deployAppGeneric(w http.ResponseWriter, r *http.Request, persi
 	json.NewEncoder(w).Encode(response)
 }
 
+// deployAsync starts the deploy of a single artifact in the background and
+// answers with the job that can be polled for its progress.  The upload is
+// opened before returning, the server removes the multipart files once the
+// handler is done.
+func (h *Handler) deployAsync(w http.ResponseWriter, entry *types.AuditEntry, metadata types.Metadata, artifacts []*multipart.FileHeader, persistent bool) {
+	if file, ok := openAsyncArtifact(w, entry, artifacts); ok {
+		h.startDeployJob(w, entry, metadata, file, artifacts[0].Size, persistent, nil)
+	}
+}
+
+// upgradeAsync starts the upgrade of an app to a single artifact in the
+// background the same way as deployAsync
+func (h *Handler) upgradeAsync(w http.ResponseWriter, entry *types.AuditEntry, id string, metadata types.Metadata, artifacts []*multipart.FileHeader) {
+	file, ok := openAsyncArtifact(w, entry, artifacts)
+	if !ok {
+		return
+	}
+	h.startJob(w, entry, func(j *job) (*types.App, error) {
+		defer file.Close()
+		j.progress(types.PhaseUnpacking, 0, 0)
+		upload := &progressReader{reader: file, job: j, size: artifacts[0].Size}
+		return h.provider.Upgrade(id, metadata, upload)
+	}, nil, file)
+}
+
+// openAsyncArtifact opens the artifact of a request run as a job and hashes
+// it for the audit log, answering with the error if there is not exactly one
+// or it cannot be read
+func openAsyncArtifact(w http.ResponseWriter, entry *types.AuditEntry, artifacts []*multipart.FileHeader) (multipart.File, bool) {
+	response := JobResponse{Status: Fail, Error: ""}
+	if len(artifacts) != 1 {
+		response.Error = "Expected exactly one artifact"
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return nil, false
+	}
+	file, err := artifacts[0].Open()
+	if err != nil {
+		response.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return nil, false
+	}
+	if entry.PackageHash, err = packageHash(file); err != nil {
+		file.Close()
+		response.Error = err.Error()
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(response)
+		return nil, false
+	}
+	return file, true
+}
+
+// startDeployJob deploys file in the background and answers with the job,
+// file is closed once the deploy is done.  finished, if given, is called
+// with the result.  The audit entry of the request is recorded again with
+// the outcome of the deploy.
+func (h *Handler) startDeployJob(w http.ResponseWriter, entry *types.AuditEntry, metadata types.Metadata, file io.ReadCloser, size int64, persistent bool, finished func(DeployResponse)) {
+	h.startJob(w, entry, func(j *job) (*types.App, error) {
+		defer file.Close()
+		upload := &progressReader{reader: file, job: j, size: size}
+		return deployWithProgress(h.provider, metadata, upload, persistent, j.progress)
+	}, finished, file)
+}
+
+// startJob runs operation in the background and answers with the job, the
+// same way for deploys, upgrades and rollbacks.  finished, if given, is
+// called with the result and closer, if given, is closed when the job cannot
+// be started.
+func (h *Handler) startJob(w http.ResponseWriter, entry *types.AuditEntry, operation func(*job) (*types.App, error), finished func(DeployResponse), closer io.Closer) {
+	response := JobResponse{Status: Fail, Error: ""}
+	done := *entry
+	id, err := h.jobs.start(func(j *job) DeployResponse {
+		result := DeployResponse{UUID: entry.App, Status: Fail, Error: ""}
+		if app, err := operation(j); err == nil {
+			result.UUID = app.UUID
+			result.Name = app.Name
+			result.Version = app.Version
+			result.Images = app.Images
+			result.Status = Ok
+		} else {
+			result.Error = err.Error()
+		}
+		if finished != nil {
+			finished(result)
+		}
+		done.Time, done.Job, done.App, done.Error = time.Time{}, j.id, result.UUID, result.Error
+		done.Outcome, done.Code = types.AuditSuccess, http.StatusOK
+		if result.Status != Ok {
+			done.Outcome, done.Code = types.AuditFailure, http.StatusInternalServerError
+		}
+		h.record(done)
+		return result
+	})
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		response.Error = err.Error()
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	entry.Job = id
+	response.ID = id
+	response.Phase = PhaseQueued
+	response.Status = Ok
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) getJob(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	response, exists := h.jobs.get(vars["id"])
+	if !exists {
+		response = JobResponse{ID: vars["id"], Status: Fail, Error: "Job not found"}
+		w.WriteHeader(http.StatusNotFound)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) applicationHealth(w http.ResponseWriter, r *http.Request) {
+	response := HealthResponse{Status: Fail, Error: ""}
+	id := mux.Vars(r)["id"]
+	if checker, ok := h.provider.(provider.HealthChecker); ok {
+		if health, err := checker.Health(id); err == nil {
+			response.UUID = health.UUID
+			response.Health = health.Status
+			response.Services = health.Services
+			response.Status = Ok
+		} else {
+			response.Error = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	} else {
+		response.Error = "Health checks are not supported by the provider"
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) pruneImages(w http.ResponseWriter, r *http.Request) {
+	response := PruneResponse{Images: []string{}, Status: Fail, Error: ""}
+	if pruner, ok := h.provider.(provider.ImagePruner); ok {
+		if report, err := pruner.PruneImages(); err == nil {
+			response.Images = report.Images
+			response.SpaceReclaimed = report.SpaceReclaimed
+			response.Status = Ok
+		} else {
+			response.Error = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	} else {
+		response.Error = "Image pruning is not supported by the provider"
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
 func (h *Handler) deployApplication(w http.ResponseWriter, r *http.Request) {
 	h.deployAppGeneric(w, r, false)
 }
@@ -174,6 +400,113 @@ func (h *Handler) deployPersistentApplication(w http.ResponseWriter, r *http.Req
 	h.deployAppGeneric(w, r, true)
 }
 
+func (h *Handler) upgradeApplication(w http.ResponseWriter, r *http.Request) {
+	response := DeployResponse{Status: Fail, Error: ""}
+	var metadata types.Metadata
+	id, exists := mux.Vars(r)["id"]
+	if !exists {
+		response.Error = NoID
+		w.WriteHeader(http.StatusBadRequest)
+	} else if err := r.ParseMultipartForm(0); err == nil {
+		if err := json.Unmarshal([]byte(r.FormValue("metadata")), &metadata); err == nil {
+			entry := auditEntry(r)
+			entry.Version = metadata.Version
+			m := r.MultipartForm
+			artifacts := m.File["artifact"]
+			if r.FormValue("async") == "true" {
+				h.upgradeAsync(w, entry, id, metadata, artifacts)
+				return
+			}
+			if len(artifacts) == 1 {
+				if file, err := artifacts[0].Open(); err == nil {
+					defer file.Close()
+					if entry.PackageHash, err = packageHash(file); err != nil {
+						response.Error = err.Error()
+						w.WriteHeader(http.StatusInternalServerError)
+					} else if app, err := h.provider.Upgrade(id, metadata, file); err == nil {
+						response.UUID = app.UUID
+						response.Name = app.Name
+						response.Version = app.Version
+						response.Images = app.Images
+						response.Status = Ok
+					} else {
+						response.UUID = id
+						response.Error = err.Error()
+						w.WriteHeader(http.StatusInternalServerError)
+					}
+				} else {
+					response.Error = err.Error()
+					w.WriteHeader(http.StatusBadRequest)
+				}
+			} else {
+				response.Error = "Expected exactly one artifact"
+				w.WriteHeader(http.StatusBadRequest)
+			}
+			m.RemoveAll()
+		} else {
+			response.Error = err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	} else {
+		response.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) applicationHistory(w http.ResponseWriter, r *http.Request) {
+	response := HistoryResponse{Status: Fail, Error: ""}
+	vars := mux.Vars(r)
+	id, exists := vars["id"]
+	if exists {
+		if history, err := h.provider.History(id); err == nil {
+			response.Status = Ok
+			response.UUID = history.UUID
+			response.Name = history.Name
+			response.Version = history.Version
+			response.Versions = history.Versions
+		} else {
+			response.Error = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	} else {
+		response.Error = NoID
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) rollbackApplication(w http.ResponseWriter, r *http.Request) {
+	response := DeployResponse{Status: Fail, Error: ""}
+	vars := mux.Vars(r)
+	id, exists := vars["id"]
+	if exists && r.FormValue("async") == "true" {
+		h.startJob(w, auditEntry(r), func(j *job) (*types.App, error) {
+			j.progress(types.PhaseStarting, 0, 0)
+			return h.provider.Rollback(id, vars["version"])
+		}, nil, nil)
+		return
+	} else if exists {
+		response.UUID = id
+		if app, err := h.provider.Rollback(id, vars["version"]); err == nil {
+			response.Name = app.Name
+			response.Version = app.Version
+			response.Images = app.Images
+			response.Status = Ok
+		} else {
+			response.Error = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	} else {
+		response.Error = NoID
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
 func (h *Handler) restartApplication(w http.ResponseWriter, r *http.Request) {
 	response := BasicResponse{Status: Ok, Error: ""}
 
@@ -239,7 +572,16 @@ func (h *Handler) statusApplication(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 	id, exists := vars["id"]
-	if exists {
+	if checker, ok := h.provider.(provider.HealthChecker); ok && exists {
+		// Running, Degraded, Unhealthy or Stopped
+		if health, err := checker.Health(id); err == nil {
+			response.Status = health.Status
+		} else {
+			response.Status = Fail
+			response.Error = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	} else if exists {
 		if details, err := h.provider.GetApplication(id); err == nil {
 			running := true
 			UP := regexp.MustCompile("^Up")
@@ -330,13 +672,14 @@ func (h *Handler) killApplication(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) createKey(w http.ResponseWriter, r *http.Request) {
 	response := BasicResponse{Status: Ok, Error: ""}
-	log.Println("Provisioning new decryption key!")
+	logger := logging.FromContext(r.Context())
+	logger.Info("Provisioning new decryption key")
 
 	decoder := json.NewDecoder(r.Body)
 	var nameJson KeyName
 	err := decoder.Decode(&nameJson)
 	if err != nil {
-		log.Printf("Could not process request body:\n%v", err)
+		logger.Error("Could not process request body", "error", err)
 		response.Status = "FAIL"
 		response.Error = err.Error()
 		w.WriteHeader(http.StatusInternalServerError)
@@ -344,11 +687,11 @@ func (h *Handler) createKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var name = nameJson.Name
+	auditEntry(r).Detail = name
 
 	err = os.MkdirAll(filepath.Dir(h.cfg.KeyLocation), 0744)
 	if err != nil {
-		log.Printf("Could not create key directory (%s) due to error: \n%v\n",
-			filepath.Dir(h.cfg.KeyLocation), err)
+		logger.Error("Could not create key directory", "dir", filepath.Dir(h.cfg.KeyLocation), "error", err)
 		response.Status = "FAIL"
 		response.Error = err.Error()
 		w.WriteHeader(http.StatusInternalServerError)
@@ -357,7 +700,7 @@ func (h *Handler) createKey(w http.ResponseWriter, r *http.Request) {
 	}
 	err = ioutil.WriteFile(h.cfg.KeyName, []byte(name), 0644)
 	if err != nil {
-		log.Printf("Failed writing key name to file (%s): %v\n", h.cfg.KeyName, err)
+		logger.Error("Failed writing key name to file", "file", h.cfg.KeyName, "error", err)
 		response.Status = "FAIL"
 		response.Error = err.Error()
 		w.WriteHeader(http.StatusInternalServerError)
@@ -366,7 +709,7 @@ func (h *Handler) createKey(w http.ResponseWriter, r *http.Request) {
 	}
 	hasTPM, err := utils.HasTPM2()
 	if err != nil {
-		log.Printf("Error while attempting to detect TPM2.0 presence: %v\n", err)
+		logger.Error("Error while attempting to detect TPM2.0 presence", "error", err)
 		response.Status = "FAIL"
 		response.Error = err.Error()
 		w.WriteHeader(http.StatusInternalServerError)
@@ -375,15 +718,15 @@ func (h *Handler) createKey(w http.ResponseWriter, r *http.Request) {
 	}
 	genKeyCommand := fmt.Sprintf(GenOpensslKeyCommandFmt, h.cfg.KeyLocation)
 	if hasTPM {
-		log.Println("TPM detected, locking private key with TPM")
+		logger.Info("TPM detected, locking private key with TPM")
 		genKeyCommand = fmt.Sprintf(GenTPMKeyCommandFmt, h.cfg.KeyLocation)
 	} else {
-		log.Println("NO TPM FOUND, generating private key using openssl tools")
+		logger.Info("No TPM found, generating private key using openssl tools")
 	}
 	cmd := exec.Command("sh", "-c", genKeyCommand)
 	err = cmd.Run()
 	if err != nil {
-		log.Printf("Error generating private key: \n%v",  err)
+		logger.Error("Error generating private key", "error", err)
 		response.Status = "FAIL"
 		response.Error = err.Error()
 		w.WriteHeader(http.StatusInternalServerError)
@@ -394,84 +737,178 @@ func (h *Handler) createKey(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) hasKey(w http.ResponseWriter, r *http.Request) {
-	log.Println("Checking if key has been generated due to API request")
+	logger := logging.FromContext(r.Context())
+	logger.Debug("Checking if key has been generated due to API request")
 	if _, err := os.Stat(h.cfg.KeyLocation); err == nil {
-		log.Println("  Have key")
+		logger.Debug("Have key")
 		json.NewEncoder(w).Encode(HasKeyResponse{HasKey: true})
 	} else if os.IsNotExist(err) {
-		log.Println("  Do not have key")
+		logger.Debug("Do not have key")
 		json.NewEncoder(w).Encode(HasKeyResponse{HasKey: false})
 	} else {
-		log.Printf("hasKey returned an error: %s", err)
+		logger.Error("hasKey returned an error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(BasicResponse{Status: Fail, Error: err.Error()})
 	}
 }
 
 func (h *Handler) getKey(w http.ResponseWriter, r *http.Request) {
-	log.Println("Responding to request for public key from API")
+	logger := logging.FromContext(r.Context())
+	logger.Debug("Responding to request for public key from API")
 	if _, err := os.Stat(h.cfg.KeyLocation); err == nil {
 		genPubKeyCommand := fmt.Sprintf(GenOpensslPubKeyCommandFmt, h.cfg.KeyLocation)
 		hasTPM, err := utils.HasTPM2()
 		if err != nil {
-			log.Printf("TPM Detection returned an error: \n%v", err)
+			logger.Error("TPM detection returned an error", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(BasicResponse{Status: Fail, Error: err.Error()})
 		}
 		if hasTPM {
-			log.Println("TPM detected, generating public key from TPM locked private key.")
+			logger.Debug("TPM detected, generating public key from TPM locked private key")
 			genPubKeyCommand = fmt.Sprintf(GenTPMPubKeyCommandFmt, h.cfg.KeyLocation)
 		} else {
-			log.Printf("NO TPM FOUND, generating public key from openssl generated private key.")
+			logger.Debug("No TPM found, generating public key from openssl generated private key")
 		}
 		pubKeyBytes, err := exec.Command("sh", "-c", genPubKeyCommand).Output()
 		if err != nil {
-			log.Printf("Error generating public key: \n%v", err)
+			logger.Error("Error generating public key", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(BasicResponse{Status: Fail, Error: err.Error()})			
 		}
 		json.NewEncoder(w).Encode(PubKeyResponse{PubKey: string(pubKeyBytes)})
 	} else if os.IsNotExist(err) {
-		log.Printf("getKey returned an error: %s", err)
+		logger.Error("getKey returned an error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(BasicResponse{Status: Fail, Error: "Key does not exist"})
 	} else {
-		log.Printf("getKey returned an error: %s", err)
+		logger.Error("getKey returned an error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(BasicResponse{Status: Fail, Error: err.Error()})
 	}
 }
 
-func setupServer(cfg config.Config) *http.Server {
-	handler := NewHandler(cfg)
+// newRouter maps the REST API onto a handler
+func newRouter(handler *Handler) http.Handler {
 	router := mux.NewRouter()
 	router.HandleFunc("/ping", handler.ping).Methods("GET")
-	router.HandleFunc("/applications", handler.listApplications).Methods("GET")
-	router.HandleFunc("/persistent-applications", handler.listPersistentApplications).Methods("GET")
-	router.HandleFunc("/application/{id}", handler.getApplication).Methods("GET")
-	router.HandleFunc("/application/deploy", handler.deployApplication).Methods("POST")
-	router.HandleFunc("/application/deploy-persistent", handler.deployPersistentApplication).Methods("POST")
-	router.HandleFunc("/application/restart/{id}", handler.restartApplication).Methods("POST")
-	router.HandleFunc("/application/start/{id}", handler.startApplication).Methods("POST")
-	router.HandleFunc("/application/stop/{id}", handler.stopApplication).Methods("POST")
-	router.HandleFunc("/application/status/{id}", handler.statusApplication).Methods("GET")
-	router.HandleFunc("/application/purge/{id}", handler.purgeApplication).Methods("POST")
-	router.HandleFunc("/application/purge-persistent/{name}", handler.purgePersistentApplication).Methods("POST")
-	router.HandleFunc("/application/kill/{id}", handler.killApplication).Methods("POST")
-	router.HandleFunc("/provision/createKey", handler.createKey).Methods("POST")
-	router.HandleFunc("/provision/hasKey", handler.hasKey).Methods("GET")
-	router.HandleFunc("/provision/getKey", handler.getKey).Methods("GET")
-	server := &http.Server{
-		Handler:      router,
-		ReadTimeout:  time.Duration(cfg.ReadTimeout) * time.Second,
-		WriteTimeout: time.Duration(cfg.WriteTimeout) * time.Second,
-	}
-	return server
-}
-
-// Start the HTTP server to handle client requests
-func Start(cfg config.Config) {
-	server := setupServer(cfg)
+	router.HandleFunc("/metrics", handler.allow(permView, handler.serveMetrics().ServeHTTP)).Methods("GET")
+	router.HandleFunc("/applications", handler.allow(permView, handler.listApplications)).Methods("GET")
+	router.HandleFunc("/persistent-applications", handler.allow(permView, handler.listPersistentApplications)).Methods("GET")
+	router.HandleFunc("/application/{id}", handler.allow(permView, handler.getApplication)).Methods("GET")
+	router.HandleFunc("/application/{id}/health", handler.allow(permView, handler.applicationHealth)).Methods("GET")
+	router.HandleFunc("/application/{id}/logs", handler.allow(permView, handler.applicationLogs)).Methods("GET")
+	router.HandleFunc("/application/{id}/exec", handler.audited("exec", handler.allow(permOperate, handler.execApplication))).Methods("POST")
+	router.HandleFunc("/application/{id}/stats", handler.allow(permView, handler.applicationStats)).Methods("GET")
+	router.HandleFunc("/application/{id}/history", handler.allow(permView, handler.applicationHistory)).Methods("GET")
+	router.HandleFunc("/application/{id}/rollback/{version}", handler.audited("rollback", handler.allow(permOperate, handler.rollbackApplication))).Methods("POST")
+	router.HandleFunc("/application/deploy", handler.audited("deploy", handler.allow(permOperate, handler.deployApplication))).Methods("POST")
+	router.HandleFunc("/application/deploy-persistent", handler.audited("deploy-persistent", handler.allow(permOperate, handler.deployPersistentApplication))).Methods("POST")
+	router.HandleFunc("/application/upgrade/{id}", handler.audited("upgrade", handler.allow(permOperate, handler.upgradeApplication))).Methods("POST")
+	router.HandleFunc("/application/restart/{id}", handler.audited("restart", handler.allow(permOperate, handler.restartApplication))).Methods("POST")
+	router.HandleFunc("/application/start/{id}", handler.audited("start", handler.allow(permOperate, handler.startApplication))).Methods("POST")
+	router.HandleFunc("/application/stop/{id}", handler.audited("stop", handler.allow(permOperate, handler.stopApplication))).Methods("POST")
+	router.HandleFunc("/application/status/{id}", handler.allow(permView, handler.statusApplication)).Methods("GET")
+	router.HandleFunc("/application/purge/{id}", handler.audited("purge", handler.allow(permManage, handler.purgeApplication))).Methods("POST")
+	router.HandleFunc("/application/purge-persistent/{name}", handler.audited("purge-persistent", handler.allow(permManage, handler.purgePersistentApplication))).Methods("POST")
+	router.HandleFunc("/application/kill/{id}", handler.audited("kill", handler.allow(permManage, handler.killApplication))).Methods("POST")
+	router.HandleFunc("/events", handler.allow(permView, handler.streamEvents)).Methods("GET")
+	router.HandleFunc("/jobs/{id}", handler.allow(permView, handler.getJob)).Methods("GET")
+	router.HandleFunc("/images/prune", handler.audited("prune-images", handler.allow(permOperate, handler.pruneImages))).Methods("POST")
+	router.HandleFunc("/uploads", handler.allow(permOperate, handler.createUpload)).Methods("POST")
+	router.HandleFunc("/uploads/{id}", handler.allow(permView, handler.getUpload)).Methods("GET")
+	router.HandleFunc("/uploads/{id}", handler.allow(permOperate, handler.putUploadChunk)).Methods("PUT")
+	router.HandleFunc("/uploads/{id}", handler.allow(permOperate, handler.deleteUpload)).Methods("DELETE")
+	router.HandleFunc("/uploads/{id}/finalize", handler.audited("deploy-upload", handler.allow(permOperate, handler.finalizeUpload))).Methods("POST")
+	router.HandleFunc("/provision/createKey", handler.audited("create-key", handler.allow(permProvision, handler.createKey))).Methods("POST")
+	router.HandleFunc("/provision/hasKey", handler.allow(permProvision, handler.hasKey)).Methods("GET")
+	router.HandleFunc("/provision/getKey", handler.allow(permProvision, handler.getKey)).Methods("GET")
+	router.HandleFunc("/audit", handler.allow(permManage, handler.queryAudit)).Methods("GET")
+	router.HandleFunc("/logging/level", handler.allow(permView, handler.getLogLevel)).Methods("GET")
+	router.HandleFunc("/logging/level", handler.audited("set-log-level", handler.allow(permOperate, handler.setLogLevel))).Methods("PUT")
+	return withRequestID(instrument(router))
+}
+
+// servers are the servers of the service, all but api are optional
+type servers struct {
+	api     *http.Server
+	tls     *http.Server
+	metrics *http.Server
+}
+
+// setupServer creates the server of the API on the unix socket and, when
+// their addresses are configured, the server of the API on the TLS listener
+// and the server of the metrics
+func setupServer(cfg config.Config) (*servers, error) {
+	handler, err := NewHandler(cfg)
+	if err != nil {
+		return nil, err
+	}
+	router := newRouter(handler)
+	s := &servers{
+		api: &http.Server{
+			Handler:      router,
+			ReadTimeout:  time.Duration(cfg.ReadTimeout) * time.Second,
+			WriteTimeout: time.Duration(cfg.WriteTimeout) * time.Second,
+		},
+	}
+	if cfg.TLSListenAddress != "" {
+		tlsConf, err := tlsConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		s.tls = &http.Server{
+			Addr:         cfg.TLSListenAddress,
+			Handler:      router,
+			TLSConfig:    tlsConf,
+			ReadTimeout:  time.Duration(cfg.ReadTimeout) * time.Second,
+			WriteTimeout: time.Duration(cfg.WriteTimeout) * time.Second,
+			// Only HTTP/1.1 connections can be upgraded by exec
+			TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+		}
+	}
+	if cfg.MetricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", handler.allow(permView, handler.serveMetrics().ServeHTTP))
+		s.metrics = &http.Server{
+			Addr:         cfg.MetricsAddress,
+			Handler:      mux,
+			ReadTimeout:  time.Duration(cfg.ReadTimeout) * time.Second,
+			WriteTimeout: time.Duration(cfg.WriteTimeout) * time.Second,
+		}
+	}
+	return s, nil
+}
+
+// serveTCP serves on a TCP listener forever, with TLS when the server has a
+// TLS configuration
+func serveTCP(name string, server *http.Server) {
+	for {
+		utils.RetryWithBackoff(utils.NewSimpleBackoff(time.Second, time.Minute, 0.2, 2), func() error {
+			var err error
+			if server.TLSConfig != nil {
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				err = server.ListenAndServe()
+			}
+			logging.Error("Error running "+name+" listener", "address", server.Addr, "error", err)
+			return err
+		})
+	}
+}
+
+// Start the HTTP server to handle client requests.  Only failures to set up
+// the service are returned, the server itself is restarted forever.
+func Start(cfg config.Config) error {
+	s, err := setupServer(cfg)
+	if err != nil {
+		return err
+	}
+	if s.tls != nil {
+		go serveTCP("TLS", s.tls)
+	}
+	if s.metrics != nil {
+		go serveTCP("metrics", s.metrics)
+	}
 	for {
 		once := sync.Once{}
 		utils.RetryWithBackoff(utils.NewSimpleBackoff(time.Second, time.Minute, 0.2, 2), func() error {
@@ -481,18 +918,18 @@ func Start(cfg config.Config) {
 			cappsdSock, err := net.Listen("unix", cfg.ListenAddress)
 
 			if err != nil {
-				log.Println("Error binding socket - ", err)
+				logging.Error("Error binding socket", "error", err)
 				return err
 			}
 			err = os.Chmod(cfg.ListenAddress, 0760)
 			if err != nil {
-				log.Println("Error setting socket permissions - ", err)
+				logging.Error("Error setting socket permissions", "error", err)
 				return err
 			}
-			err = server.Serve(cappsdSock)
+			err = s.api.Serve(peerListener{cappsdSock})
 
 			once.Do(func() {
-				log.Println("Error running http api - ", err)
+				logging.Error("Error running http api", "error", err)
 			})
 
 			return err
@@ -28,7 +28,10 @@ var idSlice []string
 var handler *Handler
 
 func setupServerInTest(cfg config.Config) *http.Server {
-	handler = NewHandler(cfg)
+	var err error
+	if handler, err = NewHandler(cfg); err != nil {
+		log.Fatalln("Failed to create handler - ", err)
+	}
 	router := mux.NewRouter()
 	router.HandleFunc("/ping", handler.ping).Methods("GET")
 	router.HandleFunc("/applications", handler.listApplications).Methods("GET")
@@ -92,9 +95,9 @@ func Teardown(t *testing.T) error {
 func TestNewHandler(t *testing.T) {
 	cfg, err := config.NewConfig(configFilePath)
 	if err == nil {
-		handler := NewHandler(cfg)
-		if handler == nil {
-			t.Error("Failed to create new handler!")
+		handler, err := NewHandler(cfg)
+		if handler == nil || err != nil {
+			t.Error("Failed to create new handler!", err)
 			t.Fail()
 		}
 		handler = nil
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/provider"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/utils"
+)
+
+// Job phases besides the deploy phases reported by providers
+const (
+	PhaseQueued = "queued"
+	PhaseDone   = "done"
+	PhaseFailed = "failed"
+)
+
+// Share of the overall progress each phase starts at.  Unpacking advances
+// with the bytes read from the upload and image loading with each image.
+const (
+	unpackPercent  = 0
+	decryptPercent = 40
+	imagesPercent  = 45
+	startPercent   = 90
+)
+
+// jobRetention is how long finished jobs can be polled
+var jobRetention = time.Hour
+
+// JobResponse ...
+type JobResponse struct {
+	ID      string          `json:"id"`
+	Phase   string          `json:"phase"`
+	Detail  string          `json:"detail"`
+	Percent int             `json:"percent"`
+	Result  *DeployResponse `json:"result,omitempty"`
+	Status  string          `json:"status"`
+	Error   string          `json:"error"`
+}
+
+// job tracks a deploy running in the background
+type job struct {
+	lock     sync.Mutex
+	id       string
+	phase    string
+	detail   string
+	percent  int
+	result   *DeployResponse
+	finished time.Time
+}
+
+// jobs keeps all background deploys until jobRetention after they finish
+type jobs struct {
+	lock sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobs() *jobs {
+	return &jobs{jobs: make(map[string]*job)}
+}
+
+// start runs deploy in the background and returns its job ID
+func (j *jobs) start(deploy func(*job) DeployResponse) (string, error) {
+	id, err := utils.NewUUID()
+	if err != nil {
+		return "", err
+	}
+	current := &job{id: id, phase: PhaseQueued}
+
+	j.lock.Lock()
+	for old := range j.jobs {
+		if finished := j.jobs[old].finishedAt(); !finished.IsZero() && time.Since(finished) > jobRetention {
+			delete(j.jobs, old)
+		}
+	}
+	j.jobs[id] = current
+	j.lock.Unlock()
+
+	go func() {
+		result := deploy(current)
+		current.lock.Lock()
+		defer current.lock.Unlock()
+		current.result = &result
+		current.finished = time.Now()
+		current.detail = ""
+		if result.Status == Ok {
+			current.phase = PhaseDone
+			current.percent = 100
+		} else {
+			current.phase = PhaseFailed
+		}
+	}()
+	return id, nil
+}
+
+// get reports the state of a job
+func (j *jobs) get(id string) (JobResponse, bool) {
+	j.lock.Lock()
+	current, exists := j.jobs[id]
+	j.lock.Unlock()
+	if !exists {
+		return JobResponse{}, false
+	}
+
+	current.lock.Lock()
+	defer current.lock.Unlock()
+	return JobResponse{
+		ID:      current.id,
+		Phase:   current.phase,
+		Detail:  current.detail,
+		Percent: current.percent,
+		Result:  current.result,
+		Status:  Ok,
+	}, true
+}
+
+func (j *job) finishedAt() time.Time {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.finished
+}
+
+// progress is the types.Progress callback of the job
+func (j *job) progress(phase string, current int, total int) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.phase = phase
+	j.detail = ""
+	switch phase {
+	case types.PhaseDecrypting:
+		j.percent = decryptPercent
+	case types.PhaseLoadingImages:
+		j.detail = fmt.Sprintf("image %d of %d", current, total)
+		if total > 0 {
+			j.percent = imagesPercent + (startPercent-imagesPercent)*(current-1)/total
+		}
+	case types.PhaseStarting:
+		j.percent = startPercent
+	}
+}
+
+// read advances the unpacking progress as the upload is read
+func (j *job) read(done int64, size int64) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if size > 0 && (j.phase == PhaseQueued || j.phase == types.PhaseUnpacking) {
+		j.percent = unpackPercent + int(int64(decryptPercent-unpackPercent)*done/size)
+	}
+}
+
+// progressReader reports the bytes read from an upload to a job
+type progressReader struct {
+	reader io.Reader
+	job    *job
+	size   int64
+	done   int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.done += int64(n)
+	r.job.read(r.done, r.size)
+	return n, err
+}
+
+// deployWithProgress deploys through the provider, reporting progress when
+// the provider supports it
+func deployWithProgress(p provider.Provider, metadata types.Metadata, file io.Reader, persistent bool, progress types.Progress) (*types.App, error) {
+	if deployer, ok := p.(provider.ProgressDeployer); ok {
+		return deployer.DeployWithProgress(metadata, file, persistent, progress)
+	}
+	progress.Report(types.PhaseUnpacking, 0, 0)
+	return p.Deploy(metadata, file, persistent)
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+func TestJobProgress(t *testing.T) {
+	j := &job{phase: PhaseQueued}
+	j.read(50, 100)
+	if j.percent != decryptPercent/2 {
+		t.Error("Expected half of the unpacking share, got ", j.percent)
+	}
+	j.progress(types.PhaseDecrypting, 0, 0)
+	j.read(100, 100)
+	if j.percent != decryptPercent {
+		t.Error("Expected reads after unpacking to be ignored, got ", j.percent)
+	}
+	j.progress(types.PhaseLoadingImages, 3, 4)
+	if j.detail != "image 3 of 4" || j.percent <= imagesPercent || j.percent >= startPercent {
+		t.Error("Unexpected image loading progress: ", j.detail, j.percent)
+	}
+	j.progress(types.PhaseStarting, 0, 0)
+	if j.percent != startPercent || j.detail != "" {
+		t.Error("Unexpected starting progress: ", j.detail, j.percent)
+	}
+}
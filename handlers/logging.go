@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/utils"
+)
+
+// RequestIDHeader carries the correlation id of a request and its response
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID matches the ids of clients that are kept as they are
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// LogLevelRequest ...
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// LogLevelResponse ...
+type LogLevelResponse struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// withRequestID gives every request a correlation id, the one the client sent
+// in the X-Request-ID header or a new one, returns it in the response header
+// and adds it to the lines logged for the request
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id, _ = utils.NewUUID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := logging.NewContext(r.Context(), logging.With("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// getLogLevel reports the level and the format of the service log
+func (h *Handler) getLogLevel(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(LogLevelResponse{
+		Level:  logging.GetLevel().String(),
+		Format: logging.Format(),
+		Status: Ok,
+	})
+}
+
+// setLogLevel changes the level of the service log until it restarts
+func (h *Handler) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	response := LogLevelResponse{Level: logging.GetLevel().String(), Format: logging.Format(), Status: Fail}
+	var request LogLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		response.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	level, err := logging.ParseLevel(request.Level)
+	if err != nil {
+		response.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	logging.SetLevel(level)
+	logging.FromContext(r.Context()).Info("Log level changed", "level", level)
+	response.Level = level.String()
+	response.Status = Ok
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+)
+
+func putLogLevel(t *testing.T, url string, body string) (int, LogLevelResponse) {
+	req, _ := http.NewRequest("PUT", url+"/logging/level", strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var response LogLevelResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	return resp.StatusCode, response
+}
+
+func TestAPILogging(t *testing.T) {
+	server, _, cleanup := newAPITestServer(t)
+	defer cleanup()
+	var out bytes.Buffer
+	saved := logging.GetLevel()
+	logging.SetOutput(&out)
+	defer func() {
+		logging.SetOutput(os.Stderr)
+		logging.SetLevel(saved)
+	}()
+
+	if code, response := putLogLevel(t, server.URL, `{"level":"verbose"}`); code != http.StatusBadRequest || response.Status != Fail || response.Level != saved.String() {
+		t.Error("Expected an invalid level to be rejected, got ", code, response)
+	}
+	if code, response := putLogLevel(t, server.URL, `{"level":"debug"}`); code != http.StatusOK || response.Status != Ok || response.Level != "debug" {
+		t.Error("Expected the level to change, got ", code, response)
+	}
+	resp, err := http.Get(server.URL + "/logging/level")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response LogLevelResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	resp.Body.Close()
+	if response.Level != "debug" || response.Format != logging.Format() || logging.GetLevel() != logging.DebugLevel {
+		t.Error("Expected the debug level to be reported, got ", response)
+	}
+
+	// Requests keep the ids of clients and get new ones otherwise
+	req, _ := http.NewRequest("GET", server.URL+"/ping", nil)
+	req.Header.Set(RequestIDHeader, "client-id-1")
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get(RequestIDHeader) != "client-id-1" {
+		t.Error("Expected the request id of the client, got ", resp.Header.Get(RequestIDHeader))
+	}
+	req.Header.Set(RequestIDHeader, "not a valid id")
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	generated := resp.Header.Get(RequestIDHeader)
+	if generated == "" || generated == "not a valid id" {
+		t.Error("Expected a new request id, got ", generated)
+	}
+	if !strings.Contains(out.String(), "msg=Request request_id=client-id-1 method=GET route=/ping code=200") ||
+		!strings.Contains(out.String(), "request_id="+generated) {
+		t.Error("Expected requests to be logged with their ids, got ", out.String())
+	}
+}
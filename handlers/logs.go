@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/provider"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// parseSince reads the since parameter of a log request: an RFC 3339 time,
+// a Unix timestamp or a duration back from now such as 10m
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, since); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseInt(since, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if d, err := time.ParseDuration(since); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, errors.New("Invalid since " + since)
+}
+
+// logOptions reads the service, tail, since, timestamps and follow
+// parameters of a log request
+func logOptions(r *http.Request) (types.LogOptions, error) {
+	query := r.URL.Query()
+	options := types.LogOptions{Service: query.Get("service"), Tail: -1}
+	var err error
+	if tail := query.Get("tail"); tail != "" && tail != "all" {
+		if options.Tail, err = strconv.Atoi(tail); err != nil || options.Tail < 0 {
+			return options, errors.New("Invalid tail " + tail)
+		}
+	}
+	if options.Since, err = parseSince(query.Get("since"), time.Now()); err != nil {
+		return options, err
+	}
+	for name, flag := range map[string]*bool{"timestamps": &options.Timestamps, "follow": &options.Follow} {
+		if value := query.Get(name); value != "" {
+			if *flag, err = strconv.ParseBool(value); err != nil {
+				return options, errors.New("Invalid " + name + " " + value)
+			}
+		}
+	}
+	return options, nil
+}
+
+// logResponse tells if the response was started by writing or flushing it
+type logResponse struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (l *logResponse) Write(p []byte) (int, error) {
+	l.started = true
+	return l.w.Write(p)
+}
+
+func (l *logResponse) flush() {
+	if flusher, ok := l.w.(http.Flusher); ok {
+		l.started = true
+		flusher.Flush()
+	}
+}
+
+// applicationLogs writes the logs of the containers of an application as
+// plain text lines, "<service>_<n> | <line>", with " (stderr)" after the
+// container for lines written to stderr.  Followed logs are flushed line by
+// line until the client disconnects.
+func (h *Handler) applicationLogs(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	reader, ok := h.provider.(provider.LogReader)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(BasicResponse{Status: Fail, Error: "Logs are not supported by the provider"})
+		return
+	}
+	options, err := logOptions(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(BasicResponse{Status: Fail, Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	out := &logResponse{w: w}
+	var flush func()
+	if options.Follow {
+		flush = out.flush
+		endlessResponse(w)
+	}
+	if err = reader.Logs(r.Context(), id, options, provider.NewLogWriter(out, flush)); err != nil && !out.started {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(BasicResponse{Status: Fail, Error: err.Error()})
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2018, 6, 1, 10, 0, 0, 0, time.UTC)
+	for since, expected := range map[string]time.Time{
+		"":                     {},
+		"2018-06-01T09:00:00Z": now.Add(-time.Hour),
+		"1527843600":           now.Add(-time.Hour),
+		"90m":                  now.Add(-90 * time.Minute),
+	} {
+		if parsed, err := parseSince(since, now); err != nil || !parsed.Equal(expected) {
+			t.Error("Expected ", since, " to be ", expected, " got ", parsed, err)
+		}
+	}
+	for _, since := range []string{"yesterday", "-5m"} {
+		if _, err := parseSince(since, now); err == nil {
+			t.Error("Expected ", since, " to be rejected")
+		}
+	}
+}
+
+func TestAPILogs(t *testing.T) {
+	server, fake, cleanup := newAPITestServer(t)
+	defer cleanup()
+
+	_, app := apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0"}`, apiTestPackage())
+	fake.WriteLog(app.UUID, "web", "stdout", "listening")
+	fake.WriteLog(app.UUID, "db", "stdout", "ready")
+	fake.WriteLog(app.UUID, "web", "stderr", "oops")
+	fake.WriteLog(app.UUID, "web", "stdout", "served")
+
+	logs := func(query string) (int, string) {
+		resp, err := http.Get(server.URL + "/application/" + app.UUID + "/logs" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if code, body := logs(""); code != http.StatusOK || body != "web_1 | listening\ndb_1 | ready\nweb_1 (stderr) | oops\nweb_1 | served\n" {
+		t.Error("Expected every line, got ", code, body)
+	}
+	if _, body := logs("?service=web&tail=2&timestamps=true"); !strings.HasSuffix(body, " served\n") || strings.Count(body, "\n") != 2 || strings.Contains(body, "listening") {
+		t.Error("Expected the last two lines of web with timestamps, got ", body)
+	}
+	if _, body := logs("?since=1m&service=db"); body != "db_1 | ready\n" {
+		t.Error("Expected recent db lines, got ", body)
+	}
+	for _, query := range []string{"?tail=-1", "?since=later", "?follow=maybe"} {
+		if code, _ := logs(query); code != http.StatusBadRequest {
+			t.Error("Expected ", query, " to be rejected, got ", code)
+		}
+	}
+	if code, _ := logs("?service=queue"); code != http.StatusInternalServerError {
+		t.Error("Expected unknown service to fail, got ", code)
+	}
+
+	// Followed logs stream new lines until the app stops
+	resp, err := http.Get(server.URL + "/application/" + app.UUID + "/logs?follow=true&tail=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	fake.WriteLog(app.UUID, "db", "stdout", "checkpoint")
+	reader := bufio.NewReader(resp.Body)
+	if line, _ := reader.ReadString('\n'); line != "db_1 | checkpoint\n" {
+		t.Error("Expected the new line, got ", line)
+	}
+	apiCall(t, "POST", server.URL+"/application/stop/"+app.UUID)
+	if rest, _ := ioutil.ReadAll(reader); len(rest) != 0 {
+		t.Error("Expected the stream to end, got ", string(rest))
+	}
+}
+
+func TestAPILogsOutliveWriteTimeout(t *testing.T) {
+	server, fake, cleanup := newAPITestServerWithConfig(t, config.Config{WriteTimeout: 1})
+	defer cleanup()
+
+	_, app := apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0"}`, apiTestPackage())
+	resp, err := http.Get(server.URL + "/application/" + app.UUID + "/logs?follow=true&tail=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	time.Sleep(1500 * time.Millisecond)
+	fake.WriteLog(app.UUID, "db", "stdout", "checkpoint")
+	if line, _ := bufio.NewReader(resp.Body).ReadString('\n'); line != "db_1 | checkpoint\n" {
+		t.Error("Expected followed logs to outlive the write timeout, got ", line)
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/metrics"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/provider"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// The metrics of the API
+var (
+	apiRequests = metrics.NewCounter("cappsd_http_requests_total",
+		"API requests by route, method and status code.", "route", "method", "code")
+	apiDuration = metrics.NewHistogram("cappsd_http_request_duration_seconds",
+		"Time taken to answer API requests, by route and method.", nil, "route", "method")
+)
+
+// statsCacheTTL is how long the container usage sampled for a scrape is
+// reused, as sampling takes a second
+var statsCacheTTL = 5 * time.Second
+
+// statusRecorder remembers the status code of a response.  It is a Flusher
+// and a Hijacker, as the streaming handlers need, whether or not the
+// response it wraps is.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the connection of the response
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Connection upgrades are not supported")
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err == nil {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
+}
+
+// instrument counts, times and logs the requests to the routes of router.  Requests
+// are labelled with the path template of their route, so the metrics of an
+// application do not depend on its id.
+func instrument(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := "unmatched"
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if template, err := match.Route.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		router.ServeHTTP(recorder, r)
+		duration := time.Since(start)
+		apiRequests.Inc(route, r.Method, strconv.Itoa(recorder.status))
+		apiDuration.Observe(duration.Seconds(), route, r.Method)
+		logging.FromContext(r.Context()).Debug("Request", "method", r.Method, "route", route, "code", recorder.status, "duration", duration)
+	})
+}
+
+// appUsage is the usage of the containers of an app and its name
+type appUsage struct {
+	name  string
+	stats *types.AppStats
+}
+
+// containerUsage caches the usage of the containers of every app for
+// scrapes, by app UUID as several versions of an app may be deployed
+type containerUsage struct {
+	lock    sync.Mutex
+	sampled time.Time
+	stats   map[string]appUsage
+}
+
+// sample returns the usage of the containers of every app, sampling the apps
+// in parallel when the cached usage is too old
+func (c *containerUsage) sample(reader provider.StatsReader, apps []types.App) map[string]appUsage {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if time.Since(c.sampled) < statsCacheTTL {
+		return c.stats
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	sampled := make([]*types.AppStats, len(apps))
+	var wait sync.WaitGroup
+	for i, app := range apps {
+		wait.Add(1)
+		go func(i int, id string) {
+			defer wait.Done()
+			sampled[i], _ = reader.Stats(ctx, id)
+		}(i, app.UUID)
+	}
+	wait.Wait()
+	c.stats = make(map[string]appUsage)
+	for i, stats := range sampled {
+		if stats != nil {
+			c.stats[apps[i].UUID] = appUsage{name: apps[i].Name, stats: stats}
+		}
+	}
+	c.sampled = time.Now()
+	return c.stats
+}
+
+// newMetrics creates the metrics collected from the provider when scraped:
+// the number of apps in each state and the usage of their containers
+func (h *Handler) newMetrics() *metrics.Registry {
+	registry := metrics.NewRegistry()
+	registry.NewGaugeFunc("cappsd_apps", "Deployed apps by state.", []string{"state"}, func(set func(float64, ...string)) {
+		counts := make(map[string]float64)
+		checker, checked := h.provider.(provider.HealthChecker)
+		if checked {
+			for _, state := range []string{types.HealthRunning, types.HealthDegraded, types.HealthUnhealthy, types.HealthStopped} {
+				counts[strings.ToLower(state)] = 0
+			}
+		} else {
+			counts["active"], counts["inactive"] = 0, 0
+		}
+		for _, app := range h.provider.ListApplications().Apps {
+			switch {
+			case checked:
+				if health, err := checker.Health(app.UUID); err == nil {
+					counts[strings.ToLower(health.Status)]++
+				}
+			case strings.EqualFold(app.Active, "yes"):
+				counts["active"]++
+			default:
+				counts["inactive"]++
+			}
+		}
+		for state, count := range counts {
+			set(count, state)
+		}
+	})
+
+	reader, ok := h.provider.(provider.StatsReader)
+	if !ok {
+		return registry
+	}
+	usage := &containerUsage{}
+	gauge := func(name string, help string, value func(types.ContainerStats) float64) {
+		registry.NewGaugeFunc(name, help, []string{"app", "id", "container"}, func(set func(float64, ...string)) {
+			for id, app := range usage.sample(reader, h.provider.ListApplications().Apps) {
+				for _, container := range app.stats.Containers {
+					set(value(container), app.name, id, container.Name)
+				}
+			}
+		})
+	}
+	gauge("cappsd_container_cpu_percent", "CPU usage of containers, relative to one CPU.", func(c types.ContainerStats) float64 {
+		return c.CPUPercent
+	})
+	gauge("cappsd_container_memory_usage_bytes", "Memory used by containers, without the page cache.", func(c types.ContainerStats) float64 {
+		return float64(c.MemoryUsage)
+	})
+	gauge("cappsd_container_memory_limit_bytes", "Memory containers may use.", func(c types.ContainerStats) float64 {
+		return float64(c.MemoryLimit)
+	})
+	return registry
+}
+
+// serveMetrics is the handler of /metrics
+func (h *Handler) serveMetrics() http.Handler {
+	return metrics.Handler(metrics.DefaultRegistry, h.metrics)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/metrics"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+func scrape(t *testing.T, url string) string {
+	resp, err := http.Get(url + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != metrics.ContentType {
+		t.Error("Expected the Prometheus text format, got ", resp.Header.Get("Content-Type"))
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestAPIMetrics(t *testing.T) {
+	server, fake, cleanup := newAPITestServer(t)
+	defer cleanup()
+	saved := statsCacheTTL
+	defer func() {
+		statsCacheTTL = saved
+	}()
+	statsCacheTTL = 0
+
+	deploys := apiRequests.Value("/application/deploy", "POST", "200")
+	missing := apiRequests.Value("/application/{id}", "GET", "500")
+	_, app := apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0","monitor":"yes"}`, apiTestPackage())
+	_, other := apiDeploy(t, server.URL, `{"name":"other","version":"1.0"}`, apiTestPackage())
+	apiCall(t, "GET", server.URL+"/application/unknown")
+	apiCall(t, "GET", server.URL+"/no/such/route")
+	if apiRequests.Value("/application/deploy", "POST", "200") != deploys+2 || apiRequests.Value("/application/{id}", "GET", "500") != missing+1 {
+		t.Error("Expected requests to be counted by route")
+	}
+	if apiDuration.Count("unmatched", "GET") == 0 {
+		t.Error("Expected requests to unknown routes to be timed")
+	}
+
+	fake.SetUsage(app.UUID, "web", types.ResourceUsage{CPUPercent: 12.5, MemoryUsage: 1024, MemoryLimit: 4096})
+	fake.Crash(app.UUID, "web", 1)
+	apiCall(t, "POST", server.URL+"/application/stop/"+app.UUID)
+	body := scrape(t, server.URL)
+	for _, expected := range []string{
+		`cappsd_http_requests_total{route="/application/deploy",method="POST",code="200"} `,
+		`cappsd_deploy_phase_duration_seconds_count{phase="starting"} `,
+		`cappsd_monitor_restarts_total{app="testapp",service="web"} `,
+		`cappsd_apps{state="running"} 1`,
+		`cappsd_apps{state="stopped"} 1`,
+		`cappsd_apps{state="degraded"} 0`,
+		`cappsd_container_memory_usage_bytes{app="other",id="` + other.UUID + `",container="web_1"} 0`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Error("Expected ", expected, " in\n", body)
+		}
+	}
+
+	// Versions of an app deployed side by side are told apart
+	apiCall(t, "POST", server.URL+"/application/start/"+app.UUID)
+	_, upgrade := apiDeploy(t, server.URL, `{"name":"testapp","version":"2.0"}`, apiTestPackage())
+	fake.SetUsage(upgrade.UUID, "web", types.ResourceUsage{CPUPercent: 25})
+	body = scrape(t, server.URL)
+	for _, expected := range []string{
+		`cappsd_container_cpu_percent{app="testapp",id="` + app.UUID + `",container="web_1"} 12.5`,
+		`cappsd_container_memory_limit_bytes{app="testapp",id="` + app.UUID + `",container="web_1"} 4096`,
+		`cappsd_container_cpu_percent{app="testapp",id="` + upgrade.UUID + `",container="web_1"} 25`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Error("Expected ", expected, " in\n", body)
+		}
+	}
+}
+
+func TestStatusRecorder(t *testing.T) {
+	recorder := &statusRecorder{ResponseWriter: httptest.NewRecorder(), status: http.StatusOK}
+	var w http.ResponseWriter = recorder
+	if _, ok := w.(http.Flusher); !ok {
+		t.Error("Expected the recorder to flush")
+	}
+	if _, _, err := w.(http.Hijacker).Hijack(); err == nil {
+		t.Error("Expected hijacking a recorder to fail")
+	}
+	w.WriteHeader(http.StatusTeapot)
+	if recorder.status != http.StatusTeapot {
+		t.Error("Expected the status to be recorded, got ", recorder.status)
+	}
+}
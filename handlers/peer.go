@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// peerListener identifies the process at the other end of each connection
+// accepted on the unix socket.  The http server only passes the remote
+// address of a connection on to its requests, so the credentials are made
+// the remote address, which callerOf reads back from a request.
+type peerListener struct {
+	net.Listener
+}
+
+func (l peerListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn, nil
+	}
+	caller, err := peerCredentials(unixConn)
+	if err != nil {
+		return conn, nil
+	}
+	return &peerConn{Conn: conn, addr: peerAddr(*caller)}, nil
+}
+
+// peerConn is a connection whose remote address is its peer's credentials
+type peerConn struct {
+	net.Conn
+	addr peerAddr
+}
+
+func (c *peerConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// peerAddr is the address of a local process on the unix socket
+type peerAddr types.Caller
+
+const peerAddrFormat = "pid=%d,uid=%d,gid=%d"
+
+func (a peerAddr) Network() string {
+	return "unix"
+}
+
+func (a peerAddr) String() string {
+	return fmt.Sprintf(peerAddrFormat, a.PID, a.UID, a.GID)
+}
+
+// callerOf returns the process that sent a request on the unix socket, nil
+// for requests from elsewhere
+func callerOf(remoteAddr string) *types.Caller {
+	var caller types.Caller
+	if n, err := fmt.Sscanf(remoteAddr, peerAddrFormat, &caller.PID, &caller.UID, &caller.GID); err != nil || n != 3 {
+		return nil
+	}
+	return &caller
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net"
+	"syscall"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// peerCredentials asks the kernel for the process at the other end of a
+// unix socket connection
+func peerCredentials(conn *net.UnixConn) (*types.Caller, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+	return &types.Caller{UID: int(cred.Uid), GID: int(cred.Gid), PID: int(cred.Pid)}, nil
+}
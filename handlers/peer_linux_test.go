@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+func TestPeerListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappsd-peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "cappsd.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(callerOf(r.RemoteAddr))
+	})}
+	go server.Serve(peerListener{listener})
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network string, addr string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var caller *types.Caller
+	json.NewDecoder(resp.Body).Decode(&caller)
+	if caller == nil || caller.UID != os.Getuid() || caller.GID != os.Getgid() || caller.PID != os.Getpid() {
+		t.Error("Expected the credentials of the test, got ", caller)
+	}
+	if callerOf("127.0.0.1:5000") != nil {
+		t.Error("Expected no caller for TCP requests")
+	}
+}
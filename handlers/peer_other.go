@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package handlers
+
+import (
+	"errors"
+	"net"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// peerCredentials is only supported on Linux
+func peerCredentials(conn *net.UnixConn) (*types.Caller, error) {
+	return nil, errors.New("Peer credentials are not supported on this platform")
+}
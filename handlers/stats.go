@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/provider"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// statsInterval is how often streamed stats are sampled
+var statsInterval = 2 * time.Second
+
+// StatsResponse ...
+type StatsResponse struct {
+	UUID       string                 `json:"uuid"`
+	Time       time.Time              `json:"time"`
+	Containers []types.ContainerStats `json:"containers"`
+	Total      types.ResourceUsage    `json:"total"`
+	Status     string                 `json:"status"`
+	Error      string                 `json:"error"`
+}
+
+// applicationStats reports the resource usage of the running containers of
+// an application.  With stream=true it writes a new report on its own line
+// every statsInterval until the client disconnects or sampling fails.
+func (h *Handler) applicationStats(w http.ResponseWriter, r *http.Request) {
+	response := StatsResponse{Containers: []types.ContainerStats{}, Status: Fail, Error: ""}
+	id := mux.Vars(r)["id"]
+	reader, ok := h.provider.(provider.StatsReader)
+	if !ok {
+		response.Error = "Stats are not supported by the provider"
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	stream := false
+	if value := r.URL.Query().Get("stream"); value != "" {
+		var err error
+		if stream, err = strconv.ParseBool(value); err != nil {
+			response.Error = "Invalid stream " + value
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+	}
+	flusher, _ := w.(http.Flusher)
+	if stream {
+		endlessResponse(w)
+	}
+
+	for sent := false; ; sent = true {
+		stats, err := reader.Stats(r.Context(), id)
+		if err != nil {
+			if sent {
+				return
+			}
+			response.Error = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		response = StatsResponse{
+			UUID:       stats.UUID,
+			Time:       stats.Time,
+			Containers: stats.Containers,
+			Total:      stats.Total,
+			Status:     Ok,
+		}
+		if err = json.NewEncoder(w).Encode(response); err != nil || !stream {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(statsInterval):
+		}
+	}
+}
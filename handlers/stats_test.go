@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+func apiStats(t *testing.T, url string) (int, StatsResponse) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var response StatsResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	return resp.StatusCode, response
+}
+
+func TestAPIStats(t *testing.T) {
+	server, fake, cleanup := newAPITestServer(t)
+	defer cleanup()
+	saved := statsInterval
+	defer func() {
+		statsInterval = saved
+	}()
+	statsInterval = 10 * time.Millisecond
+
+	_, app := apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0"}`, apiTestPackage())
+	fake.SetUsage(app.UUID, "web", types.ResourceUsage{CPUPercent: 12.5, MemoryUsage: 64, MemoryLimit: 256, NetworkRx: 10})
+	fake.SetUsage(app.UUID, "db", types.ResourceUsage{CPUPercent: 50, MemoryUsage: 192, MemoryLimit: 256, BlockWrite: 4096})
+	url := server.URL + "/application/" + app.UUID + "/stats"
+
+	code, stats := apiStats(t, url)
+	if code != http.StatusOK || len(stats.Containers) != 2 || stats.Time.IsZero() {
+		t.Fatal("Expected the stats of both containers, got ", code, stats)
+	}
+	if total := stats.Total; total.CPUPercent != 62.5 || total.MemoryUsage != 256 || total.MemoryPercent != 50 || total.NetworkRx != 10 || total.BlockWrite != 4096 {
+		t.Error("Expected the total usage, got ", total)
+	}
+	fake.Crash(app.UUID, "web", 1)
+	if _, stats = apiStats(t, url); len(stats.Containers) != 1 || stats.Containers[0].Service != "db" || stats.Total.CPUPercent != 50 {
+		t.Error("Expected only running containers, got ", stats)
+	}
+	if code, _ = apiStats(t, url+"?stream=often"); code != http.StatusBadRequest {
+		t.Error("Expected invalid stream to be rejected, got ", code)
+	}
+	if code, stats = apiStats(t, server.URL+"/application/unknown/stats"); code != http.StatusInternalServerError || stats.Error != types.InvalidID {
+		t.Error("Expected unknown app to fail, got ", code, stats)
+	}
+
+	// Streamed stats follow the usage until the app goes away
+	resp, err := http.Get(url + "?stream=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	if err = decoder.Decode(&stats); err != nil || stats.Total.CPUPercent != 50 {
+		t.Error("Expected the first sample, got ", stats, err)
+	}
+	fake.SetUsage(app.UUID, "db", types.ResourceUsage{CPUPercent: 80})
+	for stats.Total.CPUPercent != 80 {
+		if err = decoder.Decode(&stats); err != nil {
+			t.Fatal("Expected a sample with the new usage, got ", err)
+		}
+	}
+	apiCall(t, "POST", server.URL+"/application/purge/"+app.UUID)
+	for err == nil {
+		err = decoder.Decode(&stats)
+	}
+}
+
+func TestAPIStatsOutliveWriteTimeout(t *testing.T) {
+	saved := statsInterval
+	defer func() {
+		statsInterval = saved
+	}()
+	statsInterval = 100 * time.Millisecond
+	server, fake, cleanup := newAPITestServerWithConfig(t, config.Config{WriteTimeout: 1})
+	defer cleanup()
+
+	_, app := apiDeploy(t, server.URL, `{"name":"testapp","version":"1.0"}`, apiTestPackage())
+	fake.SetUsage(app.UUID, "db", types.ResourceUsage{CPUPercent: 50})
+	resp, err := http.Get(server.URL + "/application/" + app.UUID + "/stats?stream=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	var stats StatsResponse
+	for deadline := time.Now().Add(1500 * time.Millisecond); time.Now().Before(deadline); {
+		if err = decoder.Decode(&stats); err != nil {
+			t.Fatal("Expected streamed stats to outlive the write timeout, got ", err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+)
+
+// tlsConfig loads the certificate of the TLS listener and the CA its clients
+// must present a certificate signed by
+func tlsConfig(cfg config.Config) (*tls.Config, error) {
+	if cfg.TLSCert == "" || cfg.TLSKey == "" || cfg.TLSClientCA == "" {
+		return nil, errors.New("The TLS listener needs tls_cert, tls_key and tls_client_ca")
+	}
+	certificate, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(cfg.TLSClientCA)
+	if err != nil {
+		return nil, err
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(ca) {
+		return nil, errors.New("No certificates found in " + cfg.TLSClientCA)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// clientSubject returns the subject of the client certificate of a request
+// on the TLS listener, "" for other requests
+func clientSubject(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return r.TLS.PeerCertificates[0].Subject.String()
+}
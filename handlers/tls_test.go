@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// testCertificate creates a certificate signed by parent, self-signed when
+// parent is nil
+func testCertificate(t *testing.T, name string, parent *tls.Certificate, client bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"cappsd"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, interface{}(key)
+	switch {
+	case parent == nil:
+		template.IsCA, template.BasicConstraintsValid = true, true
+	case client:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	default:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func writeTestCertificate(t *testing.T, dir string, name string, certificate tls.Certificate) (string, string) {
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	key, err := x509.MarshalECPrivateKey(certificate.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]}), 0644)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600)
+	return certFile, keyFile
+}
+
+func TestTLSListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappsd-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := testCertificate(t, "cappsd ca", nil, false)
+	server := testCertificate(t, "cappsd", &ca, false)
+	client := testCertificate(t, "fleet-manager", &ca, true)
+	other := testCertificate(t, "other ca", nil, false)
+	stranger := testCertificate(t, "stranger", &other, true)
+	caFile, _ := writeTestCertificate(t, dir, "ca", ca)
+	certFile, keyFile := writeTestCertificate(t, dir, "server", server)
+
+	cfg := config.Config{Provider: "memory", DataVolume: dir, TLSListenAddress: "127.0.0.1:0", TLSCert: certFile, TLSKey: keyFile}
+	if _, err = setupServer(cfg); err == nil {
+		t.Error("Expected the TLS listener to need a client CA")
+	}
+	cfg.TLSClientCA = keyFile
+	if _, err = setupServer(cfg); err == nil {
+		t.Error("Expected a client CA without certificates to be rejected")
+	}
+	cfg.TLSClientCA = caFile
+	s, err := setupServer(cfg)
+	if err != nil || s.tls == nil || s.metrics != nil {
+		t.Fatal("Expected the TLS server, got ", s, err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.tls.ServeTLS(listener, "", "")
+	defer s.tls.Close()
+	url := "https://" + listener.Addr().String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	clientOf := func(certificates ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificates}}}
+	}
+	for name, certificates := range map[string][]tls.Certificate{"no": nil, "an unknown": {stranger}} {
+		if resp, err := clientOf(certificates...).Get(url + "/ping"); err == nil {
+			resp.Body.Close()
+			t.Error("Expected clients with ", name, " certificate to be refused")
+		}
+	}
+
+	// The API is served the same as on the socket, callers are identified by
+	// their certificate
+	trusted := clientOf(client)
+	resp, err := trusted.Post(url+"/application/kill/unknown", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || resp.ProtoMajor != 1 {
+		t.Error("Expected the kill to fail over HTTP/1.1, got ", resp.Status, resp.Proto)
+	}
+	if resp, err = trusted.Get(url + "/audit?operation=kill"); err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var response AuditResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	if len(response.Entries) != 1 || response.Entries[0].Subject != "CN=fleet-manager,O=cappsd" ||
+		response.Entries[0].Caller != nil || response.Entries[0].Outcome != types.AuditFailure {
+		t.Error("Expected the kill to be audited with the subject of the client, got ", response)
+	}
+}
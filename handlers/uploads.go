@@ -0,0 +1,509 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/utils"
+)
+
+// Errors reported by the upload API
+const (
+	UploadNotFound   = "Upload not found"
+	UploadIncomplete = "Upload incomplete"
+	UploadChecksum   = "Upload checksum mismatch"
+	UploadDeploying  = "Upload is being deployed"
+	UploadWriting    = "Upload has chunks being written"
+)
+
+// DefaultUploadRetention is how many hours an upload session is kept after
+// its last chunk, used when upload_retention is not set in ecs.json
+const DefaultUploadRetention = 24
+
+var uploadID = regexp.MustCompile("^[0-9a-f-]+$")
+
+// UploadRange is a received byte range of an upload, End is exclusive
+type UploadRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// UploadRequest ...
+type UploadRequest struct {
+	Metadata   types.Metadata `json:"metadata"`
+	Size       int64          `json:"size"`
+	Persistent bool           `json:"persistent"`
+}
+
+// FinalizeRequest ...
+type FinalizeRequest struct {
+	SHA256 string `json:"sha256"`
+}
+
+// UploadResponse ...
+type UploadResponse struct {
+	ID       string        `json:"id"`
+	Size     int64         `json:"size"`
+	Received []UploadRange `json:"received"`
+	Complete bool          `json:"complete"`
+	Status   string        `json:"status"`
+	Error    string        `json:"error"`
+}
+
+// upload is a package being uploaded in chunks.  The chunks are written
+// into <data_volume>/uploads/<id>/package at their offsets and the session
+// is saved next to it, so uploads survive a restart of cappsd.
+type upload struct {
+	lock       sync.Mutex
+	dir        string
+	ID         string         `json:"id"`
+	Metadata   types.Metadata `json:"metadata"`
+	Size       int64          `json:"size"`
+	Persistent bool           `json:"persistent"`
+	Received   []UploadRange  `json:"received"`
+	Updated    time.Time      `json:"updated"`
+	finalizing bool
+	writing    int
+}
+
+// uploads keeps the upload sessions in progress
+type uploads struct {
+	lock      sync.Mutex
+	path      string
+	retention time.Duration
+	sessions  map[string]*upload
+}
+
+// uploadRetention ...
+func uploadRetention(c config.Config) time.Duration {
+	if c.UploadRetention > 0 {
+		return time.Duration(c.UploadRetention) * time.Hour
+	}
+	return DefaultUploadRetention * time.Hour
+}
+
+// newUploads removes the sessions that expired while cappsd was down
+func newUploads(path string, retention time.Duration) *uploads {
+	u := &uploads{path: path, retention: retention, sessions: make(map[string]*upload)}
+	u.sweep()
+	return u
+}
+
+// sweep removes the sessions that were not written to within the retention,
+// which clients abandoned without deleting them
+func (u *uploads) sweep() {
+	entries, err := ioutil.ReadDir(u.path)
+	if err != nil {
+		return
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	for _, entry := range entries {
+		if !entry.IsDir() || !uploadID.MatchString(entry.Name()) {
+			continue
+		}
+		session, exists := u.sessions[entry.Name()]
+		if !exists {
+			session = &upload{dir: filepath.Join(u.path, entry.Name())}
+			if err = utils.Load(session.sessionPath(), session); err != nil {
+				// Sessions that can't be read are aged by their directory
+				session.Updated = entry.ModTime()
+			}
+		}
+		if session.expired(u.retention) {
+			logging.Info("Removing expired upload", "id", entry.Name())
+			delete(u.sessions, entry.Name())
+			os.RemoveAll(session.dir)
+		}
+	}
+}
+
+// create starts a new upload session
+func (u *uploads) create(request UploadRequest) (*upload, error) {
+	u.sweep()
+	id, err := utils.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+	session := &upload{
+		dir:        filepath.Join(u.path, id),
+		ID:         id,
+		Metadata:   request.Metadata,
+		Size:       request.Size,
+		Persistent: request.Persistent,
+		Received:   []UploadRange{},
+		Updated:    time.Now(),
+	}
+	if err = os.MkdirAll(session.dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(session.packagePath())
+	if err == nil {
+		err = file.Close()
+	}
+	if err == nil {
+		err = session.save()
+	}
+	if err != nil {
+		os.RemoveAll(session.dir)
+		return nil, err
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.sessions[id] = session
+	return session, nil
+}
+
+// get returns an upload session, loading it from disk if cappsd was
+// restarted since it was created
+func (u *uploads) get(id string) (*upload, error) {
+	if !uploadID.MatchString(id) {
+		return nil, errors.New(UploadNotFound)
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if session, exists := u.sessions[id]; exists {
+		return session, nil
+	}
+	session := &upload{dir: filepath.Join(u.path, id)}
+	if err := utils.Load(session.sessionPath(), session); err != nil {
+		return nil, errors.New(UploadNotFound)
+	}
+	u.sessions[id] = session
+	return session, nil
+}
+
+// remove deletes an upload session and its data
+func (u *uploads) remove(session *upload) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	delete(u.sessions, session.ID)
+	os.RemoveAll(session.dir)
+}
+
+// discard removes a session on request of the client, sessions being
+// deployed are kept until the deploy finished
+func (u *uploads) discard(session *upload) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	if session.finalizing {
+		return errors.New(UploadDeploying)
+	}
+	delete(u.sessions, session.ID)
+	os.RemoveAll(session.dir)
+	return nil
+}
+
+func (s *upload) packagePath() string {
+	return filepath.Join(s.dir, "package")
+}
+
+func (s *upload) sessionPath() string {
+	return filepath.Join(s.dir, "session.json")
+}
+
+// save persists the session, the caller must hold the lock unless the
+// session is not shared yet
+func (s *upload) save() error {
+	return utils.Save(s.sessionPath(), s)
+}
+
+// expired reports if the session was not written to within the retention,
+// sessions being written or deployed never expire
+func (s *upload) expired(retention time.Duration) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return !s.finalizing && s.writing == 0 && time.Since(s.Updated) > retention
+}
+
+// write stores a chunk at offset and records it as received.  Chunks are
+// rejected once the session is being deployed, which in turn waits for the
+// chunks being written.
+func (s *upload) write(offset int64, chunk io.Reader) error {
+	if offset < 0 || offset > s.Size {
+		return errors.New("Offset " + strconv.FormatInt(offset, 10) + " is outside the upload")
+	}
+	s.lock.Lock()
+	if s.finalizing {
+		s.lock.Unlock()
+		return errors.New(UploadDeploying)
+	}
+	s.writing++
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		s.writing--
+		s.lock.Unlock()
+	}()
+
+	file, err := os.OpenFile(s.packagePath(), os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	// Read one byte more than fits to detect chunks running past the end
+	written, err := io.Copy(file, io.LimitReader(chunk, s.Size-offset+1))
+	if err == nil && offset+written > s.Size {
+		err = errors.New("Chunk runs past the end of the upload")
+		written = s.Size - offset
+		file.Truncate(s.Size)
+	}
+	if written > 0 {
+		s.lock.Lock()
+		s.Received = addRange(s.Received, UploadRange{Start: offset, End: offset + written})
+		s.Updated = time.Now()
+		if saveErr := s.save(); err == nil {
+			err = saveErr
+		}
+		s.lock.Unlock()
+	}
+	return err
+}
+
+// state reports the received ranges of the session
+func (s *upload) state() UploadResponse {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return UploadResponse{
+		ID:       s.ID,
+		Size:     s.Size,
+		Received: append([]UploadRange{}, s.Received...),
+		Complete: s.complete(),
+		Status:   Ok,
+	}
+}
+
+// complete reports if every byte was received, the caller must hold the lock
+func (s *upload) complete() bool {
+	return s.Size == 0 && len(s.Received) == 0 ||
+		len(s.Received) == 1 && s.Received[0].Start == 0 && s.Received[0].End == s.Size
+}
+
+// verify checks the upload is complete and matches the checksum
+func (s *upload) verify(checksum string) error {
+	s.lock.Lock()
+	complete := s.complete()
+	s.lock.Unlock()
+	if !complete {
+		return errors.New(UploadIncomplete)
+	}
+	file, err := os.Open(s.packagePath())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != strings.ToLower(checksum) {
+		return errors.New(UploadChecksum)
+	}
+	return nil
+}
+
+// addRange merges r into the sorted, non-overlapping ranges
+func addRange(ranges []UploadRange, r UploadRange) []UploadRange {
+	ranges = append(ranges, r)
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+	merged := []UploadRange{ranges[0]}
+	for _, next := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if next.Start <= last.End {
+			if next.End > last.End {
+				last.End = next.End
+			}
+		} else {
+			merged = append(merged, next)
+		}
+	}
+	return merged
+}
+
+func writeUploadError(w http.ResponseWriter, id string, err error) {
+	status := http.StatusInternalServerError
+	switch err.Error() {
+	case UploadNotFound:
+		status = http.StatusNotFound
+	case UploadIncomplete, UploadChecksum, UploadDeploying, UploadWriting:
+		status = http.StatusConflict
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(UploadResponse{ID: id, Status: Fail, Error: err.Error()})
+}
+
+func (h *Handler) createUpload(w http.ResponseWriter, r *http.Request) {
+	var request UploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(UploadResponse{Status: Fail, Error: err.Error()})
+		return
+	}
+	if request.Size <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(UploadResponse{Status: Fail, Error: "Upload size must be positive"})
+		return
+	}
+	session, err := h.uploads.create(request)
+	if err != nil {
+		writeUploadError(w, "", err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(session.state())
+}
+
+func (h *Handler) getUpload(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	session, err := h.uploads.get(id)
+	if err != nil {
+		writeUploadError(w, id, err)
+		return
+	}
+	json.NewEncoder(w).Encode(session.state())
+}
+
+func (h *Handler) putUploadChunk(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	session, err := h.uploads.get(id)
+	if err != nil {
+		writeUploadError(w, id, err)
+		return
+	}
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(UploadResponse{ID: id, Status: Fail, Error: "Invalid offset"})
+		return
+	}
+	// Whatever arrived before a broken connection is kept, the client
+	// resumes from the received ranges
+	if err = session.write(offset, r.Body); err != nil {
+		response := session.state()
+		response.Status = Fail
+		response.Error = err.Error()
+		if err.Error() == UploadDeploying {
+			w.WriteHeader(http.StatusConflict)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	json.NewEncoder(w).Encode(session.state())
+}
+
+func (h *Handler) deleteUpload(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	session, err := h.uploads.get(id)
+	if err != nil {
+		writeUploadError(w, id, err)
+		return
+	}
+	if err = h.uploads.discard(session); err != nil {
+		writeUploadError(w, id, err)
+		return
+	}
+	json.NewEncoder(w).Encode(UploadResponse{ID: id, Status: Ok})
+}
+
+// finalizeUpload checks the checksum of a complete upload and deploys it.
+// The session is removed once the deploy succeeded, after a failed deploy
+// it can be finalized again.
+func (h *Handler) finalizeUpload(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	session, err := h.uploads.get(id)
+	if err != nil {
+		writeUploadError(w, id, err)
+		return
+	}
+	var request FinalizeRequest
+	if err = json.NewDecoder(r.Body).Decode(&request); err != nil || request.SHA256 == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(UploadResponse{ID: id, Status: Fail, Error: "Expected the sha256 of the package"})
+		return
+	}
+
+	session.lock.Lock()
+	if session.finalizing || session.writing > 0 {
+		busy := UploadDeploying
+		if !session.finalizing {
+			busy = UploadWriting
+		}
+		session.lock.Unlock()
+		writeUploadError(w, id, errors.New(busy))
+		return
+	}
+	session.finalizing = true
+	session.lock.Unlock()
+	finished := func(result DeployResponse) {
+		if result.Status == Ok {
+			h.uploads.remove(session)
+			return
+		}
+		session.lock.Lock()
+		session.finalizing = false
+		session.lock.Unlock()
+	}
+
+	entry := auditEntry(r)
+	entry.App, entry.Name, entry.Version = "", session.Metadata.Name, session.Metadata.Version
+	if err = session.verify(request.SHA256); err != nil {
+		finished(DeployResponse{Status: Fail})
+		writeUploadError(w, id, err)
+		return
+	}
+	entry.PackageHash = "sha256:" + strings.ToLower(request.SHA256)
+	file, err := os.Open(session.packagePath())
+	if err != nil {
+		finished(DeployResponse{Status: Fail})
+		writeUploadError(w, id, err)
+		return
+	}
+	if r.URL.Query().Get("async") == "true" {
+		h.startDeployJob(w, entry, session.Metadata, file, session.Size, session.Persistent, finished)
+		return
+	}
+
+	defer file.Close()
+	response := DeployResponse{Status: Fail, Error: ""}
+	if app, err := h.provider.Deploy(session.Metadata, file, session.Persistent); err == nil {
+		entry.App = app.UUID
+		response.UUID = app.UUID
+		response.Name = app.Name
+		response.Version = app.Version
+		response.Images = app.Images
+		response.Status = Ok
+	} else {
+		response.Error = err.Error()
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	finished(response)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/provider"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+func uploadCall(t *testing.T, method string, url string, body []byte) (int, UploadResponse, []byte) {
+	req, _ := http.NewRequest(method, url, bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Request failed: ", err)
+	}
+	defer resp.Body.Close()
+	var raw bytes.Buffer
+	raw.ReadFrom(resp.Body)
+	var response UploadResponse
+	json.Unmarshal(raw.Bytes(), &response)
+	return resp.StatusCode, response, raw.Bytes()
+}
+
+func TestAddRange(t *testing.T) {
+	var ranges []UploadRange
+	for _, r := range []UploadRange{{10, 20}, {30, 40}, {0, 5}, {15, 30}, {5, 8}} {
+		ranges = addRange(ranges, r)
+	}
+	expected := []UploadRange{{0, 8}, {10, 40}}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Error("Expected ", expected, " got ", ranges)
+	}
+}
+
+func TestAPIChunkedUpload(t *testing.T) {
+	server, fake, cleanup := newAPITestServer(t)
+	defer cleanup()
+
+	pkg := apiTestPackage()
+	sum := sha256.Sum256(pkg)
+	checksum := hex.EncodeToString(sum[:])
+	create, _ := json.Marshal(UploadRequest{
+		Metadata: types.Metadata{Name: "testapp", Version: "1.0"},
+		Size:     int64(len(pkg)),
+	})
+	code, session, _ := uploadCall(t, "POST", server.URL+"/uploads", create)
+	if code != http.StatusCreated || session.ID == "" || session.Size != int64(len(pkg)) {
+		t.Fatal("Failed to create upload: ", code, session)
+	}
+	url := server.URL + "/uploads/" + session.ID
+
+	// Second half first, then finalize too early
+	half := len(pkg) / 2
+	if code, session, _ = uploadCall(t, "PUT", url+"?offset="+strconv.Itoa(half), pkg[half:]); code != http.StatusOK {
+		t.Fatal("Failed to upload chunk: ", code, session)
+	}
+	finalize, _ := json.Marshal(FinalizeRequest{SHA256: checksum})
+	if code, session, _ = uploadCall(t, "POST", url+"/finalize", finalize); code != http.StatusConflict || session.Error != UploadIncomplete {
+		t.Error("Expected incomplete upload to be rejected, got ", code, session)
+	}
+	if code, session, _ = uploadCall(t, "PUT", url+"?offset=1", append(pkg[1:], 'x')); code != http.StatusBadRequest {
+		t.Error("Expected chunk past the end to be rejected, got ", code, session)
+	}
+	if code, session, _ = uploadCall(t, "GET", url, nil); session.Complete || !reflect.DeepEqual(session.Received, []UploadRange{{1, int64(len(pkg))}}) {
+		t.Error("Unexpected received ranges: ", session)
+	}
+	uploadCall(t, "PUT", url+"?offset=0", pkg[:1])
+
+	bad, _ := json.Marshal(FinalizeRequest{SHA256: "00"})
+	if code, session, _ = uploadCall(t, "POST", url+"/finalize", bad); code != http.StatusConflict || session.Error != UploadChecksum {
+		t.Error("Expected checksum mismatch, got ", code, session)
+	}
+
+	fake.FailNext(provider.OpDeploy, errors.New("disk full"))
+	if code, _, _ = uploadCall(t, "POST", url+"/finalize", finalize); code != http.StatusInternalServerError {
+		t.Error("Expected injected deploy failure, got ", code)
+	}
+	code, _, body := uploadCall(t, "POST", url+"/finalize", finalize)
+	var app DeployResponse
+	json.Unmarshal(body, &app)
+	if code != http.StatusOK || app.Status != Ok || app.Name != "testapp" {
+		t.Fatal("Expected finalized upload to deploy, got ", code, string(body))
+	}
+	if code, _, _ = uploadCall(t, "GET", url, nil); code != http.StatusNotFound {
+		t.Error("Expected session to be removed after deploy, got ", code)
+	}
+}
+
+func TestUploadResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappsd-uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	session, err := newUploads(dir, time.Hour).create(UploadRequest{Metadata: types.Metadata{Name: "testapp"}, Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = session.write(0, strings.NewReader("01234")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sessions are read back from disk after a restart
+	restarted := newUploads(dir, time.Hour)
+	resumed, err := restarted.get(session.ID)
+	if err != nil || !reflect.DeepEqual(resumed.state().Received, []UploadRange{{0, 5}}) {
+		t.Fatal("Expected upload to survive a restart, got ", err)
+	}
+	resumed.write(5, strings.NewReader("56789"))
+	if err = resumed.verify(hex.EncodeToString(sha256.New().Sum(nil))); err == nil || err.Error() != UploadChecksum {
+		t.Error("Expected complete upload with wrong checksum, got ", err)
+	}
+
+	restarted.remove(resumed)
+	if _, err = restarted.get(session.ID); err == nil {
+		t.Error("Expected removed upload to be gone")
+	}
+	if _, err = restarted.get("../" + session.ID); err == nil {
+		t.Error("Expected invalid upload ID to be rejected")
+	}
+}
+
+func TestUploadBusy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappsd-uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sessions := newUploads(dir, time.Hour)
+	session, err := sessions.create(UploadRequest{Metadata: types.Metadata{Name: "testapp"}, Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.finalizing = true
+	if err = session.write(0, strings.NewReader("01234")); err == nil || err.Error() != UploadDeploying {
+		t.Error("Expected chunks to be rejected while deploying, got ", err)
+	}
+	if received := session.state().Received; len(received) != 0 {
+		t.Error("Expected nothing to be received while deploying, got ", received)
+	}
+	if err = sessions.discard(session); err == nil || err.Error() != UploadDeploying {
+		t.Error("Expected delete to be rejected while deploying, got ", err)
+	}
+	if _, err = os.Stat(session.packagePath()); err != nil {
+		t.Error("Expected package to be kept while deploying, got ", err)
+	}
+	session.finalizing = false
+	if err = session.write(0, strings.NewReader("01234")); err != nil || session.writing != 0 {
+		t.Error("Expected chunk to be written after a failed deploy, got ", err, session.writing)
+	}
+	if err = sessions.discard(session); err != nil {
+		t.Error("Expected delete to succeed after a failed deploy, got ", err)
+	}
+	if _, err = os.Stat(session.dir); !os.IsNotExist(err) {
+		t.Error("Expected upload to be removed, got ", err)
+	}
+}
+
+func TestUploadExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappsd-uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sessions := newUploads(dir, time.Hour)
+	stale, err := sessions.create(UploadRequest{Metadata: types.Metadata{Name: "stale"}, Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.Updated = time.Now().Add(-2 * time.Hour)
+	stale.save()
+	fresh, err := sessions.create(UploadRequest{Metadata: types.Metadata{Name: "fresh"}, Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(stale.dir); !os.IsNotExist(err) {
+		t.Error("Expected expired upload to be removed on create")
+	}
+	if _, err = sessions.get(stale.ID); err == nil {
+		t.Error("Expected expired upload to be gone")
+	}
+
+	// Expired sessions are also removed on startup, written ones are kept
+	fresh.write(0, strings.NewReader("01234"))
+	busy, err := sessions.create(UploadRequest{Metadata: types.Metadata{Name: "busy"}, Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy.Updated = time.Now().Add(-2 * time.Hour)
+	busy.save()
+	busy.finalizing = true
+	sessions.sweep()
+	if _, err = sessions.get(busy.ID); err != nil {
+		t.Error("Expected upload being deployed to be kept, got ", err)
+	}
+	restarted := newUploads(dir, time.Hour)
+	if _, err = restarted.get(fresh.ID); err != nil {
+		t.Error("Expected recent upload to survive a restart, got ", err)
+	}
+	if _, err = restarted.get(busy.ID); err == nil {
+		t.Error("Expected upload expired while cappsd was down to be removed")
+	}
+}
@@ -0,0 +1,314 @@
+// Package logging writes leveled, structured log lines as logfmt or JSON.
+// Loggers carry fields, such as the app a line is about or the id of the
+// request it was logged for, that are added to every line they write.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a log line
+type Level int32
+
+// The levels, lines below the level of the output are not written
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel reads the name of a level
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) || (levelName == "warn" && strings.EqualFold(name, "warning")) {
+			return Level(i), nil
+		}
+	}
+	return InfoLevel, errors.New("Invalid log level " + name)
+}
+
+// The formats of log lines
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+// output is where the loggers derived from one another write
+type output struct {
+	lock   sync.Mutex
+	out    io.Writer
+	level  int32
+	format atomic.Value
+	now    func() time.Time
+}
+
+// Logger writes log lines with its fields
+type Logger struct {
+	out    *output
+	fields []interface{}
+}
+
+// New creates a logger writing lines of at least level to out in format
+func New(out io.Writer, level Level, format string) (*Logger, error) {
+	o := &output{out: out, level: int32(level), now: time.Now}
+	if err := o.setFormat(format); err != nil {
+		return nil, err
+	}
+	return &Logger{out: o}, nil
+}
+
+func (o *output) setFormat(format string) error {
+	switch format {
+	case "":
+		format = FormatLogfmt
+	case FormatLogfmt, FormatJSON:
+	default:
+		return errors.New("Invalid log format " + format)
+	}
+	o.format.Store(format)
+	return nil
+}
+
+var std, _ = New(os.Stderr, InfoLevel, FormatLogfmt)
+
+// Configure sets the level and the format of the default logger, empty
+// ones are left as they are
+func Configure(level string, format string) error {
+	if level != "" {
+		parsed, err := ParseLevel(level)
+		if err != nil {
+			return err
+		}
+		SetLevel(parsed)
+	}
+	if format != "" {
+		return std.out.setFormat(format)
+	}
+	return nil
+}
+
+// SetOutput makes the default logger write to out
+func SetOutput(out io.Writer) {
+	std.out.lock.Lock()
+	defer std.out.lock.Unlock()
+	std.out.out = out
+}
+
+// SetLevel changes the level of the default logger and of every logger
+// derived from it
+func SetLevel(level Level) {
+	std.SetLevel(level)
+}
+
+// GetLevel returns the level of the default logger
+func GetLevel() Level {
+	return std.GetLevel()
+}
+
+// Format returns the format of the default logger
+func Format() string {
+	return std.out.format.Load().(string)
+}
+
+// Default returns the default logger
+func Default() *Logger {
+	return std
+}
+
+// SetLevel changes the level of the logger and of the loggers it shares its
+// output with
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.out.level, int32(level))
+}
+
+// GetLevel returns the level of the logger
+func (l *Logger) GetLevel() Level {
+	return Level(atomic.LoadInt32(&l.out.level))
+}
+
+// Enabled tells if lines of level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.GetLevel()
+}
+
+// With returns a logger adding the key value pairs to the lines of l
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(append(fields, l.fields...), keyvals...)
+	return &Logger{out: l.out, fields: fields}
+}
+
+// Debug writes a line of the debug level, keyvals are key value pairs
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(DebugLevel, msg, keyvals)
+}
+
+// Info writes a line of the info level
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(InfoLevel, msg, keyvals)
+}
+
+// Warn writes a line of the warn level
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(WarnLevel, msg, keyvals)
+}
+
+// Error writes a line of the error level
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(ErrorLevel, msg, keyvals)
+}
+
+// With returns a logger adding the key value pairs to the lines of the
+// default logger
+func With(keyvals ...interface{}) *Logger {
+	return std.With(keyvals...)
+}
+
+// Debug writes a line of the debug level with the default logger
+func Debug(msg string, keyvals ...interface{}) {
+	std.log(DebugLevel, msg, keyvals)
+}
+
+// Info writes a line of the info level with the default logger
+func Info(msg string, keyvals ...interface{}) {
+	std.log(InfoLevel, msg, keyvals)
+}
+
+// Warn writes a line of the warn level with the default logger
+func Warn(msg string, keyvals ...interface{}) {
+	std.log(WarnLevel, msg, keyvals)
+}
+
+// Error writes a line of the error level with the default logger
+func Error(msg string, keyvals ...interface{}) {
+	std.log(ErrorLevel, msg, keyvals)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	pairs := make([]interface{}, 0, 6+len(l.fields)+len(keyvals)+1)
+	pairs = append(pairs, "time", l.out.now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	pairs = append(append(pairs, l.fields...), keyvals...)
+	if len(pairs)%2 != 0 {
+		pairs = append(pairs[:len(pairs)-1], "extra", pairs[len(pairs)-1])
+	}
+
+	var line bytes.Buffer
+	if l.out.format.Load().(string) == FormatJSON {
+		writeJSON(&line, pairs)
+	} else {
+		writeLogfmt(&line, pairs)
+	}
+	line.WriteByte('\n')
+
+	l.out.lock.Lock()
+	defer l.out.lock.Unlock()
+	l.out.out.Write(line.Bytes())
+}
+
+// text returns the text of a value that is not a number or a boolean
+func text(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+func writeLogfmt(line *bytes.Buffer, pairs []interface{}) {
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(strings.Replace(text(pairs[i]), " ", "_", -1))
+		line.WriteByte('=')
+		value := text(pairs[i+1])
+		if value == "" || strings.ContainsAny(value, " =\"\\\n\t") {
+			value = strconv.Quote(value)
+		}
+		line.WriteString(value)
+	}
+}
+
+func writeJSON(line *bytes.Buffer, pairs []interface{}) {
+	line.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			line.WriteByte(',')
+		}
+		key, _ := json.Marshal(text(pairs[i]))
+		line.Write(key)
+		line.WriteByte(':')
+		var value []byte
+		switch v := pairs[i+1].(type) {
+		case bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+			value, _ = json.Marshal(v)
+		case time.Duration:
+			value, _ = json.Marshal(v.String())
+		default:
+			value, _ = json.Marshal(text(v))
+		}
+		line.Write(value)
+	}
+	line.WriteByte('}')
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying a logger
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger of a context, the default logger when it
+// has none
+func FromContext(ctx context.Context) *Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return logger
+	}
+	return std
+}
+
+// Writer returns a writer logging each line written to it at level, for
+// libraries using the standard log package
+func (l *Logger) Writer(level Level) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		for _, msg := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+			l.log(level, msg, nil)
+		}
+		return len(p), nil
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLogger(t *testing.T, level Level, format string) (*Logger, *bytes.Buffer) {
+	var out bytes.Buffer
+	logger, err := New(&out, level, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.out.now = func() time.Time {
+		return time.Date(2018, 6, 1, 10, 0, 0, 0, time.UTC)
+	}
+	return logger, &out
+}
+
+func TestLogfmt(t *testing.T) {
+	logger, out := newTestLogger(t, InfoLevel, FormatLogfmt)
+	app := logger.With("app", "1234", "name", "my app")
+	app.Debug("Hidden")
+	app.Info("Deployed", "images", 2, "took", 1500*time.Millisecond)
+	app.Error("Failed to start", "error", errors.New(`port "80" in use`), "odd")
+	logger.Warn("")
+
+	expected := `time=2018-06-01T10:00:00Z level=info msg=Deployed app=1234 name="my app" images=2 took=1.5s
+time=2018-06-01T10:00:00Z level=error msg="Failed to start" app=1234 name="my app" error="port \"80\" in use" extra=odd
+time=2018-06-01T10:00:00Z level=warn msg=""
+`
+	if out.String() != expected {
+		t.Error("Expected\n", expected, "got\n", out.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	logger, out := newTestLogger(t, DebugLevel, FormatJSON)
+	logger.With("request_id", "abc").Debug("Request", "code", 200, "ok", true, "error", errors.New("none"))
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatal(err, out.String())
+	}
+	if !strings.HasPrefix(out.String(), `{"time":"2018-06-01T10:00:00Z","level":"debug","msg":"Request","request_id":"abc"`) {
+		t.Error("Expected the fields in order, got ", out.String())
+	}
+	if line["code"] != float64(200) || line["ok"] != true || line["error"] != "none" {
+		t.Error("Expected typed values, got ", line)
+	}
+}
+
+func TestLevels(t *testing.T) {
+	for name, expected := range map[string]Level{"debug": DebugLevel, "INFO": InfoLevel, "warning": WarnLevel, "error": ErrorLevel} {
+		if level, err := ParseLevel(name); err != nil || level != expected {
+			t.Error("Expected ", name, " to be ", expected, " got ", level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected an unknown level to fail")
+	}
+	if _, err := New(nil, InfoLevel, "xml"); err == nil {
+		t.Error("Expected an unknown format to fail")
+	}
+
+	// Loggers derived from one another share their level
+	logger, out := newTestLogger(t, ErrorLevel, "")
+	derived := logger.With("app", "1234")
+	derived.Warn("Hidden")
+	logger.SetLevel(WarnLevel)
+	derived.Warn("Shown")
+	if !strings.Contains(out.String(), "Shown") || strings.Contains(out.String(), "Hidden") || derived.GetLevel() != WarnLevel {
+		t.Error("Expected the new level to apply to derived loggers, got ", out.String())
+	}
+}
+
+func TestDefaultLogger(t *testing.T) {
+	var out bytes.Buffer
+	SetOutput(&out)
+	level, format := GetLevel(), Format()
+	defer func() {
+		SetOutput(os.Stderr)
+		Configure(level.String(), format)
+	}()
+	if err := Configure("debug", FormatJSON); err != nil || GetLevel() != DebugLevel || Format() != FormatJSON {
+		t.Error("Expected the default logger to be configured, got ", GetLevel(), Format(), err)
+	}
+	if err := Configure("loud", ""); err == nil {
+		t.Error("Expected an unknown level to fail")
+	}
+
+	ctx := NewContext(context.Background(), With("request_id", "abc"))
+	FromContext(ctx).Info("From the request")
+	FromContext(context.Background()).Info("Without a request")
+	std := log.New(Default().Writer(WarnLevel), "", 0)
+	std.Println("From a library")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], `"request_id":"abc"`) || strings.Contains(lines[1], "request_id") || !strings.Contains(lines[2], `"level":"warn","msg":"From a library"`) {
+		t.Error("Expected the lines of the default logger, got ", lines)
+	}
+}
@@ -0,0 +1,394 @@
+// Package metrics keeps counters, gauges and histograms of cappsd and
+// writes them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets of
+// histograms of durations
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// DefaultRegistry holds the metrics created by the package functions
+var DefaultRegistry = NewRegistry()
+
+// family is a metric with all its label values
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics and writes them in the order they were created
+type Registry struct {
+	lock     sync.Mutex
+	names    map[string]bool
+	families []family
+}
+
+// NewRegistry ...
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, f family) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.names[name] {
+		panic("metric " + name + " registered twice")
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// WriteTo writes every metric of the registry in the text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.lock.Lock()
+	families := append([]family(nil), r.families...)
+	r.lock.Unlock()
+
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, f := range families {
+		f.write(buf)
+	}
+	err := buf.Flush()
+	return counter.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Handler serves the metrics of the registries
+func Handler(registries ...*Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		for _, registry := range registries {
+			if _, err := registry.WriteTo(w); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// metric is what metrics of all types share: a name, the help text and the
+// names of the labels
+type metric struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (m *metric) header(w *bufio.Writer) {
+	w.WriteString("# HELP " + m.name + " " + escapeHelp(m.help) + "\n")
+	w.WriteString("# TYPE " + m.name + " " + m.kind + "\n")
+}
+
+// sample writes a line of the metric, extra is a last label such as the le
+// of a histogram bucket
+func (m *metric) sample(w *bufio.Writer, suffix string, values []string, extra string, value float64) {
+	w.WriteString(m.name + suffix)
+	pairs := make([]string, 0, len(values)+1)
+	for i, label := range m.labels {
+		pairs = append(pairs, label+`="`+escapeValue(values[i])+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) > 0 {
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	w.WriteString(" " + formatValue(value) + "\n")
+}
+
+// key identifies the series of a set of label values
+func (m *metric) key(values []string) string {
+	if len(values) != len(m.labels) {
+		panic("metric " + m.name + " takes " + strconv.Itoa(len(m.labels)) + " label values")
+	}
+	return strings.Join(values, "\xff")
+}
+
+// series are the values of a metric by label values, written in order
+type series struct {
+	lock   sync.Mutex
+	values map[string][]string
+}
+
+func (s *series) sortedKeys() []string {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter is a value that only goes up, such as a number of requests
+type Counter struct {
+	metric
+	series
+	counts map[string]float64
+}
+
+// NewCounter creates a counter in the registry
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{
+		metric: metric{name: name, help: help, kind: "counter", labels: labels},
+		series: series{values: make(map[string][]string)},
+		counts: make(map[string]float64),
+	}
+	r.register(name, c)
+	return c
+}
+
+// NewCounter creates a counter in the default registry
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return DefaultRegistry.NewCounter(name, help, labels...)
+}
+
+// Inc adds one to the counter with the label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds delta, which must not be negative, to the counter with the label
+// values
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic("counter " + c.name + " cannot decrease")
+	}
+	key := c.key(values)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.values[key] = values
+	c.counts[key] += delta
+}
+
+// Value returns the count with the label values
+func (c *Counter) Value(values ...string) float64 {
+	key := c.key(values)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.counts[key]
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.header(w)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, key := range c.sortedKeys() {
+		c.sample(w, "", c.values[key], "", c.counts[key])
+	}
+}
+
+// Gauge is a value that goes up and down, such as a number of apps
+type Gauge struct {
+	metric
+	series
+	gauges map[string]float64
+}
+
+// NewGauge creates a gauge in the registry
+func (r *Registry) NewGauge(name string, help string, labels ...string) *Gauge {
+	g := &Gauge{
+		metric: metric{name: name, help: help, kind: "gauge", labels: labels},
+		series: series{values: make(map[string][]string)},
+		gauges: make(map[string]float64),
+	}
+	r.register(name, g)
+	return g
+}
+
+// NewGauge creates a gauge in the default registry
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	return DefaultRegistry.NewGauge(name, help, labels...)
+}
+
+// Set sets the gauge with the label values
+func (g *Gauge) Set(value float64, values ...string) {
+	key := g.key(values)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.values[key] = values
+	g.gauges[key] = value
+}
+
+// Add adds delta to the gauge with the label values
+func (g *Gauge) Add(delta float64, values ...string) {
+	key := g.key(values)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.values[key] = values
+	g.gauges[key] += delta
+}
+
+// Delete removes the gauge with the label values
+func (g *Gauge) Delete(values ...string) {
+	key := g.key(values)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	delete(g.values, key)
+	delete(g.gauges, key)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.header(w)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, key := range g.sortedKeys() {
+		g.sample(w, "", g.values[key], "", g.gauges[key])
+	}
+}
+
+// GaugeFunc is a gauge whose values are collected each time the metrics
+// are written, such as the usage of containers
+type GaugeFunc struct {
+	metric
+	collect func(set func(value float64, values ...string))
+}
+
+// NewGaugeFunc creates a collected gauge in the registry.  collect calls set
+// for every series, concurrent scrapes call it concurrently.
+func (r *Registry) NewGaugeFunc(name string, help string, labels []string, collect func(set func(value float64, values ...string))) *GaugeFunc {
+	g := &GaugeFunc{metric: metric{name: name, help: help, kind: "gauge", labels: labels}, collect: collect}
+	r.register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	collected := series{values: make(map[string][]string)}
+	gauges := make(map[string]float64)
+	g.collect(func(value float64, values ...string) {
+		key := g.key(values)
+		collected.values[key] = values
+		gauges[key] = value
+	})
+	g.header(w)
+	for _, key := range collected.sortedKeys() {
+		g.sample(w, "", collected.values[key], "", gauges[key])
+	}
+}
+
+// Histogram counts observations, such as durations, in buckets
+type Histogram struct {
+	metric
+	series
+	buckets []float64
+	counts  map[string][]uint64
+	sums    map[string]float64
+}
+
+// NewHistogram creates a histogram in the registry with buckets up to the
+// given bounds, DefaultBuckets when nil
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{
+		metric:  metric{name: name, help: help, kind: "histogram", labels: labels},
+		series:  series{values: make(map[string][]string)},
+		buckets: buckets,
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+	}
+	r.register(name, h)
+	return h
+}
+
+// NewHistogram creates a histogram in the default registry
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	return DefaultRegistry.NewHistogram(name, help, buckets, labels...)
+}
+
+// Observe records a value in the histogram with the label values
+func (h *Histogram) Observe(value float64, values ...string) {
+	key := h.key(values)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	counts, exists := h.counts[key]
+	if !exists {
+		// The last count is the +Inf bucket
+		counts = make([]uint64, len(h.buckets)+1)
+		h.counts[key] = counts
+		h.values[key] = values
+	}
+	i := sort.SearchFloat64s(h.buckets, value)
+	counts[i]++
+	h.sums[key] += value
+}
+
+// Count returns the number of observations with the label values
+func (h *Histogram) Count(values ...string) uint64 {
+	key := h.key(values)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var total uint64
+	for _, count := range h.counts[key] {
+		total += count
+	}
+	return total
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.header(w)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, key := range h.sortedKeys() {
+		values, counts := h.values[key], h.counts[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += counts[i]
+			h.sample(w, "_bucket", values, `le="`+formatValue(bound)+`"`, float64(cumulative))
+		}
+		cumulative += counts[len(h.buckets)]
+		h.sample(w, "_bucket", values, `le="+Inf"`, float64(cumulative))
+		h.sample(w, "_sum", values, "", h.sums[key])
+		h.sample(w, "_count", values, "", float64(cumulative))
+	}
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeValue(value string) string {
+	return valueEscaper.Replace(value)
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests by route.\nAll of them.", "route", "code")
+	apps := r.NewGauge("apps", "Apps.")
+	durations := r.NewHistogram("duration_seconds", "Durations.", []float64{1, 0.1}, "phase")
+	r.NewGaugeFunc("usage", `Usage in \ units.`, []string{"container"}, func(set func(float64, ...string)) {
+		set(0.5, "web_1")
+		set(math.Inf(1), `db "main"`)
+	})
+
+	requests.Inc("/ping", "200")
+	requests.Add(2, "/ping", "200")
+	requests.Inc("/application/{id}", "404")
+	apps.Set(3)
+	apps.Add(-1)
+	durations.Observe(0.05, "starting")
+	durations.Observe(0.5, "starting")
+	durations.Observe(5, "starting")
+
+	var out bytes.Buffer
+	if _, err := r.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP requests_total Requests by route.\nAll of them.
+# TYPE requests_total counter
+requests_total{route="/application/{id}",code="404"} 1
+requests_total{route="/ping",code="200"} 3
+# HELP apps Apps.
+# TYPE apps gauge
+apps 2
+# HELP duration_seconds Durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{phase="starting",le="0.1"} 1
+duration_seconds_bucket{phase="starting",le="1"} 2
+duration_seconds_bucket{phase="starting",le="+Inf"} 3
+duration_seconds_sum{phase="starting"} 5.55
+duration_seconds_count{phase="starting"} 3
+# HELP usage Usage in \\ units.
+# TYPE usage gauge
+usage{container="db \"main\""} +Inf
+usage{container="web_1"} 0.5
+`
+	if out.String() != expected {
+		t.Error("Expected\n", expected, "got\n", out.String())
+	}
+	if requests.Value("/ping", "200") != 3 || durations.Count("starting") != 3 || durations.Count("unpacking") != 0 {
+		t.Error("Expected the recorded values")
+	}
+
+	recorder := httptest.NewRecorder()
+	Handler(r, NewRegistry()).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Header().Get("Content-Type") != ContentType || recorder.Body.String() != expected {
+		t.Error("Expected the metrics to be served, got ", recorder.Header(), recorder.Body.String())
+	}
+}
+
+func TestRegistryMisuse(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounter("total", "Total.", "kind")
+	for name, misuse := range map[string]func(){
+		"duplicate":    func() { r.NewGauge("total", "Again.") },
+		"label values": func() { counter.Inc() },
+		"decrease":     func() { counter.Add(-1, "a") },
+	} {
+		func() {
+			defer func() {
+				if recovered := recover(); recovered == nil || !strings.Contains(recovered.(string), "total") {
+					t.Error("Expected ", name, " to panic, got ", recovered)
+				}
+			}()
+			misuse()
+		}()
+	}
+}
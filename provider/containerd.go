@@ -0,0 +1,872 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	composecfg "github.com/docker/libcompose/config"
+	"github.com/docker/libcompose/project"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/utils"
+)
+
+// Defaults used when the Containerd section of ecs.json is incomplete
+const (
+	DefaultContainerdAddress   = "/run/containerd/containerd.sock"
+	DefaultContainerdNamespace = "cappsd"
+	DefaultContainerdBinary    = "ctr"
+)
+
+// containerdStopTimeout is how long a task gets to exit on SIGTERM before it
+// is sent SIGKILL.
+var containerdStopTimeout = 10 * time.Second
+
+// ContainerdApp ...
+type ContainerdApp struct {
+	Info     types.App                            `json:"info"`
+	Services map[string]*composecfg.ServiceConfig `json:"-"`
+	Order    []string                             `json:"-"`
+	Active   bool                                 `json:"-"`
+	// Upgrading is set while an upgrade or rollback replaces the app, which
+	// holds the lock only to swap the versions
+	Upgrading bool `json:"-"`
+}
+
+// Containerd runs application packages directly on containerd, without a
+// Docker daemon.  The docker-compose.yml in the package is only used as a
+// description of the services; every service becomes a single containerd
+// container named <uuid>_<service> running on the host network.  All calls
+// go through the ctr client so the only runtime dependency is the containerd
+// socket.
+type Containerd struct {
+	Cfg   config.Config
+	Apps  map[string]*ContainerdApp
+	PApps map[string]*types.Metadata
+	Lock  sync.RWMutex
+}
+
+type containerdTask struct {
+	PID    string
+	Status string
+}
+
+func init() {
+	Register("containerd", func(c config.Config) Provider {
+		return NewContainerd(c)
+	})
+}
+
+// NewContainerd ...
+func NewContainerd(c config.Config) *Containerd {
+	provider := new(Containerd)
+	provider.Apps = make(map[string]*ContainerdApp)
+	provider.PApps = make(map[string]*types.Metadata)
+	if c.Containerd.Address == "" {
+		c.Containerd.Address = DefaultContainerdAddress
+	}
+	if c.Containerd.Namespace == "" {
+		c.Containerd.Namespace = DefaultContainerdNamespace
+	}
+	if c.Containerd.Binary == "" {
+		c.Containerd.Binary = DefaultContainerdBinary
+	}
+	provider.Cfg = c
+	return provider
+}
+
+// ctr runs the ctr client against the configured socket and namespace
+func (p *Containerd) ctr(stdin io.Reader, args ...string) ([]byte, error) {
+	cmdArgs := append([]string{"--address", p.Cfg.Containerd.Address, "--namespace", p.Cfg.Containerd.Namespace}, args...)
+	cmd := exec.Command(p.Cfg.Containerd.Binary, cmdArgs...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return out, fmt.Errorf("ctr %s: %s", args[0], msg)
+	}
+	return out, nil
+}
+
+// tasks returns the state of every task in the namespace keyed by container ID
+func (p *Containerd) tasks() (map[string]containerdTask, error) {
+	out, err := p.ctr(nil, "tasks", "ls")
+	if err != nil {
+		return nil, err
+	}
+	tasks := make(map[string]containerdTask)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[0] == "TASK" {
+			continue
+		}
+		tasks[fields[0]] = containerdTask{PID: fields[1], Status: fields[2]}
+	}
+	return tasks, scanner.Err()
+}
+
+// importImages imports every docker-save archive found in path
+func (p *Containerd) importImages(logger *logging.Logger, path string, progress types.Progress) error {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	var images []string
+	for _, f := range files {
+		if !f.IsDir() && strings.Contains(f.Name(), ".tar") {
+			images = append(images, f.Name())
+		}
+	}
+	for i, name := range images {
+		logger.Info("Importing image archive", "archive", name)
+		progress.Report(types.PhaseLoadingImages, i+1, len(images))
+		if err = p.importImage(filepath.Join(path, name)); err != nil {
+			return err
+		}
+		if info, err := os.Stat(filepath.Join(path, name)); err == nil {
+			imageLoadBytes.Add(float64(info.Size()))
+		}
+	}
+	return nil
+}
+
+func (p *Containerd) importImage(archive string) error {
+	input, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	// ctr only understands plain tarballs, docker-save archives in packages
+	// are usually gzipped
+	buffered := bufio.NewReader(input)
+	var reader io.Reader = buffered
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	_, err = p.ctr(reader, "images", "import", "-")
+	return err
+}
+
+// loadComposeServices parses the compose file of an unpacked app without
+// needing a Docker client
+func loadComposeServices(path string, uuid string) (map[string]*composecfg.ServiceConfig, []string, error) {
+	prj := project.NewProject(&project.Context{
+		ComposeFiles: []string{path + "/docker-compose.yml"},
+		ProjectName:  uuid,
+	}, nil, nil)
+	if prj == nil {
+		return nil, nil, errors.New("Could not create compose project for " + path)
+	}
+	if err := prj.Parse(); err != nil {
+		return nil, nil, err
+	}
+	services := prj.ServiceConfigs.All()
+	order, err := startOrder(services)
+	if err != nil {
+		return nil, nil, err
+	}
+	return services, order, nil
+}
+
+// normalizeImage turns a compose image reference into the fully qualified
+// reference that ctr gives images on import
+func normalizeImage(image string) string {
+	name := image
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 1 {
+		name = "docker.io/library/" + image
+	} else if !strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost" {
+		name = "docker.io/" + image
+	}
+	last := name[strings.LastIndex(name, "/")+1:]
+	if !strings.Contains(last, ":") && !strings.Contains(last, "@") {
+		name = name + ":latest"
+	}
+	return name
+}
+
+func containerdID(uuid string, service string) string {
+	return uuid + "_" + service
+}
+
+// runService creates and starts the container for a single service
+func (p *Containerd) runService(app *ContainerdApp, name string) error {
+	svc := app.Services[name]
+	if svc.Image == "" {
+		return errors.New("Service " + name + " has no image, building is not supported")
+	}
+	args := []string{"run", "--detach", "--net-host", "--label", "cappsd.app=" + app.Info.UUID}
+	for _, env := range svc.Environment {
+		if strings.Contains(env, "=") {
+			args = append(args, "--env", env)
+		}
+	}
+	if svc.Volumes != nil {
+		for _, volume := range svc.Volumes.Volumes {
+			if volume.Source == "" {
+				continue
+			}
+			source := volume.Source
+			if !filepath.IsAbs(source) {
+				if strings.HasPrefix(source, ".") {
+					source = filepath.Join(app.Info.Path, source)
+				} else {
+					// Named volumes live alongside the unpacked app
+					source = filepath.Join(app.Info.Path, "volumes", source)
+				}
+			}
+			os.MkdirAll(source, os.ModePerm)
+			options := "rbind:rw"
+			if volume.AccessMode == "ro" {
+				options = "rbind:ro"
+			}
+			args = append(args, "--mount", fmt.Sprintf("type=bind,src=%s,dst=%s,options=%s", source, volume.Destination, options))
+		}
+	}
+	if svc.WorkingDir != "" {
+		args = append(args, "--cwd", svc.WorkingDir)
+	}
+	args = append(args, normalizeImage(svc.Image), containerdID(app.Info.UUID, name))
+	// ctr replaces the whole image argv when arguments are given, so the
+	// command is only prefixed by an explicit entrypoint
+	args = append(args, svc.Entrypoint...)
+	args = append(args, svc.Command...)
+
+	_, err := p.ctr(nil, args...)
+	return err
+}
+
+// removeService stops the task of a service and deletes its container
+func (p *Containerd) removeService(uuid string, name string, signal string) {
+	id := containerdID(uuid, name)
+	p.ctr(nil, "tasks", "kill", "--signal", signal, id)
+	deadline := time.Now().Add(containerdStopTimeout)
+	for signal != "SIGKILL" {
+		tasks, err := p.tasks()
+		if task, exists := tasks[id]; err != nil || !exists || task.Status == "STOPPED" {
+			break
+		}
+		if time.Now().After(deadline) {
+			p.ctr(nil, "tasks", "kill", "--signal", "SIGKILL", id)
+			break
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	p.ctr(nil, "tasks", "delete", "--force", id)
+	p.ctr(nil, "containers", "delete", id)
+}
+
+// up starts all services of an app in dependency order
+func (p *Containerd) up(app *ContainerdApp) error {
+	for _, name := range app.Order {
+		// Clear out anything left behind by a previous run
+		p.removeService(app.Info.UUID, name, "SIGKILL")
+		if err := p.runService(app, name); err != nil {
+			p.down(app, "SIGKILL")
+			return err
+		}
+	}
+	return nil
+}
+
+// down removes all services of an app in reverse dependency order
+func (p *Containerd) down(app *ContainerdApp, signal string) {
+	for i := len(app.Order) - 1; i >= 0; i-- {
+		p.removeService(app.Info.UUID, app.Order[i], signal)
+	}
+}
+
+// Init ...
+func (p *Containerd) Init() error {
+	if _, err := os.Stat(p.Cfg.Containerd.Address); err != nil {
+		return fmt.Errorf("containerd socket %s not available: %v", p.Cfg.Containerd.Address, err)
+	}
+
+	var data map[string]ContainerdApp
+	utils.Load(p.Cfg.DataVolume+"/application.json", &data)
+	p.Apps = make(map[string]*ContainerdApp)
+	p.PApps = make(map[string]*types.Metadata)
+	for id := range data {
+		info := data[id].Info
+		info.UUID = id
+		logger := appLog(id, info.Name)
+		recoverUpgrade(logger, p.Cfg, id, info.Path)
+		services, order, err := loadComposeServices(info.Path, id)
+		if err != nil {
+			logger.Error("Failed to load the app", "error", err)
+			continue
+		}
+		app := &ContainerdApp{
+			Info:     info,
+			Services: services,
+			Order:    order,
+			Active:   strings.EqualFold(info.Active, "yes"),
+		}
+		p.Apps[id] = app
+		if app.Active {
+			if err = p.up(app); err != nil {
+				logger.Warn("Failed to start, will now attempt to import images", "error", err)
+				if err = p.importImages(logger, info.Path, nil); err == nil {
+					err = p.up(app)
+				}
+			}
+			if err != nil {
+				logger.Error("Failed to start", "error", err)
+			}
+		}
+	}
+	utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+
+	// Start all persistent apps if not already running
+	pimgsPath := p.Cfg.DataVolume + "/application_pimages/"
+	pfiles, _ := ioutil.ReadDir(pimgsPath)
+	for _, pfile := range pfiles {
+		if pfile.IsDir() || !strings.HasSuffix(pfile.Name(), ".tar.gz") {
+			continue
+		}
+		pName := strings.TrimSuffix(pfile.Name(), ".tar.gz")
+		var m types.Metadata
+		if err := utils.Load(pimgsPath+pName+".json", &m); err != nil {
+			logging.Warn("Persistent image is missing metadata json", "name", pName)
+			continue
+		}
+		p.PApps[pName] = &m
+
+		deployed := false
+		for id := range p.Apps {
+			if p.Apps[id].Info.Name == pName {
+				deployed = true
+				break
+			}
+		}
+		if !deployed {
+			f, err := os.Open(pimgsPath + pfile.Name())
+			if err != nil {
+				return err
+			}
+			_, err = p.Deploy(m, f, false)
+			f.Close()
+			if err != nil {
+				logging.Error("Failed to deploy persistent app", "name", pName, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Deploy ...
+func (p *Containerd) Deploy(metadata types.Metadata, file io.Reader, persistent bool) (*types.App, error) {
+	return p.DeployWithProgress(metadata, file, persistent, nil)
+}
+
+// DeployWithProgress ...
+func (p *Containerd) DeployWithProgress(metadata types.Metadata, file io.Reader, persistent bool, progress types.Progress) (*types.App, error) {
+	timer := newPhaseTimer(progress)
+	info, err := p.deploy(metadata, file, persistent, timer.report)
+	timer.done(err)
+	return info, err
+}
+
+func (p *Containerd) deploy(metadata types.Metadata, file io.Reader, persistent bool, progress types.Progress) (*types.App, error) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	uuid, err := utils.NewUUID()
+	if err != nil {
+		return nil, errors.New(types.InvalidID)
+	}
+	logger := appLog(uuid, metadata.Name)
+	logger.Info("Deploying application", "version", metadata.Version)
+
+	pimgsPath := p.Cfg.DataVolume + "/application_pimages/"
+	cleanupPersistent := func() {
+		if persistent {
+			os.Remove(pimgsPath + metadata.Name + ".tar.gz")
+			os.Remove(pimgsPath + metadata.Name + ".json")
+		}
+	}
+	delayStart := strings.EqualFold(metadata.DelayStart, "yes")
+	if persistent {
+		if err = utils.CreatePersistentBackup(file, metadata.Name+".tar.gz", pimgsPath); err != nil {
+			cleanupPersistent()
+			return nil, err
+		}
+		metadata.DelayStart = "no"
+		utils.Save(pimgsPath+metadata.Name+".json", metadata)
+		backup, err := os.Open(pimgsPath + metadata.Name + ".tar.gz")
+		if err != nil {
+			cleanupPersistent()
+			return nil, err
+		}
+		defer backup.Close()
+		file = backup
+	}
+
+	path := p.Cfg.DataVolume + "/" + uuid
+	os.Mkdir(path, os.ModePerm)
+	fail := func(err error) (*types.App, error) {
+		logger.Error("Failed to deploy application", "error", err)
+		cleanupPersistent()
+		os.RemoveAll(path)
+		return nil, err
+	}
+	if err = utils.UnpackWithProgress(file, path, p.Cfg, progress); err != nil {
+		return fail(err)
+	}
+	logger.Info("Application package unpacked")
+
+	services, order, err := loadComposeServices(path, uuid)
+	if err != nil {
+		return fail(err)
+	}
+	ignoreProbes(logger, metadata, path)
+	if err = p.importImages(logger, path, progress); err != nil {
+		return fail(err)
+	}
+	logger.Info("Images imported")
+
+	app := &ContainerdApp{
+		Info: types.App{
+			UUID:    uuid,
+			Name:    metadata.Name,
+			Version: metadata.Version,
+			Path:    path,
+			Monitor: metadata.Monitor,
+			Active:  "no",
+		},
+		Services: services,
+		Order:    order,
+	}
+	if !delayStart {
+		progress.Report(types.PhaseStarting, 0, 0)
+		if err = p.up(app); err != nil {
+			return fail(err)
+		}
+	}
+	app.Active = true
+	app.Info.Active = "yes"
+	p.Apps[uuid] = app
+	p.PApps[metadata.Name] = &metadata
+	utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+
+	info := app.Info
+	return &info, nil
+}
+
+// Upgrade replaces the package of a deployed application in place, keeping
+// its UUID.  The old version is only archived once the new one has stayed
+// running for the upgrade grace period, otherwise it is restored.
+func (p *Containerd) Upgrade(id string, metadata types.Metadata, file io.Reader) (*types.App, error) {
+	app, err := p.beginUpgrade(id)
+	if err != nil {
+		return nil, err
+	}
+	defer p.endUpgrade(app)
+	if metadata.Name != "" && metadata.Name != app.Info.Name {
+		return nil, errors.New("Upgrade must keep the application name " + app.Info.Name)
+	}
+	logger := appLog(id, app.Info.Name)
+	logger.Info("Upgrading application", "version", metadata.Version)
+	staging, _ := upgradePaths(p.Cfg, id)
+	os.RemoveAll(staging)
+	os.Mkdir(staging, os.ModePerm)
+	defer os.RemoveAll(staging)
+	if err := utils.Unpack(file, staging, p.Cfg); err != nil {
+		logger.Error("Failed to unpack the application package", "error", err)
+		return nil, err
+	}
+	logger.Info("Application package unpacked")
+	return p.replace(app, metadata, staging)
+}
+
+// Rollback replaces a deployed application with a version from its history
+// the same way Upgrade does
+func (p *Containerd) Rollback(id string, version string) (*types.App, error) {
+	app, err := p.beginUpgrade(id)
+	if err != nil {
+		return nil, err
+	}
+	defer p.endUpgrade(app)
+	appLog(id, app.Info.Name).Info("Rolling back application", "version", version)
+	staging, _ := upgradePaths(p.Cfg, id)
+	history := NewHistory(p.Cfg)
+	archived, err := history.Take(app.Info.Name, version, staging)
+	if err != nil {
+		return nil, err
+	}
+	metadata := types.Metadata{Name: archived.Name, Version: archived.Version, Monitor: archived.Monitor, Restart: archived.Restart, Probes: archived.Probes}
+	info, err := p.replace(app, metadata, staging)
+	if err != nil {
+		history.Archive(archived, staging)
+	}
+	return info, err
+}
+
+// beginUpgrade marks an app as upgrading, so only one upgrade or rollback
+// replaces it at a time and other operations leave it alone until
+// endUpgrade
+func (p *Containerd) beginUpgrade(id string) (*ContainerdApp, error) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return nil, errors.New(types.InvalidID)
+	}
+	if app.Upgrading {
+		return nil, errors.New(types.Upgrading)
+	}
+	app.Upgrading = true
+	return app, nil
+}
+
+func (p *Containerd) endUpgrade(app *ContainerdApp) {
+	p.Lock.Lock()
+	app.Upgrading = false
+	p.Lock.Unlock()
+}
+
+// History ...
+func (p *Containerd) History(id string) (*types.AppHistory, error) {
+	p.Lock.RLock()
+	defer p.Lock.RUnlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return nil, errors.New(types.InvalidID)
+	}
+	return &types.AppHistory{
+		UUID:     id,
+		Name:     app.Info.Name,
+		Version:  app.Info.Version,
+		Versions: NewHistory(p.Cfg).Versions(app.Info.Name),
+	}, nil
+}
+
+// ignoreProbes warns about the probes of the metadata and of the pod spec of
+// the package at path, which containerd does not run
+func ignoreProbes(logger *logging.Logger, metadata types.Metadata, path string) {
+	probes, err := utils.LoadPodProbes(path)
+	if err != nil {
+		probes = map[string]types.ServiceProbes{}
+	}
+	for name, probe := range metadata.Probes {
+		probes[name] = probe
+	}
+	warnIgnoredProbes(logger, probes, "the containerd provider does not run probes")
+}
+
+// replace swaps the unpacked package in staging in for the running version
+// of app.  On success the previous version is archived, on failure it is
+// restored and the rejected package is left in staging.  The app must be
+// marked as upgrading, the lock is only taken to swap the versions so other
+// apps can be used while the new version is watched.
+func (p *Containerd) replace(app *ContainerdApp, metadata types.Metadata, staging string) (*types.App, error) {
+	id := app.Info.UUID
+	logger := appLog(id, app.Info.Name)
+	services, order, err := loadComposeServices(staging, id)
+	if err != nil {
+		return nil, err
+	}
+	ignoreProbes(logger, metadata, staging)
+	if err = p.importImages(logger, staging, nil); err != nil {
+		return nil, err
+	}
+	logger.Info("Images imported")
+
+	_, backup := upgradePaths(p.Cfg, id)
+	p.Lock.Lock()
+	app.Active = false
+	p.down(app, "SIGTERM")
+	if err = swapIn(app.Info.Path, staging, backup); err != nil {
+		if p.up(app) == nil {
+			app.Active = true
+		}
+		p.Lock.Unlock()
+		return nil, err
+	}
+	upgraded := &ContainerdApp{
+		Info:     app.Info,
+		Services: services,
+		Order:    order,
+	}
+	upgraded.Info.Version = metadata.Version
+	if metadata.Monitor != "" {
+		upgraded.Info.Monitor = metadata.Monitor
+	}
+	err = p.up(upgraded)
+	p.Lock.Unlock()
+
+	started := err == nil
+	if started {
+		err = watchUpgrade(upgradeGracePeriod(p.Cfg), func() error {
+			return p.checkApp(upgraded)
+		})
+	}
+
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	if err != nil && started {
+		p.down(upgraded, "SIGKILL")
+	}
+	if err != nil {
+		logger.Warn("Upgrade failed, rolling back", "error", err)
+		return nil, upgradeFailed(app.Info.Version, err, p.restore(app, backup, staging))
+	}
+	if err = NewHistory(p.Cfg).Archive(AppVersion(app.Info), backup); err != nil {
+		logger.Warn("Failed to archive the previous version", "version", app.Info.Version, "error", err)
+	}
+
+	upgraded.Active = true
+	upgraded.Info.Active = "yes"
+	p.Apps[id] = upgraded
+	if _, persistent := p.PApps[app.Info.Name]; persistent {
+		metadata.Name = app.Info.Name
+		p.PApps[app.Info.Name] = &metadata
+	}
+	utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+	logger.Info("Application upgraded", "version", metadata.Version)
+
+	info := upgraded.Info
+	return &info, nil
+}
+
+// restore brings back and restarts the previous package of an application
+// whose upgrade failed
+func (p *Containerd) restore(app *ContainerdApp, backup string, staging string) error {
+	if err := swapBack(app.Info.Path, backup, staging); err != nil {
+		return err
+	}
+	if err := p.importImages(appLog(app.Info.UUID, app.Info.Name), app.Info.Path, nil); err != nil {
+		return err
+	}
+	if err := p.up(app); err != nil {
+		return err
+	}
+	app.Active = true
+	app.Info.Active = "yes"
+	utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+	return nil
+}
+
+// checkApp fails unless the task of every service is running
+func (p *Containerd) checkApp(app *ContainerdApp) error {
+	tasks, err := p.tasks()
+	if err != nil {
+		return err
+	}
+	for _, name := range app.Order {
+		task, exists := tasks[containerdID(app.Info.UUID, name)]
+		if !exists || task.Status != "RUNNING" {
+			return errors.New("Service " + name + " is not running")
+		}
+	}
+	return nil
+}
+
+// Undeploy ...
+func (p *Containerd) Undeploy(id string) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return errors.New(types.InvalidID)
+	}
+	if app.Upgrading {
+		return errors.New(types.Upgrading)
+	}
+	p.down(app, "SIGTERM")
+	NewHistory(p.Cfg).Archive(AppVersion(app.Info), app.Info.Path)
+	delete(p.Apps, id)
+	utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+	return nil
+}
+
+// PurgePersistent ...
+func (p *Containerd) PurgePersistent(name string) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	if _, exists := p.PApps[name]; !exists {
+		return errors.New(types.InvalidName)
+	}
+	os.Remove(p.Cfg.DataVolume + "/application_pimages/" + name + ".tar.gz")
+	os.Remove(p.Cfg.DataVolume + "/application_pimages/" + name + ".json")
+	delete(p.PApps, name)
+	return nil
+}
+
+// Kill ...
+func (p *Containerd) Kill(id string) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return errors.New(types.InvalidID)
+	}
+	if app.Upgrading {
+		return errors.New(types.Upgrading)
+	}
+	p.down(app, "SIGKILL")
+	os.RemoveAll(app.Info.Path)
+	delete(p.Apps, id)
+	utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+	return nil
+}
+
+// Start ...
+func (p *Containerd) Start(id string) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return errors.New(types.InvalidID)
+	}
+	if app.Upgrading {
+		return errors.New(types.Upgrading)
+	}
+	if err := p.up(app); err != nil {
+		return err
+	}
+	app.Active = true
+	app.Info.Active = "yes"
+	utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+	return nil
+}
+
+// Stop ...
+func (p *Containerd) Stop(id string) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return errors.New(types.InvalidID)
+	}
+	if app.Upgrading {
+		return errors.New(types.Upgrading)
+	}
+	app.Active = false
+	app.Info.Active = "no"
+	p.down(app, "SIGTERM")
+	utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+	return nil
+}
+
+// Restart ...
+func (p *Containerd) Restart(id string) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return errors.New(types.InvalidID)
+	}
+	if app.Upgrading {
+		return errors.New(types.Upgrading)
+	}
+	app.Active = false
+	p.down(app, "SIGTERM")
+	if err := p.up(app); err != nil {
+		return err
+	}
+	app.Active = true
+	app.Info.Active = "yes"
+	utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+	return nil
+}
+
+// GetApplication ...
+func (p *Containerd) GetApplication(id string) (*types.AppDetails, error) {
+	p.Lock.RLock()
+	defer p.Lock.RUnlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return nil, errors.New(types.InvalidID)
+	}
+	tasks, err := p.tasks()
+	if err != nil {
+		return nil, err
+	}
+	details := types.AppDetails{
+		UUID:    app.Info.UUID,
+		Name:    app.Info.Name,
+		Version: app.Info.Version,
+		Monitor: app.Info.Monitor,
+	}
+	for _, name := range app.Order {
+		cid := containerdID(app.Info.UUID, name)
+		task, running := tasks[cid]
+		if !running {
+			continue
+		}
+		// Report running tasks the way Docker does so status checks work
+		// the same for both providers
+		state := strings.Title(strings.ToLower(task.Status))
+		if task.Status == "RUNNING" {
+			state = "Up (pid " + task.PID + ")"
+		}
+		svc := app.Services[name]
+		details.Containers = append(details.Containers, types.Container{
+			ID:      cid,
+			Name:    cid,
+			Command: strings.Join(append(append([]string{}, svc.Entrypoint...), svc.Command...), " "),
+			State:   state,
+			Ports:   strings.Join(svc.Ports, ", "),
+		})
+	}
+	return &details, nil
+}
+
+// ListApplications ...
+func (p *Containerd) ListApplications() types.Applications {
+	p.Lock.RLock()
+	defer p.Lock.RUnlock()
+
+	var response types.Applications
+	for k := range p.Apps {
+		response.Apps = append(response.Apps, p.Apps[k].Info)
+	}
+	return response
+}
+
+// ListPersistentApplications ...
+func (p *Containerd) ListPersistentApplications() types.PersistentApps {
+	p.Lock.RLock()
+	defer p.Lock.RUnlock()
+
+	var response types.PersistentApps
+	for k := range p.PApps {
+		response.PApps = append(response.PApps, *p.PApps[k])
+	}
+	return response
+}
@@ -0,0 +1,285 @@
+package provider
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// fakeCtr stands in for the ctr client, keeping task state as files
+const fakeCtr = `#!/bin/sh
+state="%s"
+echo "$@" >> "$state/calls"
+shift 4
+case "$1 $2" in
+"tasks ls")
+	echo "TASK PID STATUS"
+	for f in "$state"/tasks/*; do
+		if [ -e "$f" ]; then echo "$(basename "$f") 42 $(cat "$f")"; fi
+	done ;;
+"tasks kill")
+	for last; do :; done
+	if [ -e "$state/tasks/$last" ]; then echo STOPPED > "$state/tasks/$last"; fi ;;
+"tasks delete")
+	for last; do :; done
+	rm -f "$state/tasks/$last" ;;
+"images import")
+	cat > /dev/null ;;
+"containers delete") ;;
+*)
+	while [ "$#" -gt 0 ]; do
+		case "$1" in
+		--label|--env|--mount|--cwd) shift 2 ;;
+		run|--*) shift ;;
+		*) break ;;
+		esac
+	done
+	case "$1" in
+	*crash*) echo STOPPED > "$state/tasks/$2" ;;
+	*) echo RUNNING > "$state/tasks/$2" ;;
+	esac ;;
+esac
+`
+
+const testCompose = `version: '2'
+services:
+  web:
+    image: web:1.0
+    depends_on:
+      - db
+    environment:
+      - MODE=test
+  db:
+    image: db
+`
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) {
+	tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))})
+	tw.Write(data)
+}
+
+func gzipTar(files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range files {
+		writeTarFile(tw, name, data)
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func testPackage(compose string) []byte {
+	payload := gzipTar(map[string][]byte{
+		"docker-compose.yml": []byte(compose),
+		"web.tar":            []byte("image"),
+	})
+	return gzipTar(map[string][]byte{
+		"MANIFEST.JSON":  []byte("{}"),
+		"testapp.tar.gz": payload,
+	})
+}
+
+func newTestContainerd(t *testing.T) (*Containerd, string, func()) {
+	dir, err := ioutil.TempDir("", "cappsd-containerd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := filepath.Join(dir, "state")
+	os.MkdirAll(filepath.Join(state, "tasks"), os.ModePerm)
+	os.MkdirAll(filepath.Join(dir, "data"), os.ModePerm)
+
+	ctr := filepath.Join(dir, "ctr")
+	ioutil.WriteFile(ctr, []byte(fmt.Sprintf(fakeCtr, state)), 0755)
+
+	sock := filepath.Join(dir, "containerd.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config.Config
+	cfg.DataVolume = filepath.Join(dir, "data")
+	cfg.Containerd.Address = sock
+	cfg.Containerd.Binary = ctr
+	containerdStopTimeout = time.Second
+
+	p := NewContainerd(cfg)
+	if err = p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return p, state, func() {
+		listener.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestContainerdLifecycle(t *testing.T) {
+	p, state, cleanup := newTestContainerd(t)
+	defer cleanup()
+
+	app, err := p.Deploy(types.Metadata{Name: "testapp", Version: "1.0"}, bytes.NewReader(testPackage(testCompose)), false)
+	if err != nil {
+		t.Fatal("Deploy failed: ", err)
+	}
+
+	calls, _ := ioutil.ReadFile(filepath.Join(state, "calls"))
+	runDB := strings.Index(string(calls), "docker.io/library/db:latest "+app.UUID+"_db")
+	runWeb := strings.Index(string(calls), "--env MODE=test docker.io/library/web:1.0 "+app.UUID+"_web")
+	if runDB < 0 || runWeb < 0 || runDB > runWeb {
+		t.Error("Services were not run in dependency order:\n", string(calls))
+	}
+
+	details, err := p.GetApplication(app.UUID)
+	if err != nil || len(details.Containers) != 2 {
+		t.Fatal("Expected two containers, got ", details, err)
+	}
+	for _, c := range details.Containers {
+		if !strings.HasPrefix(c.State, "Up") {
+			t.Error("Expected container to be up, got ", c.State)
+		}
+	}
+
+	if err = p.Stop(app.UUID); err != nil {
+		t.Error("Stop failed: ", err)
+	}
+	if details, err = p.GetApplication(app.UUID); err != nil || len(details.Containers) != 0 {
+		t.Error("Expected no containers after stop, got ", details, err)
+	}
+
+	if err = p.Start(app.UUID); err != nil {
+		t.Error("Start failed: ", err)
+	}
+	if err = p.Kill(app.UUID); err != nil {
+		t.Error("Kill failed: ", err)
+	}
+	if len(p.ListApplications().Apps) != 0 {
+		t.Error("Expected no applications after kill")
+	}
+	if _, err = os.Stat(app.Path); !os.IsNotExist(err) {
+		t.Error("Expected app directory to be removed")
+	}
+}
+
+func TestContainerdUpgrade(t *testing.T) {
+	p, state, cleanup := newTestContainerd(t)
+	defer cleanup()
+	p.Cfg.UpgradeGracePeriod = 1
+	p.Cfg.HistoryRetention = 2
+	upgradePollInterval = 100 * time.Millisecond
+
+	app, err := p.Deploy(types.Metadata{Name: "testapp", Version: "1.0"}, bytes.NewReader(testPackage(testCompose)), false)
+	if err != nil {
+		t.Fatal("Deploy failed: ", err)
+	}
+
+	v2 := strings.Replace(testCompose, "web:1.0", "web:2.0", 1)
+	done := make(chan error)
+	var upgraded *types.App
+	go func() {
+		var err error
+		upgraded, err = p.Upgrade(app.UUID, types.Metadata{Name: "testapp", Version: "2.0"}, bytes.NewReader(testPackage(v2)))
+		done <- err
+	}()
+
+	// The app is left alone while it is upgraded but the provider keeps
+	// serving calls during the grace period
+	for waiting := true; waiting; {
+		time.Sleep(10 * time.Millisecond)
+		p.Lock.RLock()
+		waiting = !p.Apps[app.UUID].Upgrading
+		p.Lock.RUnlock()
+	}
+	time.Sleep(300 * time.Millisecond)
+	start := time.Now()
+	if apps := p.ListApplications(); len(apps.Apps) != 1 || time.Since(start) > 500*time.Millisecond {
+		t.Error("Expected the apps to be listed during the grace period, took ", time.Since(start))
+	}
+	if err = p.Stop(app.UUID); err == nil || err.Error() != types.Upgrading {
+		t.Error("Expected the upgrading app not to be stopped, got ", err)
+	}
+	if _, err = p.Rollback(app.UUID, "1.0"); err == nil || err.Error() != types.Upgrading {
+		t.Error("Expected a second upgrade to be refused, got ", err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal("Upgrade failed: ", err)
+	}
+	if upgraded.UUID != app.UUID || upgraded.Version != "2.0" || upgraded.Path != app.Path {
+		t.Error("Expected upgrade to keep UUID and path, got ", upgraded)
+	}
+	compose, _ := ioutil.ReadFile(filepath.Join(app.Path, "docker-compose.yml"))
+	if !strings.Contains(string(compose), "web:2.0") {
+		t.Error("Expected the new package to be swapped in")
+	}
+
+	os.Remove(filepath.Join(state, "calls"))
+	crash := strings.Replace(testCompose, "web:1.0", "crash:3.0", 1)
+	_, err = p.Upgrade(app.UUID, types.Metadata{Name: "testapp", Version: "3.0"}, bytes.NewReader(testPackage(crash)))
+	if err == nil || !strings.Contains(err.Error(), "rolled back to version 2.0") {
+		t.Fatal("Expected crashing upgrade to be rolled back, got ", err)
+	}
+	calls, _ := ioutil.ReadFile(filepath.Join(state, "calls"))
+	if !strings.Contains(string(calls), "docker.io/library/web:2.0 "+app.UUID+"_web") {
+		t.Error("Expected previous version to be started again:\n", string(calls))
+	}
+	compose, _ = ioutil.ReadFile(filepath.Join(app.Path, "docker-compose.yml"))
+	details, err := p.GetApplication(app.UUID)
+	if err != nil || details.Version != "2.0" || len(details.Containers) != 2 || !strings.Contains(string(compose), "web:2.0") {
+		t.Error("Expected version 2.0 to be running after rollback, got ", details, err)
+	}
+	if _, err = os.Stat(app.Path + ".rollback"); !os.IsNotExist(err) {
+		t.Error("Expected rollback directory to be removed")
+	}
+
+	history, err := p.History(app.UUID)
+	if err != nil || len(history.Versions) != 1 || history.Versions[0].Version != "1.0" {
+		t.Fatal("Expected version 1.0 in the history, got ", history, err)
+	}
+	if _, err = p.Rollback(app.UUID, "1.0"); err != nil {
+		t.Fatal("Rollback failed: ", err)
+	}
+	compose, _ = ioutil.ReadFile(filepath.Join(app.Path, "docker-compose.yml"))
+	history, _ = p.History(app.UUID)
+	if !strings.Contains(string(compose), "web:1.0") || history.Version != "1.0" || history.Versions[0].Version != "2.0" {
+		t.Error("Expected version 1.0 to be running with 2.0 archived, got ", history)
+	}
+
+	if _, err = p.Upgrade(app.UUID, types.Metadata{Name: "other", Version: "4.0"}, bytes.NewReader(testPackage(testCompose))); err == nil {
+		t.Error("Expected upgrade to a different application name to fail")
+	}
+}
+
+func TestContainerdInitNoSocket(t *testing.T) {
+	var cfg config.Config
+	cfg.Containerd.Address = "/nonexistent/containerd.sock"
+	if err := NewContainerd(cfg).Init(); err == nil {
+		t.Error("Expected Init to fail without a containerd socket")
+	}
+}
+
+func TestNormalizeImage(t *testing.T) {
+	tests := map[string]string{
+		"hello":                     "docker.io/library/hello:latest",
+		"hello:1.0":                 "docker.io/library/hello:1.0",
+		"user/app":                  "docker.io/user/app:latest",
+		"registry.local:5000/app:2": "registry.local:5000/app:2",
+		"localhost/app":             "localhost/app:latest",
+	}
+	for in, expected := range tests {
+		if out := normalizeImage(in); out != expected {
+			t.Errorf("normalizeImage(%s) = %s, expected %s", in, out, expected)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	composecfg "github.com/docker/libcompose/config"
+)
+
+// depGraph is the dependency graph of the services of a compose project,
+// built from depends_on and links.  Dependencies on services outside the
+// project are ignored.
+type depGraph struct {
+	order []string
+	deps  map[string][]string
+}
+
+// newDepGraph builds the graph and the start order of the services, every
+// service coming after the services it depends on or links to
+func newDepGraph(services map[string]*composecfg.ServiceConfig) (*depGraph, error) {
+	g := &depGraph{deps: make(map[string][]string)}
+	names := make([]string, 0, len(services))
+	for name, svc := range services {
+		names = append(names, name)
+		deps := append([]string{}, svc.DependsOn...)
+		for _, link := range svc.Links {
+			deps = append(deps, strings.SplitN(link, ":", 2)[0])
+		}
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if _, known := services[dep]; known {
+				g.deps[name] = append(g.deps[name], dep)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	visited := make(map[string]int)
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch visited[name] {
+		case 1:
+			return errors.New("Circular service dependency involving " + name)
+		case 2:
+			return nil
+		}
+		visited[name] = 1
+		for _, dep := range g.deps[name] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		visited[name] = 2
+		g.order = append(g.order, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// startOrder sorts services so that every service comes after the services
+// it depends on or links to
+func startOrder(services map[string]*composecfg.ServiceConfig) ([]string, error) {
+	g, err := newDepGraph(services)
+	if err != nil {
+		return nil, err
+	}
+	return g.order, nil
+}
+
+// dependents lists the services that depend on name, directly or through
+// other services, in start order
+func (g *depGraph) dependents(name string) []string {
+	affected := map[string]bool{name: true}
+	var dependents []string
+	// Dependencies come first in the start order, so one pass finds every
+	// service depending on an affected one
+	for _, service := range g.order {
+		if affected[service] {
+			continue
+		}
+		for _, dep := range g.deps[service] {
+			if affected[dep] {
+				affected[service] = true
+				dependents = append(dependents, service)
+				break
+			}
+		}
+	}
+	return dependents
+}
@@ -6,9 +6,6 @@ import (
 	"os"
 	"sync"
 	"time"
-	"log"
-
-	"fmt"
 
 	"encoding/json"
 	"io/ioutil"
@@ -19,22 +16,26 @@ import (
 	"github.com/docker/libcompose/docker"
 	"github.com/docker/libcompose/docker/ctx"
 	"github.com/docker/libcompose/project"
-	"github.com/docker/libcompose/project/events"
 	"github.com/docker/libcompose/project/options"
 	"golang.org/x/net/context"
 
 	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
 	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
 	"github.build.ge.com/PredixEdgeOS/container-app-service/utils"
 )
 
 // ComposeApp ...
 type ComposeApp struct {
-	Info    types.App                  `json:"info"`
-	Client  project.APIProject         `json:"-"`
-	Events  chan events.ContainerEvent `json:"-"`
-	Monitor bool                       `json:"-"`
-	Active  bool                       `json:"-"`
+	Info     types.App          `json:"info"`
+	Client   project.APIProject `json:"-"`
+	Monitor  bool               `json:"-"`
+	Active   bool               `json:"-"`
+	Restarts restartStates      `json:"-"`
+	Graph    *depGraph          `json:"-"`
+	// Upgrading is set while an upgrade or rollback replaces the app, which
+	// holds the lock only to swap the versions
+	Upgrading bool `json:"-"`
 }
 
 // Docker ...
@@ -44,19 +45,31 @@ type Docker struct {
 	PApps        map[string]*types.Metadata
 	Lock         sync.RWMutex
 	IsHealthyMap map[string](map[string]bool)
+	listener     *EventListener
+	broker       *Broker
 }
 
-// EventListener ...
-type EventListener struct {
-	provider *Docker
+// The docker client shared by the monitor, the probes and the API calls on
+// containers.  A client per call would keep a connection to the daemon open
+// for each.
+var (
+	sharedClient     *client.Client
+	sharedClientErr  error
+	sharedClientOnce sync.Once
+)
+
+// dockerClient ...
+func dockerClient() (*client.Client, error) {
+	sharedClientOnce.Do(func() {
+		sharedClient, sharedClientErr = client.NewEnvClient()
+	})
+	return sharedClient, sharedClientErr
 }
 
-// NewListener ...
-func NewListener(d *Docker) {
-	l := EventListener{
-		provider: d,
-	}
-	go l.start()
+func init() {
+	Register("docker", func(c config.Config) Provider {
+		return NewDocker(c)
+	})
 }
 
 // LoadImage loads a docker image from a tar ball file
@@ -99,38 +112,15 @@ func LoadImage(infilePath *string) error {
 	return err
 }
 
-// start ...
-func (l *EventListener) start() {
-	for id := range l.provider.Apps {
-		l.provider.IsHealthyMap[id] = make(map[string]bool)
-		app := l.provider.Apps[id].Client.(*project.Project)
-		for name := range app.ServiceConfigs.All() {
-			l.provider.IsHealthyMap[id][name] = true
-		}
-	}
-
-	for {
-		l.provider.Lock.RLock()
-		for id := range l.provider.Apps {
-			eventstream := l.provider.Apps[id].Events
-			select {
-			case event := <-eventstream:
-				if l.provider.Apps[id].Active == true && l.provider.Apps[id].Monitor == true {
-					if event.Event == "health_status: unhealthy" {
-						l.provider.IsHealthyMap[id][event.Service] = false
-						l.provider.Apps[id].Client.Restart(context.Background(), 5, event.Service)
-					} else if event.Event == "health_status: healthy" {
-						l.provider.IsHealthyMap[id][event.Service] = true
-					} else if l.provider.IsHealthyMap[id][event.Service] == true && event.Event == "stop" {
-						l.provider.Apps[id].Client.Start(context.Background(), event.Service)
-					}
-				}
-			default:
-			}
-		}
-		l.provider.Lock.RUnlock()
-		time.Sleep(1000 * time.Millisecond)
+// newProject creates the compose project for an unpacked application
+func newProject(path string, uuid string) (project.APIProject, error) {
+	c := ctx.Context{
+		Context: project.Context{
+			ComposeFiles: []string{path + "/docker-compose.yml"},
+			ProjectName:  uuid,
+		},
 	}
+	return docker.NewProject(&c, nil)
 }
 
 // NewDocker ...
@@ -140,6 +130,8 @@ func NewDocker(c config.Config) *Docker {
 	provider.PApps = make(map[string]*types.Metadata)
 	provider.IsHealthyMap = make(map[string](map[string]bool))
 	provider.Cfg = c
+	provider.listener = NewListener(provider)
+	provider.broker = NewBroker()
 	return provider
 }
 
@@ -158,11 +150,16 @@ func (p *Docker) Init() error {
 				Path:    data[id].Info.Path,
 				Monitor: data[id].Info.Monitor,
 				Active:  data[id].Info.Active,
+				Images:  data[id].Info.Images,
+				Restart: data[id].Info.Restart,
+				Probes:  data[id].Info.Probes,
 			},
 			Monitor: strings.EqualFold(data[id].Info.Monitor, "yes"),
 			Active:  strings.EqualFold(data[id].Info.Active, "yes"),
 		}
 
+		logger := appLog(id, p.Apps[id].Info.Name)
+		recoverUpgrade(logger, p.Cfg, id, p.Apps[id].Info.Path)
 		composeFile := p.Apps[id].Info.Path + "/docker-compose.yml"
 		c := ctx.Context{
 			Context: project.Context{
@@ -175,6 +172,14 @@ func (p *Docker) Init() error {
 		var prj project.APIProject
 		if prj, err = docker.NewProject(&c, nil); err == nil {
 			p.Apps[id].Client = prj
+			p.IsHealthyMap[id] = make(map[string]bool)
+			for name := range prj.(*project.Project).ServiceConfigs.All() {
+				p.IsHealthyMap[id][name] = true
+			}
+			if p.Apps[id].Info.Restart == nil {
+				// Apps deployed before restart policies follow their labels
+				p.Apps[id].Info.Restart, _ = restartPolicies(types.Metadata{}, prj.(*project.Project).ServiceConfigs.All())
+			}
 			if p.Apps[id].Active == true {
 				// Stop running docker containers for the app first
 				if err = p.Apps[id].Client.Down(context.Background(), options.Down{}); err != nil {
@@ -186,29 +191,18 @@ func (p *Docker) Init() error {
 				// If we failed to start the container lets attempt to reload the image if its available
 				// since the user may have inadvertently deleted it.
 				if err != nil {
-					log.Println(err, " - Will now attempt to load image from disk instead")
-					files, err := ioutil.ReadDir(p.Apps[id].Info.Path)
-			                if err == nil {
-						for _, f := range files {
-							if strings.Contains(f.Name(), ".tar") {
-								var infile = new(string)
-								*infile = p.Apps[id].Info.Path + "/" + f.Name()
-								err = LoadImage(infile)
-								if err != nil {
-									log.Println("Failed to load: ", *infile)
-								}
-							}
-						}
-						// Attempt to start app again regardless if loading occurred (maybe we will get lucky)
-						err = prj.Up(context.Background(), options.Up{})
+					logger.Warn("Failed to start, will now attempt to load images from disk instead", "error", err)
+					if _, err = loadImages(logger, p.Apps[id].Info.Path, nil); err != nil {
+						logger.Error("Failed to load images", "error", err)
 					}
+					// Attempt to start app again regardless if loading occurred (maybe we will get lucky)
+					err = prj.Up(context.Background(), options.Up{})
 				}
 
 				if err == nil {
-					eventstream, _ := p.Apps[id].Client.Events(context.Background())
-					p.Apps[id].Events = eventstream
+					p.monitor(id)
 				} else {
-					log.Println("Failed to start: ", p.Apps[id].Info.Name)
+					logger.Error("Failed to start", "error", err)
 				}
 
 			}
@@ -254,20 +248,36 @@ func (p *Docker) Init() error {
 		}
 	}
 
-	NewListener(p)
 	return nil
 }
 
 // Deploy ...
 func (p *Docker) Deploy(metadata types.Metadata, file io.Reader, persistent bool) (*types.App, error) {
+	return p.DeployWithProgress(metadata, file, persistent, nil)
+}
+
+// DeployWithProgress ...
+func (p *Docker) DeployWithProgress(metadata types.Metadata, file io.Reader, persistent bool, progress types.Progress) (*types.App, error) {
+	timer := newPhaseTimer(progress)
+	info, err := p.deploy(metadata, file, persistent, timer.report)
+	timer.done(err)
+	p.broker.deployed("", metadata, info, err)
+	return info, err
+}
+
+func (p *Docker) deploy(metadata types.Metadata, file io.Reader, persistent bool, progress types.Progress) (*types.App, error) {
+	// Unused images are removed once the lock is released
+	var unused []types.Image
+	defer func() { p.removeUnused(unused) }()
 	p.Lock.Lock()
 	defer p.Lock.Unlock()
-	fmt.Println("Deploying Application")
 	var err error
 	var uuid string
 	pimgs_path := p.Cfg.DataVolume + "/application_pimages/" 
 
 	if uuid, err = utils.NewUUID(); err == nil {
+		logger := appLog(uuid, metadata.Name)
+		logger.Info("Deploying application", "version", metadata.Version)
 		//If image is expected to be persistent then make sure we back
 		//  it up so it is always available, need to do this now while
 		//  file context is still valid
@@ -288,9 +298,9 @@ func (p *Docker) Deploy(metadata types.Metadata, file io.Reader, persistent bool
 		}
 		path := p.Cfg.DataVolume + "/" + uuid
 		os.Mkdir(path, os.ModePerm)
-		err = utils.Unpack(file, path, p.Cfg)
+		err = utils.UnpackWithProgress(file, path, p.Cfg, progress)
 		if err != nil {
-			fmt.Println(err)
+			logger.Error("Failed to unpack the application package", "error", err)
 			if persistent {
 				os.Remove(pimgs_path + metadata.Name + ".tar.gz")
 	                        os.Remove(pimgs_path + metadata.Name + ".json")
@@ -298,44 +308,35 @@ func (p *Docker) Deploy(metadata types.Metadata, file io.Reader, persistent bool
 			os.RemoveAll(path)
 			return nil, err
 		}
-		fmt.Println("Application package unpacked.")
-		composeFile := path + "/docker-compose.yml"
-		fmt.Println("Loading images...")
-		files, err := ioutil.ReadDir(path)
-		if err == nil {
-			for _, f := range files {
-				if strings.Contains(f.Name(), ".tar") {
-					var infile = new(string)
-					*infile = path + "/" + f.Name()
-					fmt.Printf("  Loading image %s\n", f.Name())
-					err = LoadImage(infile)
-					if err != nil {
-						if persistent {
-							os.Remove(pimgs_path + metadata.Name + ".tar.gz")
-							os.Remove(pimgs_path + metadata.Name + ".json")
-						}
-						os.RemoveAll(path)
-						return nil, err
-					}
-				}
+		logger.Info("Application package unpacked")
+		var images []types.Image
+		images, err = loadImages(logger, path, progress)
+		if err != nil {
+			unused = dropImages(p.Cfg, uuid, images)
+			if persistent {
+				os.Remove(pimgs_path + metadata.Name + ".tar.gz")
+				os.Remove(pimgs_path + metadata.Name + ".json")
 			}
-		} else {
-		       return nil, err
-		}
-		fmt.Println("Images loaded.")
-		
-		c := ctx.Context{
-			Context: project.Context{
-				ComposeFiles: []string{composeFile},
-				ProjectName:  uuid,
-			},
+			os.RemoveAll(path)
+			return nil, err
 		}
+		logger.Info("Images loaded", "images", len(images))
 
 		var prj project.APIProject
-		prj, err = docker.NewProject(&c, nil)
+		prj, err = newProject(path, uuid)
+		var policies map[string]types.RestartPolicy
+		var probes map[string]types.ServiceProbes
+		if err == nil {
+			policies, err = restartPolicies(metadata, prj.(*project.Project).ServiceConfigs.All())
+		}
+		if err == nil {
+			probes, err = probePolicies(metadata, path, prj.(*project.Project).ServiceConfigs.All())
+		}
 		if err == nil {
 			isMonitor := false
-			if strings.EqualFold(metadata.Monitor, "yes") {
+			if !strings.EqualFold(metadata.Monitor, "yes") {
+				warnIgnoredProbes(logger, probes, "the app is not monitored")
+			} else {
 				isMonitor = true
 				p.IsHealthyMap[uuid] = make(map[string]bool)
 				app := prj.(*project.Project)
@@ -351,6 +352,9 @@ func (p *Docker) Deploy(metadata types.Metadata, file io.Reader, persistent bool
 					Path:    path,
 					Monitor: metadata.Monitor,
 					Active:  "no",
+					Images:  images,
+					Restart: policies,
+					Probes:  probes,
 				},
 				Client:  prj,
 				Monitor: isMonitor,
@@ -361,17 +365,26 @@ func (p *Docker) Deploy(metadata types.Metadata, file io.Reader, persistent bool
 			// attempt to run app
 			err = nil
 			if !DelayStart {
+				progress.Report(types.PhaseStarting, 0, 0)
 				err = prj.Up(context.Background(), options.Up{})
 			}
 			if err == nil {
-				eventstream, _ := p.Apps[uuid].Client.Events(context.Background())
-				p.Apps[uuid].Events = eventstream
+				p.monitor(uuid)
 				p.Apps[uuid].Active = true
 				p.Apps[uuid].Info.Active = "yes"
 				utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
 				info := p.Apps[uuid].Info
 				p.PApps[metadata.Name] = &metadata
 
+				refs := loadImageRefs(p.Cfg)
+				refs.claim(uuid, images)
+				if persistent {
+					refs.claim(persistentOwner(metadata.Name), images)
+				}
+				if err = refs.save(); err != nil {
+					logger.Warn("Failed to save image references", "error", err)
+				}
+
 				return &info, nil
 			}
 			app, _ := p.Apps[uuid]
@@ -383,7 +396,9 @@ func (p *Docker) Deploy(metadata types.Metadata, file io.Reader, persistent bool
 				os.Remove(pimgs_path + metadata.Name + ".json")
 			}
 			delete(p.Apps, app.Info.UUID)
+			delete(p.IsHealthyMap, app.Info.UUID)
 			utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+			unused = dropImages(p.Cfg, uuid, images)
 			return nil, err
 		}
 		if persistent {
@@ -391,24 +406,286 @@ func (p *Docker) Deploy(metadata types.Metadata, file io.Reader, persistent bool
 			os.Remove(pimgs_path + metadata.Name + ".json")
 		}
 		os.RemoveAll(path)
+		unused = dropImages(p.Cfg, uuid, images)
 		return nil, err
 	}
 
 	return nil, errors.New(types.InvalidID)
 }
 
+// Upgrade replaces the package of a deployed application in place.  The new
+// package is unpacked and its images loaded while the old version keeps
+// running, then the directories are swapped and the new version is started
+// under the same UUID.  If it fails to start, or any service stops or turns
+// unhealthy within the upgrade grace period, the previous directory and
+// images are restored and the old version is started again.
+func (p *Docker) Upgrade(id string, metadata types.Metadata, file io.Reader) (*types.App, error) {
+	info, err := p.upgrade(id, metadata, file)
+	p.broker.deployed(id, metadata, info, err)
+	return info, err
+}
+
+func (p *Docker) upgrade(id string, metadata types.Metadata, file io.Reader) (*types.App, error) {
+	app, err := p.beginUpgrade(id)
+	if err != nil {
+		return nil, err
+	}
+	defer p.endUpgrade(app)
+	if metadata.Name != "" && metadata.Name != app.Info.Name {
+		return nil, errors.New("Upgrade must keep the application name " + app.Info.Name)
+	}
+	logger := appLog(id, app.Info.Name)
+	logger.Info("Upgrading application", "version", metadata.Version)
+	staging, _ := upgradePaths(p.Cfg, id)
+	os.RemoveAll(staging)
+	os.Mkdir(staging, os.ModePerm)
+	defer os.RemoveAll(staging)
+	if err := utils.Unpack(file, staging, p.Cfg); err != nil {
+		logger.Error("Failed to unpack the application package", "error", err)
+		return nil, err
+	}
+	logger.Info("Application package unpacked")
+	return p.replace(app, metadata, staging)
+}
+
+// Rollback replaces a deployed application with a version from its history
+// the same way Upgrade does.  The replaced version is archived in its place.
+func (p *Docker) Rollback(id string, version string) (*types.App, error) {
+	info, err := p.rollback(id, version)
+	p.broker.deployed(id, types.Metadata{Version: version}, info, err)
+	return info, err
+}
+
+func (p *Docker) rollback(id string, version string) (*types.App, error) {
+	app, err := p.beginUpgrade(id)
+	if err != nil {
+		return nil, err
+	}
+	defer p.endUpgrade(app)
+	appLog(id, app.Info.Name).Info("Rolling back application", "version", version)
+	staging, _ := upgradePaths(p.Cfg, id)
+	history := NewHistory(p.Cfg)
+	archived, err := history.Take(app.Info.Name, version, staging)
+	if err != nil {
+		return nil, err
+	}
+	metadata := types.Metadata{Name: archived.Name, Version: archived.Version, Monitor: archived.Monitor, Restart: archived.Restart, Probes: archived.Probes}
+	info, err := p.replace(app, metadata, staging)
+	if err != nil {
+		history.Archive(archived, staging)
+	}
+	return info, err
+}
+
+// beginUpgrade marks an app as upgrading, so only one upgrade or rollback
+// replaces it at a time and other operations leave it alone until
+// endUpgrade
+func (p *Docker) beginUpgrade(id string) (*ComposeApp, error) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return nil, errors.New(types.InvalidID)
+	}
+	if app.Upgrading {
+		return nil, errors.New(types.Upgrading)
+	}
+	app.Upgrading = true
+	return app, nil
+}
+
+func (p *Docker) endUpgrade(app *ComposeApp) {
+	p.Lock.Lock()
+	app.Upgrading = false
+	p.Lock.Unlock()
+}
+
+// History ...
+func (p *Docker) History(id string) (*types.AppHistory, error) {
+	p.Lock.RLock()
+	defer p.Lock.RUnlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return nil, errors.New(types.InvalidID)
+	}
+	return &types.AppHistory{
+		UUID:     id,
+		Name:     app.Info.Name,
+		Version:  app.Info.Version,
+		Versions: NewHistory(p.Cfg).Versions(app.Info.Name),
+	}, nil
+}
+
+// replace swaps the unpacked package in staging in for the running version
+// of app.  On success the previous version is archived, on failure it is
+// restored and the rejected package is left in staging.  The app must be
+// marked as upgrading, the lock is only taken to swap the versions so other
+// apps can be used while the new version is watched.
+func (p *Docker) replace(app *ComposeApp, metadata types.Metadata, staging string) (*types.App, error) {
+	var unused []types.Image
+	defer func() { p.removeUnused(unused) }()
+	logger := appLog(app.Info.UUID, app.Info.Name)
+	images, err := loadImages(logger, staging, nil)
+	var staged project.APIProject
+	var policies map[string]types.RestartPolicy
+	var probes map[string]types.ServiceProbes
+	if err == nil {
+		logger.Info("Images loaded", "images", len(images))
+		staged, err = newProject(staging, app.Info.UUID)
+	}
+	if err == nil {
+		policies, err = restartPolicies(metadata, staged.(*project.Project).ServiceConfigs.All())
+	}
+	if err == nil {
+		probes, err = probePolicies(metadata, staging, staged.(*project.Project).ServiceConfigs.All())
+	}
+	if err != nil {
+		// Image references are only updated under the lock
+		p.Lock.Lock()
+		unused = dropImages(p.Cfg, app.Info.UUID+".upgrade", images)
+		p.Lock.Unlock()
+		return nil, err
+	}
+	if monitor := metadata.Monitor; !strings.EqualFold(monitor, "yes") && (monitor != "" || !app.Monitor) {
+		warnIgnoredProbes(logger, probes, "the app is not monitored")
+	}
+
+	// Everything the new version needs is in place, take the old one down
+	// and swap the unpacked directories
+	id := app.Info.UUID
+	_, backup := upgradePaths(p.Cfg, id)
+	p.Lock.Lock()
+	app.Active = false
+	p.listener.unwatch(id)
+	app.Client.Down(context.Background(), options.Down{})
+	if err := swapIn(app.Info.Path, staging, backup); err != nil {
+		if app.Client.Up(context.Background(), options.Up{}) == nil {
+			app.Active = true
+			p.monitor(id)
+		}
+		unused = dropImages(p.Cfg, id+".upgrade", images)
+		p.Lock.Unlock()
+		return nil, err
+	}
+	prj, err := newProject(app.Info.Path, id)
+	if err == nil {
+		err = prj.Up(context.Background(), options.Up{})
+	}
+	p.Lock.Unlock()
+
+	if err == nil {
+		err = watchUpgrade(upgradeGracePeriod(p.Cfg), func() error {
+			return checkProject(prj)
+		})
+	}
+
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	if err != nil && prj != nil {
+		prj.Down(context.Background(), options.Down{})
+		prj.Delete(context.Background(), options.Delete{})
+	}
+	if err != nil {
+		logger.Warn("Upgrade failed, rolling back", "error", err)
+		err = upgradeFailed(app.Info.Version, err, p.restore(app, backup, staging))
+
+		// Drop the images only the rejected version loaded
+		unused = dropImages(p.Cfg, id+".upgrade", images)
+		return nil, err
+	}
+	if err = NewHistory(p.Cfg).Archive(AppVersion(app.Info), backup); err != nil {
+		logger.Warn("Failed to archive the previous version", "version", app.Info.Version, "error", err)
+	}
+
+	app.Client = prj
+	app.Info.Version = metadata.Version
+	app.Info.Images = images
+	app.Info.Restart = policies
+	app.Info.Probes = probes
+	refs := loadImageRefs(p.Cfg)
+	refs.claim(id, images)
+	unused = refs.unused()
+	if metadata.Monitor != "" {
+		app.Info.Monitor = metadata.Monitor
+		app.Monitor = strings.EqualFold(metadata.Monitor, "yes")
+	}
+	if app.Monitor {
+		p.IsHealthyMap[id] = make(map[string]bool)
+		for name := range prj.(*project.Project).ServiceConfigs.All() {
+			p.IsHealthyMap[id][name] = true
+		}
+	}
+	p.monitor(id)
+	app.Active = true
+	app.Info.Active = "yes"
+	if _, persistent := p.PApps[app.Info.Name]; persistent {
+		metadata.Name = app.Info.Name
+		p.PApps[app.Info.Name] = &metadata
+	}
+	utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+	logger.Info("Application upgraded", "version", metadata.Version)
+
+	info := app.Info
+	return &info, nil
+}
+
+// restore brings back the previous package of an application whose upgrade
+// failed, reloading its images in case the new package replaced them
+func (p *Docker) restore(app *ComposeApp, backup string, staging string) error {
+	if err := swapBack(app.Info.Path, backup, staging); err != nil {
+		return err
+	}
+	if _, err := loadImages(appLog(app.Info.UUID, app.Info.Name), app.Info.Path, nil); err != nil {
+		return err
+	}
+	if err := app.Client.Up(context.Background(), options.Up{}); err != nil {
+		return err
+	}
+	p.monitor(app.Info.UUID)
+	app.Active = true
+	app.Info.Active = "yes"
+	utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+	return nil
+}
+
+// checkProject fails unless every container of the project is up and
+// healthy
+func checkProject(prj project.APIProject) error {
+	info, err := prj.Ps(context.Background())
+	if err != nil {
+		return err
+	}
+	var containers []types.Container
+	for _, service := range info {
+		containers = append(containers, types.Container{Name: service["Name"], State: service["State"]})
+	}
+	return checkContainers(containers)
+}
+
 // Undeploy ...
 func (p *Docker) Undeploy(id string) error {
+	// Unused images are removed once the lock is released
+	var unused []types.Image
+	defer func() { p.removeUnused(unused) }()
 	p.Lock.Lock()
 	defer p.Lock.Unlock()
 
+	if app, exists := p.Apps[id]; exists && app.Upgrading {
+		return errors.New(types.Upgrading)
+	}
+
 	app, exists := p.Apps[id]
 	if exists {
+		p.listener.unwatch(id)
 		app.Client.Down(context.Background(), options.Down{})
 		app.Client.Delete(context.Background(), options.Delete{})
-		os.RemoveAll(app.Info.Path)
+		NewHistory(p.Cfg).Archive(AppVersion(app.Info), app.Info.Path)
 		delete(p.Apps, app.Info.UUID)
 		utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+		unused = p.releaseImages(id)
+		p.broker.Publish(appEvent(types.EventPurged, app.Info))
 
 		return nil
 	}
@@ -418,6 +695,9 @@ func (p *Docker) Undeploy(id string) error {
 
 // PurgePersistent ...
 func (p *Docker) PurgePersistent(name string) error {
+	// Unused images are removed once the lock is released
+	var unused []types.Image
+	defer func() { p.removeUnused(unused) }()
 	p.Lock.Lock()
 	defer p.Lock.Unlock()
 
@@ -426,24 +706,93 @@ func (p *Docker) PurgePersistent(name string) error {
 		os.Remove(p.Cfg.DataVolume+"/application_pimages/"+name+".tar.gz")
 		os.Remove(p.Cfg.DataVolume+"/application_pimages/"+name+".json")
 		delete(p.PApps, name)
+		unused = p.releaseImages(persistentOwner(name))
 		return nil
 	}
 
 	return errors.New(types.InvalidName)
 }
 
+// monitor starts watching the events of an app with fresh restart counts
+// and its dependency graph, and probing the services of monitored apps, the
+// caller must hold the lock
+func (p *Docker) monitor(id string) {
+	app := p.Apps[id]
+	app.Restarts = restartStates{}
+	if prj, ok := app.Client.(*project.Project); ok {
+		graph, err := newDepGraph(prj.ServiceConfigs.All())
+		if err != nil {
+			appLog(id, app.Info.Name).Warn("Dependents will not be restarted", "error", err)
+		}
+		app.Graph = graph
+	}
+	var probes map[string]types.ServiceProbes
+	if app.Monitor {
+		probes = app.Info.Probes
+	}
+	p.listener.watch(id, app.Info.Name, app.Client, probes)
+}
+
+// releaseImages drops the references of owner and returns the images no
+// application uses anymore, the caller must hold the lock
+func (p *Docker) releaseImages(owner string) []types.Image {
+	refs := loadImageRefs(p.Cfg)
+	refs.release(owner)
+	return refs.unused()
+}
+
+// removeUnused removes images returned by releaseImages or dropImages, the
+// caller must not hold the lock
+func (p *Docker) removeUnused(images []types.Image) {
+	if report := removeImages(p.Cfg, &p.Lock, images); len(report.Images) > 0 {
+		logging.Info("Removed unused images", "images", len(report.Images), "reclaimed", report.SpaceReclaimed)
+	}
+}
+
+// PruneImages removes the images cappsd loaded that no deployed or
+// persistent application uses, including references left behind by
+// applications that are gone
+func (p *Docker) PruneImages() (*types.PruneReport, error) {
+	p.Lock.Lock()
+	refs := loadImageRefs(p.Cfg)
+	refs.retain(func(owner string) bool {
+		if _, exists := p.Apps[owner]; exists {
+			return true
+		}
+		for name := range p.PApps {
+			if owner == persistentOwner(name) {
+				return true
+			}
+		}
+		return false
+	})
+	unused := refs.unused()
+	p.Lock.Unlock()
+	return removeImages(p.Cfg, &p.Lock, unused), nil
+}
+
 // Kill ...
 func (p *Docker) Kill(id string) error {
+	// Unused images are removed once the lock is released
+	var unused []types.Image
+	defer func() { p.removeUnused(unused) }()
 	p.Lock.Lock()
 	defer p.Lock.Unlock()
 
+	if app, exists := p.Apps[id]; exists && app.Upgrading {
+		return errors.New(types.Upgrading)
+	}
+
 	app, exists := p.Apps[id]
 	if exists {
+		p.listener.unwatch(id)
 		app.Client.Kill(context.Background(), "SIGKILL")
 		app.Client.Delete(context.Background(), options.Delete{})
 		os.RemoveAll(app.Info.Path)
 		delete(p.Apps, app.Info.UUID)
 		utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+		unused = p.releaseImages(id)
+		p.broker.Publish(appEvent(types.EventPurged, app.Info))
 
 		return nil
 	}
@@ -456,6 +805,10 @@ func (p *Docker) Start(id string) error {
 	p.Lock.Lock()
 	defer p.Lock.Unlock()
 
+	if app, exists := p.Apps[id]; exists && app.Upgrading {
+		return errors.New(types.Upgrading)
+	}
+
 	var err error
 	app, exists := p.Apps[id]
 	if exists {
@@ -463,6 +816,8 @@ func (p *Docker) Start(id string) error {
 			p.Apps[id].Active = true
 			p.Apps[id].Info.Active = "yes"
 			utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+			p.monitor(id)
+			p.broker.Publish(appEvent(types.EventStarted, app.Info))
 			return nil
 		}
 		return err
@@ -476,13 +831,19 @@ func (p *Docker) Stop(id string) error {
 	p.Lock.Lock()
 	defer p.Lock.Unlock()
 
+	if app, exists := p.Apps[id]; exists && app.Upgrading {
+		return errors.New(types.Upgrading)
+	}
+
 	var err error
 	app, exists := p.Apps[id]
 	if exists {
 		p.Apps[id].Active = false
 		p.Apps[id].Info.Active = "no"
+		p.listener.unwatch(id)
 		if err = app.Client.Down(context.Background(), options.Down{}); err == nil {
 			utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+			p.broker.Publish(appEvent(types.EventStopped, app.Info))
 			return nil
 		}
 		return err
@@ -496,15 +857,22 @@ func (p *Docker) Restart(id string) error {
 	p.Lock.Lock()
 	defer p.Lock.Unlock()
 
+	if app, exists := p.Apps[id]; exists && app.Upgrading {
+		return errors.New(types.Upgrading)
+	}
+
 	var err error
 	app, exists := p.Apps[id]
 	if exists {
 		p.Apps[id].Active = false
+		p.listener.unwatch(id)
 		app.Client.Down(context.Background(), options.Down{})
 		if err = app.Client.Up(context.Background(), options.Up{}); err == nil {
+			p.monitor(id)
 			p.Apps[id].Active = true
 			p.Apps[id].Info.Active = "yes"
 			utils.Save(p.Cfg.DataVolume+"/application.json", p.Apps)
+			p.broker.Publish(appEvent(types.EventRestarted, app.Info))
 			return nil
 		}
 		return err
@@ -513,6 +881,11 @@ func (p *Docker) Restart(id string) error {
 	return errors.New(types.InvalidID)
 }
 
+// Subscribe ...
+func (p *Docker) Subscribe() (<-chan types.LifecycleEvent, func()) {
+	return p.broker.Subscribe()
+}
+
 // GetApplication ...
 func (p *Docker) GetApplication(id string) (*types.AppDetails, error) {
 	p.Lock.RLock()
@@ -537,6 +910,7 @@ func (p *Docker) GetApplication(id string) (*types.AppDetails, error) {
 					State:   service["State"],
 					Ports:   service["Ports"]})
 			}
+			details.Services = app.Restarts.statuses(app.Info.Restart)
 			return &details, nil
 		}
 		return nil, err
@@ -544,6 +918,30 @@ func (p *Docker) GetApplication(id string) (*types.AppDetails, error) {
 	return nil, errors.New(types.InvalidID)
 }
 
+// Health reports the health of the services of an app.  Services of apps
+// that are not monitored count as healthy.
+func (p *Docker) Health(id string) (*types.AppHealth, error) {
+	p.Lock.RLock()
+	defer p.Lock.RUnlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return nil, errors.New(types.InvalidID)
+	}
+	health := &types.AppHealth{UUID: id, Services: make(map[string]types.ServiceHealth)}
+	for service := range app.Info.Restart {
+		running := app.Active
+		if running {
+			_, err := serviceContainer(app.Client, service)
+			running = err == nil
+		}
+		healthy, tracked := p.IsHealthyMap[id][service]
+		health.Services[service] = app.Restarts.lookup(service, app.Info.Restart).health(running, healthy || !tracked)
+	}
+	health.Status = aggregateHealth(health.Services)
+	return health, nil
+}
+
 // ListApplications ...
 func (p *Docker) ListApplications() types.Applications {
 	p.Lock.RLock()
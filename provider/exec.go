@@ -0,0 +1,184 @@
+package provider
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"golang.org/x/net/context"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// execOutputLimit is how many bytes of each output of a command run without
+// a terminal are returned, the rest is dropped
+var execOutputLimit = 1024 * 1024
+
+// execTimeout is how long a command run without a terminal may take, its
+// output is buffered until it exits
+var execTimeout = 5 * time.Minute
+
+// limitedBuffer keeps the first limit bytes written to it and counts the rest
+type limitedBuffer struct {
+	buf     bytes.Buffer
+	limit   int
+	dropped int
+}
+
+func newLimitedBuffer() *limitedBuffer {
+	return &limitedBuffer{limit: execOutputLimit}
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	kept := len(p)
+	if room := b.limit - b.buf.Len(); kept > room {
+		kept = room
+	}
+	b.buf.Write(p[:kept])
+	b.dropped += len(p) - kept
+	return len(p), nil
+}
+
+// String returns the output, ending with a marker when some was dropped
+func (b *limitedBuffer) String() string {
+	if b.dropped == 0 {
+		return b.buf.String()
+	}
+	return b.buf.String() + "\n[output truncated, " + strconv.Itoa(b.dropped) + " more bytes]\n"
+}
+
+// execCommand runs a command in a container, copying stdin, when not nil, to
+// its input and its output to stdout and stderr, and returns its exit code.
+// A command on a terminal writes all its output to stdout.  Tests replace it.
+var execCommand = func(ctx context.Context, containerID string, request types.ExecRequest, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	cli, err := dockerClient()
+	if err != nil {
+		return -1, err
+	}
+	config := dockertypes.ExecConfig{
+		Cmd:          request.Command,
+		Tty:          request.TTY,
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+	exec, err := cli.ContainerExecCreate(ctx, containerID, config)
+	if err != nil {
+		return -1, err
+	}
+	attach, err := cli.ContainerExecAttach(ctx, exec.ID, config)
+	if err != nil {
+		return -1, err
+	}
+	defer attach.Close()
+	// Stop reading the output once the context is done
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			attach.Close()
+		case <-stop:
+		}
+	}()
+	if stdin != nil {
+		go func() {
+			io.Copy(attach.Conn, stdin)
+			attach.CloseWrite()
+		}()
+	}
+	if request.TTY {
+		_, err = io.Copy(stdout, attach.Reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, attach.Reader)
+	}
+	if ctx.Err() != nil {
+		return -1, ctx.Err()
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	// The output ends just before docker records the exit code
+	for {
+		inspect, err := cli.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			return -1, err
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, nil
+		}
+		select {
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// validateExec checks that a command names a service of the app and
+// something to run
+func validateExec(request types.ExecRequest, policies map[string]types.RestartPolicy) error {
+	if len(request.Command) == 0 || request.Command[0] == "" {
+		return errors.New("Exec without a command")
+	}
+	if _, exists := policies[request.Service]; !exists {
+		return errors.New("Service " + request.Service + " not found")
+	}
+	return nil
+}
+
+// execContainer returns the running container of the service a command runs in
+func (p *Docker) execContainer(id string, request types.ExecRequest) (string, error) {
+	p.Lock.RLock()
+	defer p.Lock.RUnlock()
+	app, exists := p.Apps[id]
+	if !exists {
+		return "", errors.New(types.InvalidID)
+	}
+	if err := validateExec(request, app.Info.Restart); err != nil {
+		return "", err
+	}
+	return serviceContainer(app.Client, request.Service)
+}
+
+// Exec runs a command in the container of a service and returns its output,
+// truncated to execOutputLimit.  Commands running longer than execTimeout
+// fail, although docker leaves them running in the container.
+func (p *Docker) Exec(ctx context.Context, id string, request types.ExecRequest) (*types.ExecResult, error) {
+	containerID, err := p.execContainer(id, request)
+	if err != nil {
+		return nil, err
+	}
+	var stdin io.Reader
+	if request.Stdin != "" {
+		stdin = strings.NewReader(request.Stdin)
+	}
+	ctx, cancel := context.WithTimeout(ctx, execTimeout)
+	defer cancel()
+	stdout, stderr := newLimitedBuffer(), newLimitedBuffer()
+	code, err := execCommand(ctx, containerID, request, stdin, stdout, stderr)
+	if err == context.DeadlineExceeded {
+		err = errors.New("Exec timed out after " + execTimeout.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &types.ExecResult{ExitCode: code, Stdout: stdout.String(), Stderr: stderr.String()}, nil
+}
+
+// ExecStream runs an interactive command on a terminal in the container of a
+// service
+func (p *Docker) ExecStream(ctx context.Context, id string, request types.ExecRequest, stream io.ReadWriter) (int, error) {
+	request.TTY = true
+	containerID, err := p.execContainer(id, request)
+	if err != nil {
+		return -1, err
+	}
+	return execCommand(ctx, containerID, request, stream, stream, stream)
+}
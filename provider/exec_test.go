@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/libcompose/project"
+	"golang.org/x/net/context"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+func TestDockerExec(t *testing.T) {
+	savedContainer, savedExec := serviceContainer, execCommand
+	defer func() {
+		serviceContainer, execCommand = savedContainer, savedExec
+	}()
+	serviceContainer = func(prj project.APIProject, service string) (string, error) {
+		if service == "db" {
+			return "", errors.New("No running container")
+		}
+		return service + "-1", nil
+	}
+	execCommand = func(ctx context.Context, containerID string, request types.ExecRequest, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+		input := []byte{}
+		if stdin != nil {
+			input, _ = ioutil.ReadAll(stdin)
+		}
+		if request.TTY {
+			stdout.Write([]byte("$ "))
+		}
+		stdout.Write([]byte(containerID + " " + request.Command[0] + "\n"))
+		stderr.Write(input)
+		return 3, nil
+	}
+	p := &Docker{Apps: map[string]*ComposeApp{
+		"app": {Info: types.App{UUID: "app", Restart: map[string]types.RestartPolicy{"web": {}, "db": {}}}},
+	}}
+
+	result, err := p.Exec(context.Background(), "app", types.ExecRequest{Service: "web", Command: []string{"env"}, Stdin: "input"})
+	if err != nil || result.ExitCode != 3 || result.Stdout != "web-1 env\n" || result.Stderr != "input" {
+		t.Error("Expected env to run in web, got ", result, err)
+	}
+	for _, request := range []types.ExecRequest{
+		{Service: "db", Command: []string{"env"}},
+		{Service: "queue", Command: []string{"env"}},
+		{Service: "web"},
+	} {
+		if _, err = p.Exec(context.Background(), "app", request); err == nil {
+			t.Error("Expected ", request, " to fail")
+		}
+	}
+
+	stream := &bytes.Buffer{}
+	stream.WriteString("exit\n")
+	code, err := p.ExecStream(context.Background(), "app", types.ExecRequest{Service: "web", Command: []string{"sh"}}, stream)
+	if err != nil || code != 3 || stream.String() != "$ web-1 sh\nexit\n" {
+		t.Error("Expected sh to run on a terminal, got ", code, stream.String(), err)
+	}
+	if _, err = p.ExecStream(context.Background(), "unknown", types.ExecRequest{Service: "web", Command: []string{"sh"}}, stream); err == nil || err.Error() != types.InvalidID {
+		t.Error("Expected unknown app to fail, got ", err)
+	}
+}
+
+func TestDockerExecLimits(t *testing.T) {
+	savedContainer, savedExec := serviceContainer, execCommand
+	savedLimit, savedTimeout := execOutputLimit, execTimeout
+	defer func() {
+		serviceContainer, execCommand = savedContainer, savedExec
+		execOutputLimit, execTimeout = savedLimit, savedTimeout
+	}()
+	execOutputLimit, execTimeout = 8, 50*time.Millisecond
+	serviceContainer = func(prj project.APIProject, service string) (string, error) {
+		return service + "-1", nil
+	}
+	execCommand = func(ctx context.Context, containerID string, request types.ExecRequest, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+		if request.Command[0] == "sleep" {
+			<-ctx.Done()
+			return -1, ctx.Err()
+		}
+		stdout.Write([]byte("0123456789"))
+		stdout.Write([]byte("abc"))
+		stderr.Write([]byte("short"))
+		return 0, nil
+	}
+	p := &Docker{Apps: map[string]*ComposeApp{
+		"app": {Info: types.App{UUID: "app", Restart: map[string]types.RestartPolicy{"web": {}}}},
+	}}
+
+	result, err := p.Exec(context.Background(), "app", types.ExecRequest{Service: "web", Command: []string{"cat"}})
+	if err != nil || result.Stdout != "01234567\n[output truncated, 5 more bytes]\n" || result.Stderr != "short" {
+		t.Error("Expected the output to be truncated, got ", result, err)
+	}
+	if _, err = p.Exec(context.Background(), "app", types.ExecRequest{Service: "web", Command: []string{"sleep"}}); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Error("Expected the command to time out, got ", err)
+	}
+}
@@ -0,0 +1,126 @@
+package provider
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/utils"
+)
+
+// History keeps the unpacked packages of previous versions of each named
+// application under <data_volume>/application_history/<name>/<version>, so
+// an application can be rolled back without the package being sent again.
+// Only the newest Retention versions of each application are kept, with a
+// retention of 0 packages are removed instead of archived.
+type History struct {
+	Path      string
+	Retention int
+}
+
+// NewHistory ...
+func NewHistory(c config.Config) *History {
+	return &History{
+		Path:      c.DataVolume + "/application_history",
+		Retention: c.HistoryRetention,
+	}
+}
+
+// AppVersion describes the version of a deployed app for the history
+func AppVersion(info types.App) types.AppVersion {
+	return types.AppVersion{
+		Name:    info.Name,
+		Version: info.Version,
+		Monitor: info.Monitor,
+		Restart: info.Restart,
+		Probes:  info.Probes,
+	}
+}
+
+// validName rejects names that cannot be used as a directory
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".."
+}
+
+func (h *History) dir(name string, version string) string {
+	return filepath.Join(h.Path, url.PathEscape(name), url.PathEscape(version))
+}
+
+// Archive moves the unpacked package in dir into the history of its
+// application, replacing an archived copy of the same version, and prunes
+// the oldest versions beyond the retention.  The version is stamped with
+// the time it was archived unless it already carries one.
+func (h *History) Archive(version types.AppVersion, dir string) error {
+	if h.Retention <= 0 || !validName(version.Name) || !validName(version.Version) {
+		return os.RemoveAll(dir)
+	}
+	if version.Archived.IsZero() {
+		version.Archived = time.Now().UTC()
+	}
+	target := h.dir(version.Name, version.Version)
+	os.RemoveAll(target)
+	if err := os.MkdirAll(target, os.ModePerm); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	if err := os.Rename(dir, target+"/app"); err != nil {
+		os.RemoveAll(dir)
+		os.RemoveAll(target)
+		return err
+	}
+	if err := utils.Save(target+"/version.json", version); err != nil {
+		os.RemoveAll(target)
+		return err
+	}
+
+	versions := h.Versions(version.Name)
+	for i := h.Retention; i < len(versions); i++ {
+		os.RemoveAll(h.dir(versions[i].Name, versions[i].Version))
+	}
+	return nil
+}
+
+// Versions lists the archived versions of an application, newest first
+func (h *History) Versions(name string) []types.AppVersion {
+	versions := []types.AppVersion{}
+	if !validName(name) {
+		return versions
+	}
+	dirs, _ := ioutil.ReadDir(filepath.Join(h.Path, url.PathEscape(name)))
+	for _, d := range dirs {
+		var version types.AppVersion
+		if !d.IsDir() || utils.Load(filepath.Join(h.Path, url.PathEscape(name), d.Name(), "version.json"), &version) != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].Archived.After(versions[j].Archived)
+	})
+	return versions
+}
+
+// Take moves an archived version out of the history into dir.  A version
+// that fails to deploy can be returned with Archive.
+func (h *History) Take(name string, version string, dir string) (types.AppVersion, error) {
+	var archived types.AppVersion
+	if !validName(name) || !validName(version) {
+		return archived, errors.New(types.InvalidVersion)
+	}
+	source := h.dir(name, version)
+	if err := utils.Load(source+"/version.json", &archived); err != nil {
+		return archived, errors.New(types.InvalidVersion)
+	}
+	os.RemoveAll(dir)
+	if err := os.Rename(source+"/app", dir); err != nil {
+		return archived, err
+	}
+	os.RemoveAll(source)
+	return archived, nil
+}
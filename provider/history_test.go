@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappsd-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var cfg config.Config
+	cfg.DataVolume = dir
+	cfg.HistoryRetention = 2
+	history := NewHistory(cfg)
+
+	unpacked := func(version string) string {
+		path := filepath.Join(dir, "app-"+version)
+		os.Mkdir(path, os.ModePerm)
+		ioutil.WriteFile(filepath.Join(path, "docker-compose.yml"), []byte(version), 0644)
+		return path
+	}
+	for _, version := range []string{"1.0", "2.0", "3.0"} {
+		if err = history.Archive(types.AppVersion{Name: "testapp", Version: version}, unpacked(version)); err != nil {
+			t.Fatal("Archive failed: ", err)
+		}
+	}
+
+	versions := history.Versions("testapp")
+	if len(versions) != 2 || versions[0].Version != "3.0" || versions[1].Version != "2.0" {
+		t.Fatal("Expected the two newest versions, got ", versions)
+	}
+
+	target := filepath.Join(dir, "restored")
+	archived, err := history.Take("testapp", "2.0", target)
+	if err != nil || archived.Version != "2.0" || archived.Archived.IsZero() {
+		t.Fatal("Take failed: ", archived, err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(target, "docker-compose.yml")); string(data) != "2.0" {
+		t.Error("Expected the archived package to be restored, got ", string(data))
+	}
+	if versions = history.Versions("testapp"); len(versions) != 1 {
+		t.Error("Expected taken version to leave the history, got ", versions)
+	}
+	if _, err = history.Take("testapp", "1.0", target); err == nil || err.Error() != types.InvalidVersion {
+		t.Error("Expected pruned version to be gone, got ", err)
+	}
+	if _, err = history.Take("testapp", "..", target); err == nil {
+		t.Error("Expected invalid version to be rejected")
+	}
+
+	history.Retention = 0
+	path := unpacked("4.0")
+	if err = history.Archive(types.AppVersion{Name: "testapp", Version: "4.0"}, path); err != nil {
+		t.Error(err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) || len(history.Versions("testapp")) != 1 {
+		t.Error("Expected package to be removed when history is disabled")
+	}
+}
@@ -0,0 +1,339 @@
+package provider
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/context"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/utils"
+)
+
+// archiveManifest is an entry of the manifest.json docker save writes into
+// an image archive
+type archiveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+}
+
+// inspectImage returns the ID of the image a reference resolves to on the
+// daemon, tests replace it to avoid talking to docker
+var inspectImage = func(ref string) (string, error) {
+	cli, err := dockerClient()
+	if err != nil {
+		return "", err
+	}
+	image, _, err := cli.ImageInspectWithRaw(context.Background(), ref)
+	if err != nil {
+		return "", err
+	}
+	return image.ID, nil
+}
+
+// loadImage loads an image archive into the daemon, tests replace it
+var loadImage = func(archive string) error {
+	return LoadImage(&archive)
+}
+
+// imageArchives lists the docker-save archives of an unpacked application
+func imageArchives(path string) ([]string, error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var archives []string
+	for _, f := range files {
+		if !f.IsDir() && strings.Contains(f.Name(), ".tar") {
+			archives = append(archives, f.Name())
+		}
+	}
+	return archives, nil
+}
+
+// readArchiveImages reads the images in a docker-save archive, which may be
+// gzipped, from its manifest.json
+func readArchiveImages(archive string) ([]types.Image, error) {
+	input, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	var reader io.Reader = bufio.NewReader(input)
+	if magic, _ := reader.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, errors.New("No manifest.json in " + filepath.Base(archive))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Name != "manifest.json" {
+			continue
+		}
+
+		var manifest []archiveManifest
+		if err = json.NewDecoder(tarReader).Decode(&manifest); err != nil {
+			return nil, err
+		}
+		images := []types.Image{}
+		for _, entry := range manifest {
+			// The config is named after the image ID, <hex>.json in the
+			// legacy layout and blobs/sha256/<hex> in the OCI one
+			id := strings.TrimSuffix(path.Base(entry.Config), ".json")
+			if id == "" || id == "." {
+				return nil, errors.New("Image without a config in " + filepath.Base(archive))
+			}
+			images = append(images, types.Image{
+				ID:      "sha256:" + id,
+				Tags:    entry.RepoTags,
+				Archive: filepath.Base(archive),
+			})
+		}
+		return images, nil
+	}
+}
+
+// imagePresent reports if the daemon has the image and every tag of it
+// still points at it
+func imagePresent(image types.Image) bool {
+	if id, err := inspectImage(image.ID); err != nil || id != image.ID {
+		return false
+	}
+	for _, tag := range image.Tags {
+		if id, err := inspectImage(tag); err != nil || id != image.ID {
+			return false
+		}
+	}
+	return true
+}
+
+// loadImages loads the docker-save archives in an unpacked application,
+// skipping archives whose images are all present already.  Archives
+// without a readable manifest are always loaded.  On failure the images of
+// the archives loaded so far are returned with the error.
+func loadImages(logger *logging.Logger, path string, progress types.Progress) ([]types.Image, error) {
+	archives, err := imageArchives(path)
+	if err != nil {
+		return nil, err
+	}
+	loaded := []types.Image{}
+	for i, name := range archives {
+		progress.Report(types.PhaseLoadingImages, i+1, len(archives))
+		archive := filepath.Join(path, name)
+		images, err := readArchiveImages(archive)
+		if err != nil {
+			logger.Warn("Cannot read the images of an archive, loading it", "archive", name, "error", err)
+			images = nil
+		}
+
+		skip := len(images) > 0
+		for _, image := range images {
+			skip = skip && imagePresent(image)
+		}
+		if skip {
+			logger.Debug("Skipping image archive, already present", "archive", name)
+		} else {
+			logger.Info("Loading image archive", "archive", name)
+			if err = loadImage(archive); err != nil {
+				return loaded, err
+			}
+			if info, err := os.Stat(archive); err == nil {
+				imageLoadBytes.Add(float64(info.Size()))
+			}
+		}
+		for _, image := range images {
+			image.Skipped = skip
+			loaded = append(loaded, image)
+		}
+	}
+	return loaded, nil
+}
+
+// persistentOwner is the owner of the images of a persistent application,
+// which can be deployed again from its backup after its apps are undeployed
+func persistentOwner(name string) string {
+	return "persistent:" + name
+}
+
+// imageRef is an image loaded by cappsd and the owners still using it, app
+// UUIDs or persistent owners
+type imageRef struct {
+	Tags   []string `json:"tags"`
+	Owners []string `json:"owners"`
+}
+
+// imageRefs counts the references to the images cappsd loaded, saved in
+// <data_volume>/images.json.  Images present before cappsd skipped loading
+// them are not tracked, so images cappsd did not load are never removed.
+type imageRefs struct {
+	path   string
+	Images map[string]*imageRef
+}
+
+// loadImageRefs ...
+func loadImageRefs(c config.Config) *imageRefs {
+	refs := &imageRefs{path: c.DataVolume + "/images.json"}
+	utils.Load(refs.path, &refs.Images)
+	if refs.Images == nil {
+		refs.Images = make(map[string]*imageRef)
+	}
+	return refs
+}
+
+func (r *imageRefs) save() error {
+	return utils.Save(r.path, r.Images)
+}
+
+// claim makes owner use exactly images, releasing the images it used before
+func (r *imageRefs) claim(owner string, images []types.Image) {
+	r.release(owner)
+	for _, image := range images {
+		ref, tracked := r.Images[image.ID]
+		if !tracked {
+			if image.Skipped {
+				continue
+			}
+			ref = &imageRef{Owners: []string{}}
+			r.Images[image.ID] = ref
+		}
+		ref.Tags = image.Tags
+		ref.Owners = append(ref.Owners, owner)
+	}
+}
+
+// dropImages releases the images a failed deploy or upgrade loaded for
+// owner and returns those no other owner uses, to be removed
+func dropImages(c config.Config, owner string, images []types.Image) []types.Image {
+	refs := loadImageRefs(c)
+	refs.claim(owner, images)
+	refs.release(owner)
+	return refs.unused()
+}
+
+// release drops owner from every image
+func (r *imageRefs) release(owner string) {
+	r.retain(func(o string) bool {
+		return o != owner
+	})
+}
+
+// retain keeps only the owners keep accepts
+func (r *imageRefs) retain(keep func(owner string) bool) {
+	for _, ref := range r.Images {
+		owners := []string{}
+		for _, o := range ref.Owners {
+			if keep(o) {
+				owners = append(owners, o)
+			}
+		}
+		ref.Owners = owners
+	}
+}
+
+// unused saves the references and returns the images without owners, to be
+// removed by removeImages
+func (r *imageRefs) unused() []types.Image {
+	images := []types.Image{}
+	for id, ref := range r.Images {
+		if len(ref.Owners) == 0 {
+			images = append(images, types.Image{ID: id, Tags: ref.Tags})
+		}
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].ID < images[j].ID
+	})
+	if err := r.save(); err != nil {
+		logging.Warn("Failed to save image references", "error", err)
+	}
+	return images
+}
+
+// removeImages removes unused images from the daemon, which can take seconds
+// for large images, so the caller must not hold lock.  lock guards the
+// references, which only stop tracking the images once they are removed.
+// Images that fail to be removed, because a container outside cappsd still
+// uses them, stay tracked and are retried by the next prune.
+func removeImages(c config.Config, lock sync.Locker, images []types.Image) *types.PruneReport {
+	report := &types.PruneReport{Images: []string{}}
+	for _, image := range images {
+		size, err := removeImage(image)
+		if err != nil {
+			logging.Warn("Failed to remove image", "image", image.ID, "error", err)
+			continue
+		}
+		report.Images = append(report.Images, image.ID)
+		report.SpaceReclaimed += size
+	}
+	if len(report.Images) == 0 {
+		return report
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	refs := loadImageRefs(c)
+	for _, id := range report.Images {
+		// Images claimed again meanwhile are left to their new owner
+		if ref, tracked := refs.Images[id]; tracked && len(ref.Owners) == 0 {
+			delete(refs.Images, id)
+		}
+	}
+	if err := refs.save(); err != nil {
+		logging.Warn("Failed to save image references", "error", err)
+	}
+	return report
+}
+
+// removeImage removes an image from the daemon and returns its size.  Tags
+// moved to another image since are left alone.  Tests replace it.
+var removeImage = func(image types.Image) (int64, error) {
+	cli, err := dockerClient()
+	if err != nil {
+		return 0, err
+	}
+	inspect, _, err := cli.ImageInspectWithRaw(context.Background(), image.ID)
+	if client.IsErrImageNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	for _, tag := range image.Tags {
+		if id, err := inspectImage(tag); err == nil && id == image.ID {
+			if _, err = cli.ImageRemove(context.Background(), tag, dockertypes.ImageRemoveOptions{PruneChildren: true}); err != nil {
+				return 0, err
+			}
+		}
+	}
+	_, err = cli.ImageRemove(context.Background(), image.ID, dockertypes.ImageRemoveOptions{PruneChildren: true})
+	if err != nil && !client.IsErrImageNotFound(err) {
+		return 0, err
+	}
+	return inspect.Size, nil
+}
@@ -0,0 +1,196 @@
+package provider
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+func imageArchive(t *testing.T, path string, manifest string, gzipped bool) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(manifest))})
+	tw.Write([]byte(manifest))
+	tw.Close()
+	data := buf.Bytes()
+	if gzipped {
+		var gz bytes.Buffer
+		w := gzip.NewWriter(&gz)
+		w.Write(data)
+		w.Close()
+		data = gz.Bytes()
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadImagesSkipsPresent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappsd-images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	imageArchive(t, filepath.Join(dir, "web.tar.gz"), `[{"Config":"aaa.json","RepoTags":["web:1.0"]}]`, true)
+	imageArchive(t, filepath.Join(dir, "db.tar"), `[{"Config":"blobs/sha256/bbb","RepoTags":["db:1.0"]}]`, false)
+	imageArchive(t, filepath.Join(dir, "cache.tar"), `[{"Config":"ccc.json","RepoTags":["cache:1.0"]}]`, false)
+	ioutil.WriteFile(filepath.Join(dir, "broken.tar"), []byte("not a tarball"), 0644)
+
+	// web is present, db's tag was moved to another image and cache is
+	// missing
+	daemon := map[string]string{
+		"sha256:aaa": "sha256:aaa", "web:1.0": "sha256:aaa",
+		"sha256:bbb": "sha256:bbb", "db:1.0": "sha256:other",
+	}
+	var loaded []string
+	defer func(inspect func(string) (string, error), load func(string) error) {
+		inspectImage, loadImage = inspect, load
+	}(inspectImage, loadImage)
+	inspectImage = func(ref string) (string, error) {
+		if id, ok := daemon[ref]; ok {
+			return id, nil
+		}
+		return "", errors.New("No such image: " + ref)
+	}
+	loadImage = func(archive string) error {
+		loaded = append(loaded, filepath.Base(archive))
+		return nil
+	}
+
+	images, err := loadImages(logging.Default(), dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"broken.tar", "cache.tar", "db.tar"}; !reflect.DeepEqual(loaded, expected) {
+		t.Error("Expected ", expected, " to be loaded, got ", loaded)
+	}
+	if len(images) != 3 {
+		t.Fatal("Expected the images of 3 archives, got ", images)
+	}
+	for _, image := range images {
+		if image.Skipped != (image.Archive == "web.tar.gz") {
+			t.Error("Unexpected skip of ", image)
+		}
+	}
+	if images[1].ID != "sha256:bbb" || images[1].Tags[0] != "db:1.0" {
+		t.Error("Expected db image from OCI config path, got ", images[1])
+	}
+
+	loadImage = func(archive string) error {
+		return errors.New("daemon gone")
+	}
+	if _, err = loadImages(logging.Default(), dir, nil); err == nil {
+		t.Error("Expected load failure to be reported")
+	}
+}
+
+func TestImageRefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappsd-images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := config.Config{DataVolume: dir}
+
+	var removed []string
+	inUse := map[string]bool{}
+	defer func(remove func(types.Image) (int64, error)) { removeImage = remove }(removeImage)
+	removeImage = func(image types.Image) (int64, error) {
+		if inUse[image.ID] {
+			return 0, errors.New("image is being used by a container")
+		}
+		removed = append(removed, image.ID)
+		return 100, nil
+	}
+
+	base := types.Image{ID: "sha256:base", Tags: []string{"base:1"}}
+	web := types.Image{ID: "sha256:web", Tags: []string{"web:1"}}
+	external := types.Image{ID: "sha256:external", Skipped: true}
+	refs := loadImageRefs(cfg)
+	refs.claim("app1", []types.Image{base, web, external})
+	refs.claim(persistentOwner("web"), []types.Image{base, web})
+	base.Skipped = true
+	refs.claim("app2", []types.Image{base})
+	refs.save()
+
+	// References are read back from disk by every operation
+	refs = loadImageRefs(cfg)
+	if _, tracked := refs.Images[external.ID]; tracked {
+		t.Error("Expected image cappsd did not load to be untracked")
+	}
+	refs.release("app1")
+	refs.release(persistentOwner("web"))
+	var lock sync.Mutex
+	if report := removeImages(cfg, &lock, refs.unused()); !reflect.DeepEqual(report.Images, []string{web.ID}) || report.SpaceReclaimed != 100 {
+		t.Error("Expected only web to be removed, got ", report)
+	}
+
+	inUse[base.ID] = true
+	refs = loadImageRefs(cfg)
+	refs.release("app2")
+	if report := removeImages(cfg, &lock, refs.unused()); len(report.Images) != 0 {
+		t.Error("Expected image in use to be kept, got ", report)
+	}
+	inUse[base.ID] = false
+	refs = loadImageRefs(cfg)
+	removeImages(cfg, &lock, refs.unused())
+	refs = loadImageRefs(cfg)
+	sort.Strings(removed)
+	if expected := []string{base.ID, web.ID}; !reflect.DeepEqual(removed, expected) || len(refs.Images) != 0 {
+		t.Error("Expected ", expected, " to be removed once unused, got ", removed)
+	}
+}
+
+func TestDropImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappsd-images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := config.Config{DataVolume: dir}
+
+	var removed []string
+	defer func(remove func(types.Image) (int64, error)) { removeImage = remove }(removeImage)
+	removeImage = func(image types.Image) (int64, error) {
+		removed = append(removed, image.ID)
+		return 100, nil
+	}
+
+	base := types.Image{ID: "sha256:base", Tags: []string{"base:1"}}
+	web := types.Image{ID: "sha256:web", Tags: []string{"web:1"}}
+	external := types.Image{ID: "sha256:external", Skipped: true}
+	refs := loadImageRefs(cfg)
+	refs.claim("app1", []types.Image{base})
+	refs.save()
+
+	// A failed deploy only removes the images no other app uses
+	base.Skipped = true
+	unused := dropImages(cfg, "app2", []types.Image{base, web, external})
+	if len(removed) != 0 {
+		t.Error("Expected images to be removed only after the lock is released, got ", removed)
+	}
+	removeImages(cfg, &sync.Mutex{}, unused)
+	if !reflect.DeepEqual(removed, []string{web.ID}) {
+		t.Error("Expected only the image loaded by the failed deploy to be removed, got ", removed)
+	}
+	refs = loadImageRefs(cfg)
+	if ref, tracked := refs.Images[base.ID]; !tracked || !reflect.DeepEqual(ref.Owners, []string{"app1"}) {
+		t.Error("Expected the shared image to stay with its owner, got ", ref)
+	}
+	if len(refs.Images) != 1 {
+		t.Error("Expected only the shared image to be tracked, got ", refs.Images)
+	}
+}
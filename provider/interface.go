@@ -3,7 +3,8 @@ package provider
 import (
 	"io"
 
-	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"golang.org/x/net/context"
+
 	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
 )
 
@@ -15,21 +16,60 @@ type Provider interface {
 	Undeploy(id string) error
 	PurgePersistent(name string) error
 	Kill(id string) error
+	Upgrade(id string, metadata types.Metadata, file io.Reader) (*types.App, error)
+	Rollback(id string, version string) (*types.App, error)
 
 	Start(id string) error
 	Stop(id string) error
 	Restart(id string) error
 
 	GetApplication(id string) (*types.AppDetails, error)
+	History(id string) (*types.AppHistory, error)
 	ListApplications() types.Applications
 	ListPersistentApplications() types.PersistentApps
 }
 
-// NewProvider ...
-func NewProvider(c config.Config) Provider {
-	p := NewDocker(c)
-	if err := p.Init(); err != nil {
-		return nil
-	}
-	return p
+// ProgressDeployer is implemented by providers that can report the progress
+// of a deploy
+type ProgressDeployer interface {
+	DeployWithProgress(metadata types.Metadata, file io.Reader, persistent bool, progress types.Progress) (*types.App, error)
+}
+
+// EventPublisher is implemented by providers that publish the lifecycle
+// events of applications
+type EventPublisher interface {
+	Subscribe() (<-chan types.LifecycleEvent, func())
+}
+
+// LogReader is implemented by providers that can read the logs of the
+// containers of applications
+type LogReader interface {
+	Logs(ctx context.Context, id string, options types.LogOptions, out *LogWriter) error
+}
+
+// Executor is implemented by providers that can run commands in the
+// containers of applications.  ExecStream runs an interactive command on a
+// terminal, copying stream to its input and its output to stream until it
+// exits, and returns its exit code.
+type Executor interface {
+	Exec(ctx context.Context, id string, request types.ExecRequest) (*types.ExecResult, error)
+	ExecStream(ctx context.Context, id string, request types.ExecRequest, stream io.ReadWriter) (int, error)
+}
+
+// StatsReader is implemented by providers that can sample the resource
+// usage of the containers of applications
+type StatsReader interface {
+	Stats(ctx context.Context, id string) (*types.AppStats, error)
+}
+
+// HealthChecker is implemented by providers that track the health of the
+// services of applications
+type HealthChecker interface {
+	Health(id string) (*types.AppHealth, error)
+}
+
+// ImagePruner is implemented by providers that track the images loaded for
+// applications and can remove the ones no application uses anymore
+type ImagePruner interface {
+	PruneImages() (*types.PruneReport, error)
 }
@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"sync"
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// events to it are dropped
+const subscriberBuffer = 64
+
+// Broker fans lifecycle events out to subscribers.  Publishing never blocks,
+// so providers publish while holding their lock.
+type Broker struct {
+	lock        sync.Mutex
+	subscribers map[chan types.LifecycleEvent]bool
+}
+
+// NewBroker ...
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[chan types.LifecycleEvent]bool)}
+}
+
+// Subscribe returns a channel receiving every event published from now on
+// and the function ending the subscription, which closes the channel
+func (b *Broker) Subscribe() (<-chan types.LifecycleEvent, func()) {
+	events := make(chan types.LifecycleEvent, subscriberBuffer)
+	b.lock.Lock()
+	b.subscribers[events] = true
+	b.lock.Unlock()
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			b.lock.Lock()
+			delete(b.subscribers, events)
+			b.lock.Unlock()
+			close(events)
+		})
+	}
+}
+
+// Publish sends an event to every subscriber, stamping it with the current
+// time.  Subscribers that fell behind miss it.
+func (b *Broker) Publish(event types.LifecycleEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			appLog(event.UUID, event.Name).Warn("Dropped event for a slow subscriber", "event", event.Type)
+		}
+	}
+}
+
+// deployed publishes the outcome of a deploy, upgrade or rollback of the app
+// with the given id, which is empty for new apps
+func (b *Broker) deployed(id string, metadata types.Metadata, info *types.App, err error) {
+	if err != nil {
+		b.Publish(types.LifecycleEvent{
+			Type:    types.EventDeployFailed,
+			UUID:    id,
+			Name:    metadata.Name,
+			Version: metadata.Version,
+			Detail:  err.Error(),
+		})
+		return
+	}
+	b.Publish(appEvent(types.EventDeployed, *info))
+}
+
+// appEvent is an event about an app as a whole
+func appEvent(kind string, info types.App) types.LifecycleEvent {
+	return types.LifecycleEvent{Type: kind, UUID: info.UUID, Name: info.Name, Version: info.Version}
+}
+
+// serviceEvent is an event about a service of an app
+func serviceEvent(kind string, info types.App, service string, detail string) types.LifecycleEvent {
+	event := appEvent(kind, info)
+	event.Service = service
+	event.Detail = detail
+	return event
+}
@@ -0,0 +1,11 @@
+package provider
+
+import (
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+)
+
+// appLog returns the logger of the lines about an app, which carry its uuid
+// and name
+func appLog(uuid string, name string) *logging.Logger {
+	return logging.With("app", uuid, "name", name)
+}
@@ -0,0 +1,203 @@
+package provider
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/libcompose/project"
+	"golang.org/x/net/context"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// The docker calls of the log API, tests replace them
+var (
+	serviceContainers = func(ctx context.Context, prj project.APIProject, service string, state project.State) ([]string, error) {
+		return prj.Containers(ctx, project.Filter{State: state}, service)
+	}
+	containerLogs = func(ctx context.Context, containerID string, options types.LogOptions) (io.ReadCloser, error) {
+		cli, err := dockerClient()
+		if err != nil {
+			return nil, err
+		}
+		tail := "all"
+		if options.Tail >= 0 {
+			tail = strconv.Itoa(options.Tail)
+		}
+		since := ""
+		if !options.Since.IsZero() {
+			since = strconv.FormatInt(options.Since.Unix(), 10)
+		}
+		return cli.ContainerLogs(ctx, containerID, dockertypes.ContainerLogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Since:      since,
+			Timestamps: options.Timestamps,
+			Follow:     options.Follow,
+			Tail:       tail,
+		})
+	}
+)
+
+// LogWriter writes the log lines of several containers to one output, each
+// line prefixed with its container and, for stderr, the stream.  Lines are
+// written whole and flushed as they arrive.
+type LogWriter struct {
+	lock  sync.Mutex
+	out   io.Writer
+	flush func()
+}
+
+// NewLogWriter ...
+func NewLogWriter(out io.Writer, flush func()) *LogWriter {
+	if flush == nil {
+		flush = func() {}
+	}
+	return &LogWriter{out: out, flush: flush}
+}
+
+// Line writes a line of a container
+func (w *LogWriter) Line(container string, stream string, line string) error {
+	prefix := container
+	if stream == "stderr" {
+		prefix += " (stderr)"
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if _, err := io.WriteString(w.out, prefix+" | "+line+"\n"); err != nil {
+		return err
+	}
+	w.flush()
+	return nil
+}
+
+// Flush sends what was written so far, so a followed stream starts even
+// before the first line
+func (w *LogWriter) Flush() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.flush()
+}
+
+// lineWriter splits the output of a container stream into lines
+type lineWriter struct {
+	out       *LogWriter
+	container string
+	stream    string
+	partial   []byte
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if err := l.out.Line(l.container, l.stream, string(l.partial[:i])); err != nil {
+			return 0, err
+		}
+		l.partial = l.partial[i+1:]
+	}
+}
+
+// close writes the last line when it was not terminated
+func (l *lineWriter) close() {
+	if len(l.partial) > 0 {
+		l.out.Line(l.container, l.stream, string(l.partial))
+	}
+}
+
+// logServices returns the services to read the logs of, service or all of
+// them in order
+func logServices(service string, policies map[string]types.RestartPolicy) ([]string, error) {
+	if service != "" {
+		if _, exists := policies[service]; !exists {
+			return nil, errors.New("Service " + service + " not found")
+		}
+		return []string{service}, nil
+	}
+	services := make([]string, 0, len(policies))
+	for name := range policies {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// Logs writes the logs of the containers of an app to out.  Following logs
+// returns once ctx is done or every container stopped.
+func (p *Docker) Logs(ctx context.Context, id string, options types.LogOptions, out *LogWriter) error {
+	p.Lock.RLock()
+	app, exists := p.Apps[id]
+	var prj project.APIProject
+	var policies map[string]types.RestartPolicy
+	if exists {
+		prj, policies = app.Client, app.Info.Restart
+	}
+	p.Lock.RUnlock()
+	if !exists {
+		return errors.New(types.InvalidID)
+	}
+	services, err := logServices(options.Service, policies)
+	if err != nil {
+		return err
+	}
+
+	type container struct {
+		id   string
+		name string
+	}
+	var containers []container
+	for _, service := range services {
+		ids, err := serviceContainers(ctx, prj, service, project.AnyState)
+		if err != nil {
+			return err
+		}
+		for i, containerID := range ids {
+			containers = append(containers, container{id: containerID, name: service + "_" + strconv.Itoa(i+1)})
+		}
+	}
+
+	streams := make([]io.ReadCloser, 0, len(containers))
+	for _, c := range containers {
+		logs, err := containerLogs(ctx, c.id, options)
+		if err != nil {
+			for _, opened := range streams {
+				opened.Close()
+			}
+			return err
+		}
+		streams = append(streams, logs)
+	}
+
+	if options.Follow {
+		out.Flush()
+	}
+	var wait sync.WaitGroup
+	errs := make(chan error, len(containers))
+	for i, c := range containers {
+		wait.Add(1)
+		go func(name string, logs io.ReadCloser) {
+			defer wait.Done()
+			defer logs.Close()
+			stdout := &lineWriter{out: out, container: name, stream: "stdout"}
+			stderr := &lineWriter{out: out, container: name, stream: "stderr"}
+			_, err := stdcopy.StdCopy(stdout, stderr, logs)
+			stdout.close()
+			stderr.close()
+			if err != nil && ctx.Err() == nil {
+				errs <- err
+			}
+		}(c.name, streams[i])
+	}
+	wait.Wait()
+	close(errs)
+	return <-errs
+}
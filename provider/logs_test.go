@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/libcompose/project"
+	"golang.org/x/net/context"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// multiplexed returns a docker log stream of the given stdout and stderr
+func multiplexed(stdout string, stderr string) io.ReadCloser {
+	var buf bytes.Buffer
+	stdcopy.NewStdWriter(&buf, stdcopy.Stdout).Write([]byte(stdout))
+	stdcopy.NewStdWriter(&buf, stdcopy.Stderr).Write([]byte(stderr))
+	return ioutil.NopCloser(&buf)
+}
+
+func TestDockerLogs(t *testing.T) {
+	savedContainers, savedLogs := serviceContainers, containerLogs
+	defer func() {
+		serviceContainers, containerLogs = savedContainers, savedLogs
+	}()
+	serviceContainers = func(ctx context.Context, prj project.APIProject, service string, state project.State) ([]string, error) {
+		if service == "db" {
+			return []string{"db-a", "db-b"}, nil
+		}
+		return []string{service}, nil
+	}
+	var requested []types.LogOptions
+	containerLogs = func(ctx context.Context, containerID string, options types.LogOptions) (io.ReadCloser, error) {
+		requested = append(requested, options)
+		switch containerID {
+		case "db-a":
+			return multiplexed("started\n", ""), nil
+		case "db-b":
+			return multiplexed("", "no space left"), nil
+		case "web":
+			return multiplexed("GET /\nGET /health\n", "warning\n"), nil
+		}
+		return nil, errors.New("No such container " + containerID)
+	}
+	p := &Docker{Apps: map[string]*ComposeApp{
+		"app": {Info: types.App{UUID: "app", Restart: map[string]types.RestartPolicy{"web": {}, "db": {}}}},
+	}}
+
+	var out bytes.Buffer
+	if err := p.Logs(context.Background(), "app", types.LogOptions{Tail: 5}, NewLogWriter(&out, nil)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	expected := map[string]bool{
+		"db_1 | started":                true,
+		"db_2 (stderr) | no space left": true,
+		"web_1 | GET /":                 true,
+		"web_1 | GET /health":           true,
+		"web_1 (stderr) | warning":      true,
+	}
+	if len(lines) != len(expected) || len(requested) != 3 || requested[0].Tail != 5 {
+		t.Error("Expected every container to be read, got ", lines, requested)
+	}
+	for _, line := range lines {
+		if !expected[line] {
+			t.Error("Unexpected line ", line)
+		}
+	}
+	if !strings.Contains(out.String(), "web_1 | GET /\nweb_1 | GET /health\n") {
+		t.Error("Expected the lines of a stream in order, got ", out.String())
+	}
+
+	out.Reset()
+	if err := p.Logs(context.Background(), "app", types.LogOptions{Service: "queue"}, NewLogWriter(&out, nil)); err == nil {
+		t.Error("Expected unknown service to fail")
+	}
+	if err := p.Logs(context.Background(), "unknown", types.LogOptions{}, NewLogWriter(&out, nil)); err == nil || err.Error() != types.InvalidID {
+		t.Error("Expected unknown app to fail, got ", err)
+	}
+	p.Apps["app"].Info.Restart["queue"] = types.RestartPolicy{}
+	if err := p.Logs(context.Background(), "app", types.LogOptions{}, NewLogWriter(&out, nil)); err == nil || out.Len() != 0 {
+		t.Error("Expected a missing container to fail before writing, got ", err, out.String())
+	}
+}
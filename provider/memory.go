@@ -0,0 +1,924 @@
+package provider
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/utils"
+)
+
+// MemoryApp ...
+type MemoryApp struct {
+	Info         types.App
+	Services     []string
+	Graph        *depGraph
+	Running      map[string]bool
+	Healthy      map[string]bool
+	ExitCodes    map[string]int
+	RestartCount map[string]int
+	Restarts     restartStates
+	Logs         []MemoryLog
+	Usage        map[string]types.ResourceUsage
+	Monitor      bool
+	Active       bool
+}
+
+// MemoryLog is a line a service wrote to stdout or stderr
+type MemoryLog struct {
+	Service string
+	Stream  string
+	Line    string
+	Time    time.Time
+}
+
+// MemoryCommand stands in for a program run in a container of the in-memory
+// provider, it returns the exit code
+type MemoryCommand func(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int
+
+// memoryCommands are the programs every in-memory container has
+var memoryCommands = map[string]MemoryCommand{
+	"echo": func(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+		fmt.Fprintln(stdout, strings.Join(args, " "))
+		return 0
+	},
+	"cat": func(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+		io.Copy(stdout, stdin)
+		return 0
+	},
+}
+
+// memoryFollowInterval is how often followed logs look for new lines
+var memoryFollowInterval = 10 * time.Millisecond
+
+// Memory is a Provider that keeps all state in memory and never touches a
+// container runtime.  Packages are still unpacked and their compose file
+// parsed, so malformed packages fail the same way they do on a device, but
+// services only exist as entries in a map.  Failures and health events can be
+// injected to drive the API through error paths and monitor behaviour.
+type Memory struct {
+	Cfg      config.Config
+	Apps     map[string]*MemoryApp
+	PApps    map[string]*types.Metadata
+	Packages map[string][]byte
+	Lock     sync.RWMutex
+
+	failures map[string][]error
+	sticky   map[string]error
+	versions map[string][]memoryVersion
+	commands map[string]MemoryCommand
+	broker   *Broker
+}
+
+// memoryVersion is an archived version of an app in the history
+type memoryVersion struct {
+	Version types.AppVersion
+	Graph   *depGraph
+}
+
+// Operations that failures can be injected into
+const (
+	OpInit            = "init"
+	OpDeploy          = "deploy"
+	OpUndeploy        = "undeploy"
+	OpPurgePersistent = "purge-persistent"
+	OpKill            = "kill"
+	OpUpgrade         = "upgrade"
+	OpRollback        = "rollback"
+	OpStart           = "start"
+	OpStop            = "stop"
+	OpRestart         = "restart"
+	OpGetApplication  = "get-application"
+	OpPruneImages     = "prune-images"
+	OpHealth          = "health"
+	OpLogs            = "logs"
+	OpExec            = "exec"
+	OpStats           = "stats"
+)
+
+func init() {
+	Register("memory", func(c config.Config) Provider {
+		return NewMemory(c)
+	})
+}
+
+// NewMemory ...
+func NewMemory(c config.Config) *Memory {
+	provider := new(Memory)
+	provider.Cfg = c
+	provider.Apps = make(map[string]*MemoryApp)
+	provider.PApps = make(map[string]*types.Metadata)
+	provider.Packages = make(map[string][]byte)
+	provider.failures = make(map[string][]error)
+	provider.sticky = make(map[string]error)
+	provider.versions = make(map[string][]memoryVersion)
+	provider.commands = make(map[string]MemoryCommand)
+	for name, command := range memoryCommands {
+		provider.commands[name] = command
+	}
+	provider.broker = NewBroker()
+	return provider
+}
+
+// FailNext makes the next call of operation op return err
+func (p *Memory) FailNext(op string, err error) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	p.failures[op] = append(p.failures[op], err)
+}
+
+// SetFailure makes every call of operation op return err until it is cleared
+// by passing a nil error
+func (p *Memory) SetFailure(op string, err error) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	if err == nil {
+		delete(p.sticky, op)
+		return
+	}
+	p.sticky[op] = err
+}
+
+// injected returns the failure queued for op, the caller must hold the lock
+func (p *Memory) injected(op string) error {
+	if queued := p.failures[op]; len(queued) > 0 {
+		p.failures[op] = queued[1:]
+		return queued[0]
+	}
+	return p.sticky[op]
+}
+
+// SetHealth delivers a health event for a service the way Docker reports
+// health_status changes.  A monitored, active app restarts unhealthy
+// services as their restart policy allows, and the services depending on
+// them, just like the Docker event listener does, without waiting out the
+// backoff.
+func (p *Memory) SetHealth(id string, service string, healthy bool) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, err := p.service(id, service)
+	if err != nil {
+		return err
+	}
+	app.Healthy[service] = healthy
+	state := app.Restarts.get(service, app.Info.Restart)
+	state.checked(healthy, time.Now())
+	if healthy {
+		state.reset()
+	} else if app.Active && app.Monitor {
+		p.broker.Publish(serviceEvent(types.EventUnhealthy, app.Info, service, "health check failed"))
+		p.attempt(app, service, true)
+	}
+	return nil
+}
+
+// WriteLog appends a line to the log of a service as if it wrote it to
+// stdout or stderr
+func (p *Memory) WriteLog(id string, service string, stream string, line string) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, err := p.service(id, service)
+	if err != nil {
+		return err
+	}
+	app.Logs = append(app.Logs, MemoryLog{Service: service, Stream: stream, Line: line, Time: time.Now().UTC()})
+	return nil
+}
+
+// SetUsage sets the resource usage the running container of a service
+// reports
+func (p *Memory) SetUsage(id string, service string, usage types.ResourceUsage) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, err := p.service(id, service)
+	if err != nil {
+		return err
+	}
+	app.Usage[service] = usage
+	return nil
+}
+
+// SetCommand installs a program in every container, replacing the one with
+// the same name
+func (p *Memory) SetCommand(name string, command MemoryCommand) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	p.commands[name] = command
+}
+
+// Crash stops a single service with the given exit code as if its process
+// died.  A monitored, active app starts healthy services again as their
+// restart policy allows.
+func (p *Memory) Crash(id string, service string, exitCode int) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, err := p.service(id, service)
+	if err != nil {
+		return err
+	}
+	app.Running[service] = false
+	app.ExitCodes[service] = exitCode
+	if app.Active && app.Monitor && app.Healthy[service] {
+		if exitCode != 0 {
+			p.broker.Publish(serviceEvent(types.EventUnhealthy, app.Info, service, "container exited"))
+		}
+		p.attempt(app, service, exitCode != 0)
+	}
+	return nil
+}
+
+// attempt restarts a failed service as its restart policy allows, the caller
+// must hold the lock
+func (p *Memory) attempt(app *MemoryApp, service string, failed bool) {
+	state := app.Restarts.get(service, app.Info.Restart)
+	crashLooping := state.crashLooping
+	if restart, _ := state.attempt(failed, time.Now()); restart {
+		p.recover(app, service)
+	} else if state.crashLooping && !crashLooping {
+		p.broker.Publish(serviceEvent(types.EventUnhealthy, app.Info, service, types.ServiceCrashLooping))
+	}
+}
+
+// recover restarts a service and then the services depending on it, except
+// crash-looping ones, the caller must hold the lock
+func (p *Memory) recover(app *MemoryApp, service string) {
+	for _, name := range append([]string{service}, app.Graph.dependents(service)...) {
+		if name != service && app.Restarts[name] != nil && app.Restarts[name].crashLooping {
+			continue
+		}
+		app.RestartCount[name]++
+		app.Running[name] = true
+		app.ExitCodes[name] = 0
+		monitorRestarts.Inc(app.Info.Name, name)
+		p.broker.Publish(serviceEvent(types.EventRestarted, app.Info, name, ""))
+	}
+}
+
+func (p *Memory) service(id string, service string) (*MemoryApp, error) {
+	app, exists := p.Apps[id]
+	if !exists {
+		return nil, errors.New(types.InvalidID)
+	}
+	if _, exists = app.Running[service]; !exists {
+		return nil, errors.New("Service " + service + " not found")
+	}
+	return app, nil
+}
+
+func (p *Memory) setRunning(app *MemoryApp, running bool) {
+	for _, name := range app.Services {
+		app.Running[name] = running
+		app.ExitCodes[name] = 0
+	}
+	if running {
+		app.Restarts = restartStates{}
+	}
+}
+
+// Init ...
+func (p *Memory) Init() error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	return p.injected(OpInit)
+}
+
+// Deploy ...
+func (p *Memory) Deploy(metadata types.Metadata, file io.Reader, persistent bool) (*types.App, error) {
+	return p.DeployWithProgress(metadata, file, persistent, nil)
+}
+
+// DeployWithProgress ...
+func (p *Memory) DeployWithProgress(metadata types.Metadata, file io.Reader, persistent bool, progress types.Progress) (*types.App, error) {
+	timer := newPhaseTimer(progress)
+	info, err := p.deploy(metadata, file, persistent, timer.report)
+	timer.done(err)
+	p.broker.deployed("", metadata, info, err)
+	return info, err
+}
+
+func (p *Memory) deploy(metadata types.Metadata, file io.Reader, persistent bool, progress types.Progress) (*types.App, error) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	if err := p.injected(OpDeploy); err != nil {
+		return nil, err
+	}
+	uuid, err := utils.NewUUID()
+	if err != nil {
+		return nil, errors.New(types.InvalidID)
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unpack to a scratch directory only to validate the package and learn
+	// its services
+	path, err := ioutil.TempDir("", "cappsd-memory")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(path)
+	if err = utils.UnpackWithProgress(bytes.NewReader(data), path, p.Cfg, progress); err != nil {
+		return nil, err
+	}
+	services, _, err := loadComposeServices(path, uuid)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := newDepGraph(services)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := restartPolicies(metadata, services)
+	if err != nil {
+		return nil, err
+	}
+	probes, err := probePolicies(metadata, path, services)
+	if err != nil {
+		return nil, err
+	}
+	progress.Report(types.PhaseStarting, 0, 0)
+
+	if persistent {
+		metadata.DelayStart = "no"
+		p.Packages[metadata.Name] = data
+	}
+	app := &MemoryApp{
+		Info: types.App{
+			UUID:    uuid,
+			Name:    metadata.Name,
+			Version: metadata.Version,
+			Path:    p.Cfg.DataVolume + "/" + uuid,
+			Monitor: metadata.Monitor,
+			Active:  "yes",
+			Restart: policies,
+			Probes:  probes,
+		},
+		Services:     graph.order,
+		Graph:        graph,
+		Running:      make(map[string]bool),
+		Healthy:      make(map[string]bool),
+		ExitCodes:    make(map[string]int),
+		RestartCount: make(map[string]int),
+		Usage:        make(map[string]types.ResourceUsage),
+		Restarts:     restartStates{},
+		Monitor:      strings.EqualFold(metadata.Monitor, "yes"),
+		Active:       true,
+	}
+	for _, name := range graph.order {
+		app.Healthy[name] = true
+	}
+	p.setRunning(app, !strings.EqualFold(metadata.DelayStart, "yes"))
+	p.Apps[uuid] = app
+	p.PApps[metadata.Name] = &metadata
+
+	info := app.Info
+	return &info, nil
+}
+
+// Upgrade validates the new package and swaps it in under the same UUID.  An
+// injected upgrade failure stands in for the new version failing to come up
+// and leaves the previous version running, as a rollback would.
+func (p *Memory) Upgrade(id string, metadata types.Metadata, file io.Reader) (*types.App, error) {
+	info, err := p.upgrade(id, metadata, file)
+	p.broker.deployed(id, metadata, info, err)
+	return info, err
+}
+
+func (p *Memory) upgrade(id string, metadata types.Metadata, file io.Reader) (*types.App, error) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return nil, errors.New(types.InvalidID)
+	}
+	if metadata.Name != "" && metadata.Name != app.Info.Name {
+		return nil, errors.New("Upgrade must keep the application name " + app.Info.Name)
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	path, err := ioutil.TempDir("", "cappsd-memory")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(path)
+	if err = utils.Unpack(bytes.NewReader(data), path, p.Cfg); err != nil {
+		return nil, err
+	}
+	services, _, err := loadComposeServices(path, id)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := newDepGraph(services)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := restartPolicies(metadata, services)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Probes, err = probePolicies(metadata, path, services); err != nil {
+		return nil, err
+	}
+	if err = p.injected(OpUpgrade); err != nil {
+		p.setRunning(app, true)
+		app.Active = true
+		app.Info.Active = "yes"
+		return nil, upgradeFailed(app.Info.Version, err, nil)
+	}
+
+	if _, persistent := p.Packages[app.Info.Name]; persistent {
+		p.Packages[app.Info.Name] = data
+	}
+	return p.replace(app, metadata, graph, policies), nil
+}
+
+// Rollback swaps a version from the history in for the deployed one.  An
+// injected rollback failure leaves the deployed version running and the
+// archived version in the history.
+func (p *Memory) Rollback(id string, version string) (*types.App, error) {
+	info, err := p.rollback(id, version)
+	p.broker.deployed(id, types.Metadata{Version: version}, info, err)
+	return info, err
+}
+
+func (p *Memory) rollback(id string, version string) (*types.App, error) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return nil, errors.New(types.InvalidID)
+	}
+	versions := p.versions[app.Info.Name]
+	for i, archived := range versions {
+		if archived.Version.Version != version {
+			continue
+		}
+		if err := p.injected(OpRollback); err != nil {
+			return nil, upgradeFailed(app.Info.Version, err, nil)
+		}
+		p.versions[app.Info.Name] = append(versions[:i:i], versions[i+1:]...)
+		metadata := types.Metadata{
+			Name:    archived.Version.Name,
+			Version: archived.Version.Version,
+			Monitor: archived.Version.Monitor,
+			Restart: archived.Version.Restart,
+			Probes:  archived.Version.Probes,
+		}
+		return p.replace(app, metadata, archived.Graph, archived.Version.Restart), nil
+	}
+	return nil, errors.New(types.InvalidVersion)
+}
+
+// History ...
+func (p *Memory) History(id string) (*types.AppHistory, error) {
+	p.Lock.RLock()
+	defer p.Lock.RUnlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return nil, errors.New(types.InvalidID)
+	}
+	history := &types.AppHistory{
+		UUID:     id,
+		Name:     app.Info.Name,
+		Version:  app.Info.Version,
+		Versions: []types.AppVersion{},
+	}
+	for _, archived := range p.versions[app.Info.Name] {
+		history.Versions = append(history.Versions, archived.Version)
+	}
+	return history, nil
+}
+
+// replace archives the deployed version of app and starts the services of
+// graph in its place, the caller must hold the lock
+func (p *Memory) replace(app *MemoryApp, metadata types.Metadata, graph *depGraph, policies map[string]types.RestartPolicy) *types.App {
+	p.archive(app)
+	app.Info.Version = metadata.Version
+	app.Info.Restart = policies
+	app.Info.Probes = metadata.Probes
+	if metadata.Monitor != "" {
+		app.Info.Monitor = metadata.Monitor
+		app.Monitor = strings.EqualFold(metadata.Monitor, "yes")
+	}
+	app.Services = graph.order
+	app.Graph = graph
+	app.Running = make(map[string]bool)
+	app.Healthy = make(map[string]bool)
+	app.ExitCodes = make(map[string]int)
+	app.RestartCount = make(map[string]int)
+	for _, name := range graph.order {
+		app.Healthy[name] = true
+	}
+	p.setRunning(app, true)
+	app.Active = true
+	app.Info.Active = "yes"
+	if _, persistent := p.PApps[app.Info.Name]; persistent {
+		metadata.Name = app.Info.Name
+		p.PApps[app.Info.Name] = &metadata
+	}
+
+	info := app.Info
+	return &info
+}
+
+// archive adds the deployed version of app to the history, keeping only the
+// newest Cfg.HistoryRetention versions.  The caller must hold the lock.
+func (p *Memory) archive(app *MemoryApp) {
+	if p.Cfg.HistoryRetention <= 0 {
+		return
+	}
+	version := AppVersion(app.Info)
+	version.Archived = time.Now().UTC()
+	versions := []memoryVersion{{Version: version, Graph: app.Graph}}
+	for _, archived := range p.versions[app.Info.Name] {
+		if archived.Version.Version != version.Version && len(versions) < p.Cfg.HistoryRetention {
+			versions = append(versions, archived)
+		}
+	}
+	p.versions[app.Info.Name] = versions
+}
+
+// Undeploy ...
+func (p *Memory) Undeploy(id string) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	if err := p.injected(OpUndeploy); err != nil {
+		return err
+	}
+	app, exists := p.Apps[id]
+	if !exists {
+		return errors.New(types.InvalidID)
+	}
+	p.archive(app)
+	delete(p.Apps, id)
+	p.broker.Publish(appEvent(types.EventPurged, app.Info))
+	return nil
+}
+
+// PurgePersistent ...
+func (p *Memory) PurgePersistent(name string) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	if err := p.injected(OpPurgePersistent); err != nil {
+		return err
+	}
+	if _, exists := p.PApps[name]; !exists {
+		return errors.New(types.InvalidName)
+	}
+	delete(p.PApps, name)
+	delete(p.Packages, name)
+	return nil
+}
+
+// PruneImages reports nothing to remove, since no images are ever loaded
+func (p *Memory) PruneImages() (*types.PruneReport, error) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	if err := p.injected(OpPruneImages); err != nil {
+		return nil, err
+	}
+	return &types.PruneReport{Images: []string{}}, nil
+}
+
+// Kill ...
+func (p *Memory) Kill(id string) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	if err := p.injected(OpKill); err != nil {
+		return err
+	}
+	app, exists := p.Apps[id]
+	if !exists {
+		return errors.New(types.InvalidID)
+	}
+	delete(p.Apps, id)
+	p.broker.Publish(appEvent(types.EventPurged, app.Info))
+	return nil
+}
+
+// Start ...
+func (p *Memory) Start(id string) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return errors.New(types.InvalidID)
+	}
+	if err := p.injected(OpStart); err != nil {
+		return err
+	}
+	p.setRunning(app, true)
+	app.Active = true
+	app.Info.Active = "yes"
+	p.broker.Publish(appEvent(types.EventStarted, app.Info))
+	return nil
+}
+
+// Stop ...
+func (p *Memory) Stop(id string) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return errors.New(types.InvalidID)
+	}
+	app.Active = false
+	app.Info.Active = "no"
+	if err := p.injected(OpStop); err != nil {
+		return err
+	}
+	p.setRunning(app, false)
+	p.broker.Publish(appEvent(types.EventStopped, app.Info))
+	return nil
+}
+
+// Restart ...
+func (p *Memory) Restart(id string) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return errors.New(types.InvalidID)
+	}
+	app.Active = false
+	p.setRunning(app, false)
+	if err := p.injected(OpRestart); err != nil {
+		return err
+	}
+	p.setRunning(app, true)
+	app.Active = true
+	app.Info.Active = "yes"
+	p.broker.Publish(appEvent(types.EventRestarted, app.Info))
+	return nil
+}
+
+// Subscribe ...
+func (p *Memory) Subscribe() (<-chan types.LifecycleEvent, func()) {
+	return p.broker.Subscribe()
+}
+
+// GetApplication ...
+func (p *Memory) GetApplication(id string) (*types.AppDetails, error) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return nil, errors.New(types.InvalidID)
+	}
+	if err := p.injected(OpGetApplication); err != nil {
+		return nil, err
+	}
+	details := types.AppDetails{
+		UUID:    app.Info.UUID,
+		Name:    app.Info.Name,
+		Version: app.Info.Version,
+		Monitor: app.Info.Monitor,
+	}
+	for i, name := range app.Services {
+		state := "Up"
+		if !app.Running[name] {
+			state = fmt.Sprintf("Exit %d", app.ExitCodes[name])
+		}
+		details.Containers = append(details.Containers, types.Container{
+			ID:      fmt.Sprintf("%064x", i+1),
+			Name:    app.Info.UUID + "_" + name + "_1",
+			Command: "",
+			State:   state,
+			Ports:   "",
+		})
+	}
+	details.Services = app.Restarts.statuses(app.Info.Restart)
+	return &details, nil
+}
+
+// Logs writes the log lines of the services of an app.  Following logs
+// returns once ctx is done or the app is stopped or removed.
+func (p *Memory) Logs(ctx context.Context, id string, options types.LogOptions, out *LogWriter) error {
+	p.Lock.Lock()
+	app, exists := p.Apps[id]
+	if !exists {
+		p.Lock.Unlock()
+		return errors.New(types.InvalidID)
+	}
+	if err := p.injected(OpLogs); err != nil {
+		p.Lock.Unlock()
+		return err
+	}
+	if _, err := logServices(options.Service, app.Info.Restart); err != nil {
+		p.Lock.Unlock()
+		return err
+	}
+	var lines []MemoryLog
+	count := make(map[string]int)
+	for i := len(app.Logs) - 1; i >= 0; i-- {
+		line := app.Logs[i]
+		if (options.Service != "" && line.Service != options.Service) || line.Time.Before(options.Since) {
+			continue
+		}
+		if options.Tail >= 0 && count[line.Service] >= options.Tail {
+			continue
+		}
+		count[line.Service]++
+		lines = append([]MemoryLog{line}, lines...)
+	}
+	read := len(app.Logs)
+	p.Lock.Unlock()
+
+	if options.Follow {
+		out.Flush()
+	}
+	for {
+		for _, line := range lines {
+			text := line.Line
+			if options.Timestamps {
+				text = line.Time.Format(time.RFC3339Nano) + " " + text
+			}
+			if err := out.Line(line.Service+"_1", line.Stream, text); err != nil {
+				return err
+			}
+		}
+		if !options.Follow {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(memoryFollowInterval):
+		}
+
+		p.Lock.Lock()
+		app, exists = p.Apps[id]
+		if !exists || !app.Active {
+			p.Lock.Unlock()
+			return nil
+		}
+		lines = nil
+		for _, line := range app.Logs[read:] {
+			if options.Service == "" || line.Service == options.Service {
+				lines = append(lines, line)
+			}
+		}
+		read = len(app.Logs)
+		p.Lock.Unlock()
+	}
+}
+
+// command returns the program a command runs in a running service of an app
+func (p *Memory) command(id string, request types.ExecRequest) (MemoryCommand, error) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	app, exists := p.Apps[id]
+	if !exists {
+		return nil, errors.New(types.InvalidID)
+	}
+	if err := p.injected(OpExec); err != nil {
+		return nil, err
+	}
+	if err := validateExec(request, app.Info.Restart); err != nil {
+		return nil, err
+	}
+	if !app.Running[request.Service] {
+		return nil, errors.New("No running container")
+	}
+	command, exists := p.commands[request.Command[0]]
+	if !exists {
+		return func(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+			fmt.Fprintln(stderr, request.Command[0]+": not found")
+			return 127
+		}, nil
+	}
+	return command, nil
+}
+
+// Exec runs a command in a service of an app and returns its output
+func (p *Memory) Exec(ctx context.Context, id string, request types.ExecRequest) (*types.ExecResult, error) {
+	command, err := p.command(id, request)
+	if err != nil {
+		return nil, err
+	}
+	stdout, stderr := newLimitedBuffer(), newLimitedBuffer()
+	code := command(request.Command[1:], strings.NewReader(request.Stdin), stdout, stderr)
+	return &types.ExecResult{ExitCode: code, Stdout: stdout.String(), Stderr: stderr.String()}, nil
+}
+
+// ExecStream runs an interactive command in a service of an app, its
+// terminal writes all output to stream
+func (p *Memory) ExecStream(ctx context.Context, id string, request types.ExecRequest, stream io.ReadWriter) (int, error) {
+	request.TTY = true
+	command, err := p.command(id, request)
+	if err != nil {
+		return -1, err
+	}
+	return command(request.Command[1:], stream, stream, stream), nil
+}
+
+// Stats reports the resource usage set for the running services of an app
+func (p *Memory) Stats(ctx context.Context, id string) (*types.AppStats, error) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return nil, errors.New(types.InvalidID)
+	}
+	if err := p.injected(OpStats); err != nil {
+		return nil, err
+	}
+	stats := &types.AppStats{UUID: id, Time: time.Now().UTC(), Containers: []types.ContainerStats{}}
+	for i, name := range app.Services {
+		if app.Running[name] {
+			stats.Containers = append(stats.Containers, types.ContainerStats{
+				ID:            fmt.Sprintf("%064x", i+1),
+				Name:          name + "_1",
+				Service:       name,
+				ResourceUsage: app.Usage[name],
+			})
+		}
+	}
+	stats.Total = totalUsage(stats.Containers)
+	return stats, nil
+}
+
+// Health reports the health of the services of an app
+func (p *Memory) Health(id string) (*types.AppHealth, error) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	app, exists := p.Apps[id]
+	if !exists {
+		return nil, errors.New(types.InvalidID)
+	}
+	if err := p.injected(OpHealth); err != nil {
+		return nil, err
+	}
+	health := &types.AppHealth{UUID: id, Services: make(map[string]types.ServiceHealth)}
+	for _, name := range app.Services {
+		health.Services[name] = app.Restarts.lookup(name, app.Info.Restart).health(app.Running[name], app.Healthy[name])
+	}
+	health.Status = aggregateHealth(health.Services)
+	return health, nil
+}
+
+// ListApplications ...
+func (p *Memory) ListApplications() types.Applications {
+	p.Lock.RLock()
+	defer p.Lock.RUnlock()
+
+	var response types.Applications
+	ids := make([]string, 0, len(p.Apps))
+	for id := range p.Apps {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		response.Apps = append(response.Apps, p.Apps[id].Info)
+	}
+	return response
+}
+
+// ListPersistentApplications ...
+func (p *Memory) ListPersistentApplications() types.PersistentApps {
+	p.Lock.RLock()
+	defer p.Lock.RUnlock()
+
+	var response types.PersistentApps
+	names := make([]string, 0, len(p.PApps))
+	for name := range p.PApps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		response.PApps = append(response.PApps, *p.PApps[name])
+	}
+	return response
+}
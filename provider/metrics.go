@@ -0,0 +1,52 @@
+package provider
+
+import (
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/metrics"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// phaseValidating is the phase of a deploy before its package is unpacked
+const phaseValidating = "validating"
+
+// The metrics of deploys and of the monitor
+var (
+	deployDuration = metrics.NewHistogram("cappsd_deploy_phase_duration_seconds",
+		"Time deploys spent in each phase.", nil, "phase")
+	deployFailures = metrics.NewCounter("cappsd_deploy_failures_total",
+		"Deploys that failed, by the phase they failed in.", "phase")
+	imageLoadBytes = metrics.NewCounter("cappsd_image_load_bytes_total",
+		"Size of the image archives loaded into the container runtime.")
+	monitorRestarts = metrics.NewCounter("cappsd_monitor_restarts_total",
+		"Services restarted by the monitor, by app and service.", "app", "service")
+)
+
+// phaseTimer times the phases a deploy reports to its progress
+type phaseTimer struct {
+	progress types.Progress
+	phase    string
+	started  time.Time
+}
+
+func newPhaseTimer(progress types.Progress) *phaseTimer {
+	return &phaseTimer{progress: progress, phase: phaseValidating, started: time.Now()}
+}
+
+// report is the types.Progress of the deploy, it passes the progress on
+func (t *phaseTimer) report(phase string, current int, total int) {
+	if phase != t.phase {
+		now := time.Now()
+		deployDuration.Observe(now.Sub(t.started).Seconds(), t.phase)
+		t.phase, t.started = phase, now
+	}
+	t.progress.Report(phase, current, total)
+}
+
+// done records the last phase and, when err is set, the failure in it
+func (t *phaseTimer) done(err error) {
+	deployDuration.Observe(time.Since(t.started).Seconds(), t.phase)
+	if err != nil {
+		deployFailures.Inc(t.phase)
+	}
+}
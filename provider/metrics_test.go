@@ -0,0 +1,33 @@
+package provider
+
+import (
+	"errors"
+	"testing"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+func TestPhaseTimer(t *testing.T) {
+	unpacking := deployDuration.Count(types.PhaseUnpacking)
+	loading := deployDuration.Count(types.PhaseLoadingImages)
+	failed := deployFailures.Value(types.PhaseLoadingImages)
+	var reported []string
+	timer := newPhaseTimer(func(phase string, current int, total int) {
+		reported = append(reported, phase)
+	})
+	timer.report(types.PhaseUnpacking, 0, 0)
+	timer.report(types.PhaseLoadingImages, 1, 2)
+	timer.report(types.PhaseLoadingImages, 2, 2)
+	timer.done(errors.New("No space left on device"))
+
+	if len(reported) != 3 {
+		t.Error("Expected the progress to be passed on, got ", reported)
+	}
+	if deployDuration.Count(types.PhaseUnpacking) != unpacking+1 || deployDuration.Count(types.PhaseLoadingImages) != loading+1 {
+		t.Error("Expected each phase to be timed once")
+	}
+	if deployFailures.Value(types.PhaseLoadingImages) != failed+1 {
+		t.Error("Expected the failure to be counted in the last phase")
+	}
+	newPhaseTimer(nil).done(nil)
+}
@@ -0,0 +1,380 @@
+package provider
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/libcompose/labels"
+	"github.com/docker/libcompose/project"
+	"github.com/docker/libcompose/project/events"
+	"golang.org/x/net/context"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/utils"
+)
+
+// EventListener monitors the compose event stream of every running app and
+// runs the probes of its services.  Each app's stream and each probe has its
+// own goroutine, so events are handled as they arrive.  The provider lock is
+// only taken to look up and update health, never while a service is being
+// restarted, so deploys and other API calls are not held up by the monitor.
+type EventListener struct {
+	provider *Docker
+	lock     sync.Mutex
+	watches  map[string]context.CancelFunc
+}
+
+// The compose and docker calls of the monitor, tests replace them since
+// compose projects cannot be faked outside libcompose
+var (
+	// projectEvents streams the container events of a project since a time,
+	// the zero time for new events only, until the context is done or an
+	// error ends the stream
+	projectEvents = func(ctx context.Context, prj project.APIProject, since time.Time) (<-chan events.ContainerEvent, <-chan error) {
+		stream, errs := make(chan events.ContainerEvent), make(chan error, 1)
+		cli, err := dockerClient()
+		if err != nil {
+			errs <- err
+			return stream, errs
+		}
+		filter := filters.NewArgs()
+		filter.Add("type", "container")
+		filter.Add("label", labels.PROJECT.Str()+"="+prj.(*project.Project).Name)
+		filter.Add("label", labels.SERVICE.Str())
+		options := dockertypes.EventsOptions{Filters: filter}
+		if !since.IsZero() {
+			options.Since = strconv.FormatInt(since.Unix(), 10)
+		}
+		messages, failed := cli.Events(ctx, options)
+		go func() {
+			for {
+				select {
+				case message := <-messages:
+					event := events.ContainerEvent{
+						Service: message.Actor.Attributes[labels.SERVICE.Str()],
+						Event:   message.Action,
+						Type:    message.Type,
+						ID:      message.Actor.ID,
+						Time:    time.Unix(message.Time, 0),
+					}
+					select {
+					case stream <- event:
+					case <-ctx.Done():
+						return
+					}
+				case err := <-failed:
+					errs <- err
+					return
+				}
+			}
+		}()
+		return stream, errs
+	}
+	restartService = func(prj project.APIProject, service string) error {
+		return prj.Restart(context.Background(), 5, service)
+	}
+	startService = func(prj project.APIProject, service string) error {
+		return prj.Start(context.Background(), service)
+	}
+	containerState = func(containerID string) (bool, int, error) {
+		cli, err := dockerClient()
+		if err != nil {
+			return false, 0, err
+		}
+		container, err := cli.ContainerInspect(context.Background(), containerID)
+		if err != nil {
+			return false, 0, err
+		}
+		return container.State.Running, container.State.ExitCode, nil
+	}
+)
+
+// newWatchBackoff paces watching the events of an app again after the
+// stream failed, when docker restarts for example
+var newWatchBackoff = func() utils.Backoff {
+	return utils.NewSimpleBackoff(time.Second, time.Minute, 0.2, 2)
+}
+
+// NewListener ...
+func NewListener(d *Docker) *EventListener {
+	return &EventListener{
+		provider: d,
+		watches:  make(map[string]context.CancelFunc),
+	}
+}
+
+// watch starts consuming the events of an app's project and probing its
+// services, replacing the watch of a previous project of the app
+func (l *EventListener) watch(id string, name string, prj project.APIProject, probes map[string]types.ServiceProbes) {
+	l.unwatch(id)
+	logger := appLog(id, name)
+	ctx, cancel := context.WithCancel(context.Background())
+	l.lock.Lock()
+	l.watches[id] = cancel
+	l.lock.Unlock()
+	stream := projectEvents
+	open := func(since time.Time) (<-chan events.ContainerEvent, <-chan error) {
+		return stream(ctx, prj, since)
+	}
+	go l.consume(ctx, logger, id, open, newWatchBackoff())
+
+	find, run := serviceContainer, runProbe
+	check := func(service string, probe types.Probe, timeout time.Duration) error {
+		containerID, err := find(prj, service)
+		if err == nil {
+			err = run(containerID, probe, timeout)
+		}
+		return err
+	}
+	for service, probe := range probes {
+		if probe.Liveness != nil {
+			go l.probe(ctx, logger, id, check, service, *probe.Liveness, true)
+		}
+		if probe.Readiness != nil {
+			go l.probe(ctx, logger, id, check, service, *probe.Readiness, false)
+		}
+	}
+}
+
+// unwatch stops consuming the events of an app and probing it.  Events of the app that are
+// still waiting for the provider lock are dropped.
+func (l *EventListener) unwatch(id string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if cancel, exists := l.watches[id]; exists {
+		cancel()
+		delete(l.watches, id)
+	}
+}
+
+// consume handles the events of an app until it is unwatched.  When the
+// stream fails it is opened again, with a backoff, from the time it failed
+// so the events in between are not missed.
+func (l *EventListener) consume(ctx context.Context, logger *logging.Logger, id string, open func(time.Time) (<-chan events.ContainerEvent, <-chan error), backoff utils.Backoff) {
+	var since time.Time
+	for {
+		stream, errs := open(since)
+		err := l.drain(ctx, id, stream, errs, backoff)
+		if ctx.Err() != nil {
+			return
+		}
+		since = time.Now()
+		wait := backoff.Duration()
+		logger.Warn("Lost the events of the app, watching them again", "error", err, "retry", wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// drain handles the events of a stream until it fails, resetting the backoff
+// once events arrive again
+func (l *EventListener) drain(ctx context.Context, id string, stream <-chan events.ContainerEvent, errs <-chan error, backoff utils.Backoff) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errs:
+			return err
+		case event := <-stream:
+			backoff.Reset()
+			l.handle(ctx, id, event)
+		}
+	}
+}
+
+// handle records health changes of a monitored app and restarts services
+// that turn unhealthy or die while healthy, as their restart policy allows.
+// Waiting out the backoff holds up later events of the app, which are
+// handled once the service was restarted.
+func (l *EventListener) handle(ctx context.Context, id string, event events.ContainerEvent) {
+	// Most events, such as those of exec probes, change nothing
+	switch event.Event {
+	case "die", "health_status: healthy", "health_status: unhealthy":
+	default:
+		return
+	}
+	l.provider.Lock.RLock()
+	app, exists := l.provider.Apps[id]
+	monitored := exists && app.Active && app.Monitor
+	l.provider.Lock.RUnlock()
+	if ctx.Err() != nil || !monitored {
+		return
+	}
+
+	failed := true
+	if event.Event == "die" {
+		// Containers restarted since, by compose or the monitor, are fine
+		running, exitCode, err := containerState(event.ID)
+		if err == nil && running {
+			return
+		}
+		failed = err != nil || exitCode != 0
+	}
+
+	l.provider.Lock.Lock()
+	app, exists = l.provider.Apps[id]
+	if ctx.Err() != nil || !exists || !app.Active || !app.Monitor {
+		l.provider.Lock.Unlock()
+		return
+	}
+	health, exists := l.provider.IsHealthyMap[id]
+	if !exists {
+		health = make(map[string]bool)
+		l.provider.IsHealthyMap[id] = health
+	}
+	if app.Restarts == nil {
+		app.Restarts = restartStates{}
+	}
+	state := app.Restarts.get(event.Service, app.Info.Restart)
+	info := app.Info
+	client := app.Client
+	graph := app.Graph
+	recover := restartService
+	restart, wait := false, time.Duration(0)
+	crashLooping := state.crashLooping
+	switch {
+	case event.Event == "health_status: unhealthy":
+		health[event.Service] = false
+		state.checked(false, time.Now())
+		l.provider.broker.Publish(serviceEvent(types.EventUnhealthy, info, event.Service, "health check failed"))
+		restart, wait = state.attempt(true, time.Now())
+	case event.Event == "health_status: healthy":
+		health[event.Service] = true
+		state.checked(true, time.Now())
+		state.reset()
+	case health[event.Service] && event.Event == "die":
+		if failed {
+			l.provider.broker.Publish(serviceEvent(types.EventUnhealthy, info, event.Service, "container exited"))
+		}
+		recover = startService
+		restart, wait = state.attempt(failed, time.Now())
+	}
+	if state.crashLooping {
+		appLog(id, info.Name).Warn("Service is crash-looping, giving up", "service", event.Service, "restarts", state.restarts)
+		if !crashLooping {
+			l.provider.broker.Publish(serviceEvent(types.EventUnhealthy, info, event.Service, types.ServiceCrashLooping))
+		}
+	}
+	state.waiting = restart
+	l.provider.Lock.Unlock()
+	if !restart {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(wait):
+	}
+	err := recover(client, event.Service)
+	if err != nil {
+		appLog(id, info.Name).Error("Failed to recover service", "service", event.Service, "error", err)
+	} else {
+		monitorRestarts.Inc(info.Name, event.Service)
+		l.provider.broker.Publish(serviceEvent(types.EventRestarted, info, event.Service, ""))
+	}
+	l.provider.Lock.Lock()
+	state.waiting = false
+	l.provider.Lock.Unlock()
+	if err == nil && graph != nil {
+		l.restartDependents(ctx, id, info, client, graph.dependents(event.Service))
+	}
+}
+
+// restartDependents restarts the services depending on a recovered one, in
+// start order, so they reconnect to it.  Crash-looping services are left
+// alone.
+func (l *EventListener) restartDependents(ctx context.Context, id string, info types.App, client project.APIProject, dependents []string) {
+	for _, service := range dependents {
+		l.provider.Lock.RLock()
+		app, exists := l.provider.Apps[id]
+		active := ctx.Err() == nil && exists && app.Active
+		crashLooping := active && app.Restarts[service] != nil && app.Restarts[service].crashLooping
+		l.provider.Lock.RUnlock()
+		if !active {
+			return
+		}
+		if crashLooping {
+			continue
+		}
+		logger := appLog(id, info.Name).With("service", service)
+		logger.Info("Restarting service after its dependency recovered")
+		if err := restartService(client, service); err != nil {
+			logger.Error("Failed to restart service", "error", err)
+		} else {
+			monitorRestarts.Inc(info.Name, service)
+			l.provider.broker.Publish(serviceEvent(types.EventRestarted, info, service, "dependency recovered"))
+		}
+	}
+}
+
+// probe runs a liveness or readiness probe of a service until the app is
+// unwatched.  Once the probe failed threshold times in a row a liveness
+// probe reports the service unhealthy, restarting it like a failed docker
+// healthcheck, and a readiness probe marks it not ready.  The next success
+// marks it healthy or ready again.
+func (l *EventListener) probe(ctx context.Context, logger *logging.Logger, id string, check func(string, types.Probe, time.Duration) error, service string, probe types.Probe, liveness bool) {
+	period, timeout, threshold := probeTimings(probe)
+	delay := time.Duration(probe.InitialDelaySeconds) * probeSecond
+	failures, healthy := 0, true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = period
+
+		err := check(service, probe, timeout)
+		report := false
+		if err == nil {
+			failures = 0
+			report = !healthy
+			healthy = true
+		} else if failures++; failures >= threshold {
+			logger.Warn("Probe failed", "service", service, "failures", failures, "error", err)
+			failures = 0
+			report = true
+			healthy = false
+		}
+
+		if !liveness || !report {
+			l.checked(ctx, id, service, err == nil, !liveness && report)
+			continue
+		}
+		// The health event records the check
+		event := "health_status: healthy"
+		if !healthy {
+			event = "health_status: unhealthy"
+			// The restarted container gets its initial delay again
+			delay = time.Duration(probe.InitialDelaySeconds) * probeSecond
+		}
+		l.handle(ctx, id, events.ContainerEvent{Service: service, Event: event})
+	}
+}
+
+// checked records the result of a probe of a service of a monitored app,
+// and its readiness when ready is set
+func (l *EventListener) checked(ctx context.Context, id string, service string, passed bool, ready bool) {
+	l.provider.Lock.Lock()
+	defer l.provider.Lock.Unlock()
+	app, exists := l.provider.Apps[id]
+	if ctx.Err() != nil || !exists || !app.Active || !app.Monitor {
+		return
+	}
+	if app.Restarts == nil {
+		app.Restarts = restartStates{}
+	}
+	state := app.Restarts.get(service, app.Info.Restart)
+	state.checked(passed, time.Now())
+	if ready {
+		state.unready = !passed
+	}
+}
@@ -0,0 +1,477 @@
+package provider
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	composecfg "github.com/docker/libcompose/config"
+	"github.com/docker/libcompose/project"
+	"github.com/docker/libcompose/project/events"
+	"golang.org/x/net/context"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/utils"
+)
+
+func expectService(t *testing.T, calls chan string, service string) {
+	select {
+	case called := <-calls:
+		if called != service {
+			t.Error("Expected ", service, " got ", called)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected ", service, " to be recovered")
+	}
+}
+
+func expectNoCall(t *testing.T, calls chan string) {
+	select {
+	case called := <-calls:
+		t.Error("Expected no recovery, got ", called)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// fakeMonitor replaces the compose and docker calls of the monitor.  Dead
+// containers are named after their exit code.
+type fakeMonitor struct {
+	stream   chan events.ContainerEvent
+	errs     chan error
+	watches  chan time.Time
+	restarts chan string
+	starts   chan string
+	release  chan bool
+}
+
+func newFakeMonitor() (*fakeMonitor, func()) {
+	m := &fakeMonitor{
+		stream:   make(chan events.ContainerEvent),
+		errs:     make(chan error),
+		watches:  make(chan time.Time, 10),
+		restarts: make(chan string, 1),
+		starts:   make(chan string, 1),
+		release:  make(chan bool, 10),
+	}
+	savedEvents, savedRestart, savedStart := projectEvents, restartService, startService
+	savedState, savedBackoff, savedWatch := containerState, newRestartBackoff, newWatchBackoff
+	projectEvents = func(ctx context.Context, prj project.APIProject, since time.Time) (<-chan events.ContainerEvent, <-chan error) {
+		m.watches <- since
+		return m.stream, m.errs
+	}
+	restartService = func(prj project.APIProject, service string) error {
+		m.restarts <- service
+		<-m.release
+		return nil
+	}
+	startService = func(prj project.APIProject, service string) error {
+		m.starts <- service
+		return nil
+	}
+	containerState = func(id string) (bool, int, error) {
+		if id == "running" {
+			return true, 0, nil
+		}
+		return false, map[string]int{"exit0": 0, "exit1": 1}[id], nil
+	}
+	newRestartBackoff = func() utils.Backoff {
+		return utils.NewSimpleBackoff(0, 0, 0, 1)
+	}
+	newWatchBackoff = newRestartBackoff
+	return m, func() {
+		projectEvents, restartService, startService = savedEvents, savedRestart, savedStart
+		containerState, newRestartBackoff, newWatchBackoff = savedState, savedBackoff, savedWatch
+	}
+}
+
+func monitoredDocker(policies map[string]types.RestartPolicy) *Docker {
+	p := NewDocker(config.Config{})
+	p.Apps["app"] = &ComposeApp{Info: types.App{UUID: "app", Restart: policies}, Monitor: true, Active: true}
+	p.IsHealthyMap["app"] = map[string]bool{"web": true}
+	p.Lock.Lock()
+	p.monitor("app")
+	p.Lock.Unlock()
+	return p
+}
+
+func TestEventListener(t *testing.T) {
+	m, restore := newFakeMonitor()
+	defer restore()
+	p := monitoredDocker(nil)
+	lifecycle, unsubscribe := p.Subscribe()
+	defer unsubscribe()
+
+	// The provider stays available while the monitor restarts a service
+	m.stream <- events.ContainerEvent{Service: "web", Event: "health_status: unhealthy"}
+	expectService(t, m.restarts, "web")
+	p.Lock.Lock()
+	if p.IsHealthyMap["app"]["web"] {
+		t.Error("Expected web to be marked unhealthy")
+	}
+	p.Lock.Unlock()
+	m.release <- true
+	for _, kind := range []string{types.EventUnhealthy, types.EventRestarted} {
+		select {
+		case event := <-lifecycle:
+			if event.Type != kind || event.UUID != "app" || event.Service != "web" {
+				t.Error("Expected ", kind, " event of web, got ", event)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected ", kind, " event")
+		}
+	}
+
+	// Unhealthy services that die are left to the restart, containers that
+	// are running again are fine
+	m.stream <- events.ContainerEvent{Service: "web", Event: "die", ID: "exit1"}
+	m.stream <- events.ContainerEvent{Service: "web", Event: "health_status: healthy"}
+	m.stream <- events.ContainerEvent{Service: "web", Event: "die", ID: "running"}
+	expectNoCall(t, m.starts)
+	m.stream <- events.ContainerEvent{Service: "web", Event: "die", ID: "exit0"}
+	expectService(t, m.starts, "web")
+
+	// Events that change nothing are handled without the provider lock
+	p.Lock.Lock()
+	for _, event := range []string{"exec_create: true", "exec_start: true", "exec_die"} {
+		select {
+		case m.stream <- events.ContainerEvent{Service: "web", Event: event}:
+		case <-time.After(time.Second):
+			t.Error("Expected ", event, " to be handled while the provider is locked")
+		}
+	}
+	p.Lock.Unlock()
+
+	// A failed stream is watched again from the time it failed
+	if since := <-m.watches; !since.IsZero() {
+		t.Error("Expected the first watch to stream new events, got ", since)
+	}
+	m.errs <- errors.New("docker restarted")
+	select {
+	case since := <-m.watches:
+		if since.IsZero() {
+			t.Error("Expected the events since the failure to be watched")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the events to be watched again")
+	}
+	m.stream <- events.ContainerEvent{Service: "web", Event: "die", ID: "exit0"}
+	expectService(t, m.starts, "web")
+
+	p.listener.unwatch("app")
+	select {
+	case m.stream <- events.ContainerEvent{Service: "web", Event: "die", ID: "exit1"}:
+		t.Error("Expected events to be ignored after unwatch")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRestartPolicies(t *testing.T) {
+	m, restore := newFakeMonitor()
+	defer restore()
+	p := monitoredDocker(map[string]types.RestartPolicy{"web": {Policy: types.RestartOnFailure, MaxRetries: 2}})
+
+	// Clean exits are not restarted on-failure
+	m.stream <- events.ContainerEvent{Service: "web", Event: "die", ID: "exit0"}
+	expectNoCall(t, m.starts)
+	for i := 0; i < 2; i++ {
+		m.stream <- events.ContainerEvent{Service: "web", Event: "die", ID: "exit1"}
+		expectService(t, m.starts, "web")
+	}
+	m.stream <- events.ContainerEvent{Service: "web", Event: "die", ID: "exit1"}
+	expectNoCall(t, m.starts)
+
+	p.Lock.Lock()
+	status := p.Apps["app"].Restarts.statuses(p.Apps["app"].Info.Restart)["web"]
+	p.Lock.Unlock()
+	if status.State != types.ServiceCrashLooping || status.Restarts != 2 {
+		t.Error("Expected web to be crash-looping after 2 restarts, got ", status)
+	}
+
+	// A healthy service starts a new series of retries
+	m.stream <- events.ContainerEvent{Service: "web", Event: "health_status: healthy"}
+	m.stream <- events.ContainerEvent{Service: "web", Event: "die", ID: "exit1"}
+	expectService(t, m.starts, "web")
+	p.listener.unwatch("app")
+}
+
+func TestResolveRestartPolicies(t *testing.T) {
+	services := map[string]*composecfg.ServiceConfig{
+		"web":   {Labels: map[string]string{RestartPolicyLabel: "on-failure", RestartMaxRetriesLabel: "3"}},
+		"db":    {Labels: map[string]string{RestartPolicyLabel: "never"}},
+		"cache": {},
+	}
+	policies, err := restartPolicies(types.Metadata{Restart: map[string]types.RestartPolicy{
+		"db": {Policy: types.RestartAlways, MaxRetries: 1},
+	}}, services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]types.RestartPolicy{
+		"web":   {Policy: types.RestartOnFailure, MaxRetries: 3},
+		"db":    {Policy: types.RestartAlways, MaxRetries: 1},
+		"cache": {Policy: types.RestartAlways},
+	}
+	if !reflect.DeepEqual(policies, expected) {
+		t.Error("Expected ", expected, " got ", policies)
+	}
+
+	for _, metadata := range []map[string]types.RestartPolicy{
+		{"queue": {Policy: types.RestartNever}},
+		{"web": {Policy: "sometimes"}},
+		{"web": {Policy: types.RestartAlways, MaxRetries: -1}},
+	} {
+		if _, err = restartPolicies(types.Metadata{Restart: metadata}, services); err == nil {
+			t.Error("Expected invalid restart policy ", metadata, " to be rejected")
+		}
+	}
+	services["web"].Labels[RestartMaxRetriesLabel] = "many"
+	if _, err = restartPolicies(types.Metadata{}, services); err == nil {
+		t.Error("Expected invalid max_retries label to be rejected")
+	}
+}
+
+func TestDependentRestart(t *testing.T) {
+	m, restore := newFakeMonitor()
+	defer restore()
+	p := monitoredDocker(nil)
+	graph, err := newDepGraph(map[string]*composecfg.ServiceConfig{
+		"db":     {},
+		"api":    {DependsOn: []string{"db"}},
+		"web":    {Links: []string{"api:backend"}},
+		"worker": {DependsOn: []string{"db"}},
+		"proxy":  {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dependents := graph.dependents("db"); !reflect.DeepEqual(dependents, []string{"api", "web", "worker"}) {
+		t.Error("Expected the dependents of db in start order, got ", dependents)
+	}
+	p.Lock.Lock()
+	p.Apps["app"].Graph = graph
+	p.Apps["app"].Restarts.get("worker", nil).crashLooping = true
+	p.Lock.Unlock()
+
+	// Dependents restart after the recovered service, crash-looping ones are
+	// left alone
+	m.stream <- events.ContainerEvent{Service: "db", Event: "health_status: unhealthy"}
+	for _, service := range []string{"db", "api", "web"} {
+		expectService(t, m.restarts, service)
+		m.release <- true
+	}
+	expectNoCall(t, m.restarts)
+	p.listener.unwatch("app")
+
+	if _, err = newDepGraph(map[string]*composecfg.ServiceConfig{
+		"a": {DependsOn: []string{"b"}},
+		"b": {Links: []string{"a"}},
+	}); err == nil {
+		t.Error("Expected circular dependencies to be rejected")
+	}
+}
+
+func TestProbes(t *testing.T) {
+	m, restore := newFakeMonitor()
+	defer restore()
+	savedContainer, savedProbe, savedSecond := serviceContainer, runProbe, probeSecond
+	defer func() {
+		serviceContainer, runProbe, probeSecond = savedContainer, savedProbe, savedSecond
+	}()
+	probeSecond = time.Millisecond
+	failing := make(chan bool, 1)
+	failing <- false
+	serviceContainer = func(prj project.APIProject, service string) (string, error) {
+		return service, nil
+	}
+	runProbe = func(containerID string, probe types.Probe, timeout time.Duration) error {
+		fail := <-failing
+		failing <- fail
+		if fail && containerID == "web" {
+			return errors.New("probe failed")
+		}
+		return nil
+	}
+	setFailing := func(fail bool) {
+		<-failing
+		failing <- fail
+	}
+
+	p := NewDocker(config.Config{})
+	probe := &types.Probe{TCPSocket: &types.TCPSocketAction{Port: 80}, FailureThreshold: 2}
+	p.Apps["app"] = &ComposeApp{
+		Info:    types.App{UUID: "app", Probes: map[string]types.ServiceProbes{"web": {Liveness: probe, Readiness: probe}, "db": {Liveness: probe}}},
+		Monitor: true,
+		Active:  true,
+	}
+	p.IsHealthyMap["app"] = map[string]bool{"web": true, "db": true}
+	p.Lock.Lock()
+	p.monitor("app")
+	p.Lock.Unlock()
+	defer p.listener.unwatch("app")
+	health := func() (bool, bool) {
+		p.Lock.Lock()
+		defer p.Lock.Unlock()
+		return p.IsHealthyMap["app"]["web"], p.Apps["app"].Restarts.statuses(map[string]types.RestartPolicy{"web": {}})["web"].Ready
+	}
+	waitHealth := func(healthy bool, ready bool) {
+		deadline := time.Now().Add(time.Second)
+		for h, r := health(); h != healthy || r != ready; h, r = health() {
+			if time.Now().After(deadline) {
+				t.Fatal("Expected web healthy ", healthy, " and ready ", ready, ", got ", h, r)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// A failing liveness probe restarts the service, a failing readiness
+	// probe only marks it not ready
+	expectNoCall(t, m.restarts)
+	setFailing(true)
+	expectService(t, m.restarts, "web")
+	waitHealth(false, false)
+	p.Lock.Lock()
+	if !p.IsHealthyMap["app"]["db"] {
+		t.Error("Expected db to stay healthy")
+	}
+	p.Lock.Unlock()
+	setFailing(false)
+	m.release <- true
+	waitHealth(true, true)
+}
+
+func TestProbeAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" || r.Header.Get("X-Probe") != "yes" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	host, portString, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	port, _ := strconv.Atoi(portString)
+
+	httpProbe := types.Probe{HTTPGet: &types.HTTPGetAction{Path: "healthz", Port: port, HTTPHeaders: []types.HTTPHeader{{Name: "X-Probe", Value: "yes"}}}}
+	if err := probeAddress(context.Background(), host, httpProbe); err != nil {
+		t.Error("Expected HTTP probe to pass, got ", err)
+	}
+	httpProbe.HTTPGet.Path = "/"
+	if err := probeAddress(context.Background(), host, httpProbe); err == nil {
+		t.Error("Expected HTTP probe to fail on 503")
+	}
+	if err := probeAddress(context.Background(), host, types.Probe{TCPSocket: &types.TCPSocketAction{Port: port}}); err != nil {
+		t.Error("Expected TCP probe to pass, got ", err)
+	}
+	server.Close()
+	if err := probeAddress(context.Background(), host, types.Probe{TCPSocket: &types.TCPSocketAction{Port: port}}); err == nil {
+		t.Error("Expected TCP probe of a closed port to fail")
+	}
+
+	services := map[string]*composecfg.ServiceConfig{"web": {}}
+	for _, probes := range []map[string]types.ServiceProbes{
+		{"db": {Liveness: &httpProbe}},
+		{"web": {Readiness: &types.Probe{}}},
+		{"web": {Liveness: &types.Probe{Exec: &types.ExecAction{Command: []string{"true"}}, PeriodSeconds: -1}}},
+	} {
+		if _, err := probePolicies(types.Metadata{Probes: probes}, "", services); err == nil {
+			t.Error("Expected invalid probes ", probes, " to be rejected")
+		}
+	}
+
+	// The probes of a pod spec apply unless the metadata gives the service
+	// its own
+	dir, err := ioutil.TempDir("", "cappsd-probes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "pod.yaml"), []byte(`spec:
+  containers:
+    - name: web
+      image: web:1.0
+      livenessProbe:
+        exec:
+          command: ["true"]
+    - name: db
+      image: db:1.0
+      readinessProbe:
+        tcpSocket:
+          port: 5432
+`), 0644)
+	services["db"] = &composecfg.ServiceConfig{}
+	policies, err := probePolicies(types.Metadata{Probes: map[string]types.ServiceProbes{"web": {Readiness: &httpProbe}}}, dir, services)
+	if err != nil || len(policies) != 2 || policies["web"].Liveness != nil || policies["web"].Readiness != &httpProbe ||
+		policies["db"].Readiness == nil || policies["db"].Readiness.TCPSocket.Port != 5432 {
+		t.Error("Expected the pod probes under those of the metadata, got ", policies, err)
+	}
+}
+
+func TestDockerHealth(t *testing.T) {
+	m, restore := newFakeMonitor()
+	defer restore()
+	savedContainer := serviceContainer
+	defer func() {
+		serviceContainer = savedContainer
+	}()
+	serviceContainer = func(prj project.APIProject, service string) (string, error) {
+		if service == "db" {
+			return "", errors.New("No running container")
+		}
+		return service, nil
+	}
+	p := monitoredDocker(map[string]types.RestartPolicy{"web": {}, "db": {Policy: types.RestartNever}})
+	defer p.listener.unwatch("app")
+
+	health, err := p.Health("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if health.Status != types.HealthDegraded || !health.Services["web"].Running || health.Services["db"].Running {
+		t.Error("Expected only web to run, got ", health)
+	}
+
+	m.stream <- events.ContainerEvent{Service: "web", Event: "health_status: unhealthy"}
+	expectService(t, m.restarts, "web")
+	health, _ = p.Health("app")
+	if web := health.Services["web"]; health.Status != types.HealthUnhealthy || web.Healthy || web.Failures != 1 || web.LastCheck == nil {
+		t.Error("Expected web to be unhealthy, got ", health)
+	}
+	m.release <- true
+
+	p.Lock.Lock()
+	p.Apps["app"].Active = false
+	p.Lock.Unlock()
+	if health, _ = p.Health("app"); health.Status != types.HealthStopped {
+		t.Error("Expected stopped app, got ", health)
+	}
+	if _, err = p.Health("unknown"); err == nil {
+		t.Error("Expected unknown app to fail")
+	}
+}
+
+func TestWarnIgnoredProbes(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := logging.New(&out, logging.WarnLevel, "logfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnIgnoredProbes(logger, nil, "the app is not monitored")
+	if out.Len() != 0 {
+		t.Error("Expected no warning without probes, got ", out.String())
+	}
+	probe := &types.Probe{TCPSocket: &types.TCPSocketAction{Port: 80}}
+	warnIgnoredProbes(logger, map[string]types.ServiceProbes{"web": {Liveness: probe}, "db": {Readiness: probe}}, "the app is not monitored")
+	if line := out.String(); !strings.Contains(line, "the app is not monitored") || !strings.Contains(line, "services=db,web") {
+		t.Error("Expected the services with ignored probes to be logged, got ", line)
+	}
+}
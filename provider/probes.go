@@ -0,0 +1,210 @@
+package provider
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	composecfg "github.com/docker/libcompose/config"
+	"github.com/docker/libcompose/project"
+	"golang.org/x/net/context"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/utils"
+)
+
+// Defaults of the probe timings, as in Kubernetes
+const (
+	defaultProbePeriod    = 10
+	defaultProbeTimeout   = 1
+	defaultProbeThreshold = 3
+)
+
+// probeSecond is the unit of the probe timings, tests shorten it
+var probeSecond = time.Second
+
+// The docker calls of the probes, tests replace them
+var (
+	serviceContainer = func(prj project.APIProject, service string) (string, error) {
+		ids, err := prj.Containers(context.Background(), project.Filter{State: project.Running}, service)
+		if err != nil {
+			return "", err
+		}
+		if len(ids) == 0 {
+			return "", errors.New("No running container")
+		}
+		return ids[0], nil
+	}
+	runProbe = probeContainer
+)
+
+// probePolicies validates the probes of the metadata against the services
+// of the compose project.  The probes of the pod spec of the package at
+// path are added for services the metadata gives none.
+func probePolicies(metadata types.Metadata, path string, services map[string]*composecfg.ServiceConfig) (map[string]types.ServiceProbes, error) {
+	policies, err := utils.LoadPodProbes(path)
+	if err != nil {
+		return nil, err
+	}
+	for name, probes := range metadata.Probes {
+		policies[name] = probes
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, exists := services[name]; !exists {
+			return nil, errors.New("Probes for unknown service " + name)
+		}
+		probes := policies[name]
+		for kind, probe := range map[string]*types.Probe{"livenessProbe": probes.Liveness, "readinessProbe": probes.Readiness} {
+			if probe == nil {
+				continue
+			}
+			if err := utils.ValidateProbe(probe); err != nil {
+				return nil, errors.New("Invalid " + kind + " of service " + name + ": " + err.Error())
+			}
+		}
+	}
+	return policies, nil
+}
+
+// warnIgnoredProbes logs that the probes of an app will not run, because the
+// app is not monitored or the provider cannot run probes
+func warnIgnoredProbes(logger *logging.Logger, probes map[string]types.ServiceProbes, reason string) {
+	if len(probes) == 0 {
+		return
+	}
+	services := make([]string, 0, len(probes))
+	for name := range probes {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	logger.Warn("Probes will not run, "+reason, "services", strings.Join(services, ","))
+}
+
+// probeTimings resolves the defaults of a probe
+func probeTimings(probe types.Probe) (period time.Duration, timeout time.Duration, threshold int) {
+	period, timeout, threshold = defaultProbePeriod*probeSecond, defaultProbeTimeout*probeSecond, defaultProbeThreshold
+	if probe.PeriodSeconds > 0 {
+		period = time.Duration(probe.PeriodSeconds) * probeSecond
+	}
+	if probe.TimeoutSeconds > 0 {
+		timeout = time.Duration(probe.TimeoutSeconds) * probeSecond
+	}
+	if probe.FailureThreshold > 0 {
+		threshold = probe.FailureThreshold
+	}
+	return period, timeout, threshold
+}
+
+// probeContainer runs a probe against a container.  HTTP and TCP probes
+// connect to the container's address, exec probes run inside it and pass
+// when the command exits with 0.
+func probeContainer(containerID string, probe types.Probe, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cli, err := dockerClient()
+	if err != nil {
+		return err
+	}
+	if probe.Exec != nil {
+		return execProbe(ctx, cli, containerID, probe.Exec.Command)
+	}
+
+	container, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	// Containers without an address of their own use the host network
+	host := "127.0.0.1"
+	if settings := container.NetworkSettings; settings != nil {
+		if settings.IPAddress != "" {
+			host = settings.IPAddress
+		} else {
+			for _, network := range settings.Networks {
+				if network.IPAddress != "" {
+					host = network.IPAddress
+					break
+				}
+			}
+		}
+	}
+	return probeAddress(ctx, host, probe)
+}
+
+// probeAddress runs an HTTP or TCP probe against host.  HTTP probes pass on
+// a status from 200 to 399.
+func probeAddress(ctx context.Context, host string, probe types.Probe) error {
+	switch {
+	case probe.HTTPGet != nil:
+		path := probe.HTTPGet.Path
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		request, err := http.NewRequest("GET", "http://"+net.JoinHostPort(host, strconv.Itoa(probe.HTTPGet.Port))+path, nil)
+		if err != nil {
+			return err
+		}
+		for _, header := range probe.HTTPGet.HTTPHeaders {
+			request.Header.Set(header.Name, header.Value)
+		}
+		response, err := http.DefaultClient.Do(request.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		if response.StatusCode < 200 || response.StatusCode >= 400 {
+			return errors.New("HTTP probe returned " + response.Status)
+		}
+		return nil
+	case probe.TCPSocket != nil:
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(probe.TCPSocket.Port)))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	return errors.New("Probe without a handler")
+}
+
+// execProbe runs a command in a container and waits for it to exit
+func execProbe(ctx context.Context, cli *client.Client, containerID string, command []string) error {
+	exec, err := cli.ContainerExecCreate(ctx, containerID, dockertypes.ExecConfig{Cmd: command, Detach: true})
+	if err != nil {
+		return err
+	}
+	if err = cli.ContainerExecStart(ctx, exec.ID, dockertypes.ExecStartCheck{Detach: true}); err != nil {
+		return err
+	}
+	for {
+		inspect, err := cli.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			return err
+		}
+		if !inspect.Running {
+			if inspect.ExitCode != 0 {
+				return errors.New("Exec probe exited with " + strconv.Itoa(inspect.ExitCode))
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.New("Exec probe timed out")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+)
+
+// DefaultProvider is used when ecs.json does not name a provider
+const DefaultProvider = "docker"
+
+// Factory creates an uninitialized provider from the service configuration
+type Factory func(c config.Config) Provider
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]Factory)
+)
+
+// Register makes a provider available under name so it can be selected with
+// the provider key in ecs.json.  Providers register themselves from init, so
+// registering the same name twice is a programming error.
+func Register(name string, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if factory == nil {
+		panic("provider: Register factory is nil for " + name)
+	}
+	if _, exists := registry[name]; exists {
+		panic("provider: Register called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// Providers returns the sorted names of all registered providers
+func Providers() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProvider creates and initializes the provider selected by the
+// configuration
+func NewProvider(c config.Config) (Provider, error) {
+	name := c.Provider
+	if name == "" {
+		name = DefaultProvider
+	}
+
+	registryLock.RLock()
+	factory, exists := registry[name]
+	registryLock.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("Unknown provider %q (available: %s)", name, strings.Join(Providers(), ", "))
+	}
+
+	p := factory(c)
+	if err := p.Init(); err != nil {
+		return nil, fmt.Errorf("Failed to initialize %s provider: %v", name, err)
+	}
+	return p, nil
+}
@@ -0,0 +1,64 @@
+package provider
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+)
+
+type failingProvider struct {
+	Docker
+}
+
+func (f *failingProvider) Init() error {
+	return errors.New("no runtime")
+}
+
+func TestNewProviderDefault(t *testing.T) {
+	cfg, err := config.NewConfig(filePath)
+	if err != nil {
+		t.Fatal("Config File Creation Error")
+	}
+	cfg.Provider = ""
+	p, err := NewProvider(cfg)
+	if err != nil {
+		t.Fatal("Expected default provider, got ", err)
+	}
+	if _, ok := p.(*Docker); !ok {
+		t.Errorf("Expected docker to be the default provider, got %T", p)
+	}
+}
+
+func TestNewProviderUnknown(t *testing.T) {
+	var cfg config.Config
+	cfg.Provider = "bogus"
+	p, err := NewProvider(cfg)
+	if p != nil || err == nil || !strings.Contains(err.Error(), "bogus") {
+		t.Error("Expected an error for an unknown provider, got ", p, err)
+	}
+}
+
+func TestNewProviderInitFailure(t *testing.T) {
+	Register("failing", func(c config.Config) Provider {
+		return &failingProvider{}
+	})
+	var cfg config.Config
+	cfg.Provider = "failing"
+	p, err := NewProvider(cfg)
+	if p != nil || err == nil || !strings.Contains(err.Error(), "no runtime") {
+		t.Error("Expected the Init error to be surfaced, got ", p, err)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering docker twice to panic")
+		}
+	}()
+	Register("docker", func(c config.Config) Provider {
+		return NewDocker(c)
+	})
+}
@@ -0,0 +1,222 @@
+package provider
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	composecfg "github.com/docker/libcompose/config"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/utils"
+)
+
+// Compose labels declaring the restart policy of a service, the metadata of
+// the deploy takes precedence
+const (
+	RestartPolicyLabel     = "cappsd.restart.policy"
+	RestartMaxRetriesLabel = "cappsd.restart.max_retries"
+)
+
+// restartResetAfter is how long a restarted service must stay up before its
+// next failure starts a new series of retries
+var restartResetAfter = 5 * time.Minute
+
+// newRestartBackoff paces the restarts of a failing service
+var newRestartBackoff = func() utils.Backoff {
+	return utils.NewSimpleBackoff(time.Second, time.Minute, 0.2, 2)
+}
+
+// restartPolicies resolves the restart policy of every service from the
+// metadata and the compose labels.  Services declaring neither are always
+// restarted.
+func restartPolicies(metadata types.Metadata, services map[string]*composecfg.ServiceConfig) (map[string]types.RestartPolicy, error) {
+	names := make([]string, 0, len(metadata.Restart))
+	for name := range metadata.Restart {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, exists := services[name]; !exists {
+			return nil, errors.New("Restart policy for unknown service " + name)
+		}
+	}
+
+	policies := make(map[string]types.RestartPolicy)
+	for name, service := range services {
+		policy, declared := metadata.Restart[name]
+		if !declared {
+			policy = types.RestartPolicy{Policy: types.RestartAlways}
+			if label, exists := service.Labels[RestartPolicyLabel]; exists {
+				policy.Policy = label
+			}
+			if label, exists := service.Labels[RestartMaxRetriesLabel]; exists {
+				retries, err := strconv.Atoi(label)
+				if err != nil {
+					return nil, errors.New("Invalid " + RestartMaxRetriesLabel + " of service " + name + ": " + label)
+				}
+				policy.MaxRetries = retries
+			}
+		}
+		switch policy.Policy {
+		case types.RestartAlways, types.RestartOnFailure, types.RestartNever:
+		default:
+			return nil, errors.New("Invalid restart policy of service " + name + ": " + policy.Policy)
+		}
+		if policy.MaxRetries < 0 {
+			return nil, errors.New("Invalid max_retries of service " + name + ": " + strconv.Itoa(policy.MaxRetries))
+		}
+		policies[name] = policy
+	}
+	return policies, nil
+}
+
+// restartState counts the restarts of a monitored service
+type restartState struct {
+	policy       types.RestartPolicy
+	restarts     int
+	last         time.Time
+	backoff      utils.Backoff
+	waiting      bool
+	crashLooping bool
+	unready      bool
+	lastCheck    time.Time
+	failures     int
+}
+
+func newRestartState(policy types.RestartPolicy) *restartState {
+	if policy.Policy == "" {
+		policy.Policy = types.RestartAlways
+	}
+	return &restartState{policy: policy, backoff: newRestartBackoff()}
+}
+
+// attempt decides if a service that stopped or turned unhealthy is
+// restarted, and how long to wait before.  failed tells a crash or failed
+// health check from a clean exit.  Once max_retries restarts in a row did
+// not last the service is crash-looping and left alone.
+func (s *restartState) attempt(failed bool, now time.Time) (bool, time.Duration) {
+	if s.policy.Policy == types.RestartNever || (s.policy.Policy == types.RestartOnFailure && !failed) {
+		return false, 0
+	}
+	if !s.last.IsZero() && now.Sub(s.last) > restartResetAfter {
+		s.reset()
+	}
+	if s.crashLooping || (s.policy.MaxRetries > 0 && s.restarts >= s.policy.MaxRetries) {
+		s.crashLooping = true
+		return false, 0
+	}
+	s.restarts++
+	s.last = now
+	return true, s.backoff.Duration()
+}
+
+// reset starts a new series of retries once the service is healthy again
+func (s *restartState) reset() {
+	s.restarts = 0
+	s.last = time.Time{}
+	s.backoff.Reset()
+	s.crashLooping = false
+}
+
+// checked records the result of a probe or docker health event
+func (s *restartState) checked(passed bool, now time.Time) {
+	s.lastCheck = now
+	if passed {
+		s.failures = 0
+	} else {
+		s.failures++
+	}
+}
+
+// status ...
+func (s *restartState) status() types.ServiceStatus {
+	state := types.ServiceOk
+	if s.crashLooping {
+		state = types.ServiceCrashLooping
+	} else if s.waiting {
+		state = types.ServiceRestarting
+	}
+	return types.ServiceStatus{
+		Policy:     s.policy.Policy,
+		MaxRetries: s.policy.MaxRetries,
+		Restarts:   s.restarts,
+		State:      state,
+		Ready:      !s.unready,
+	}
+}
+
+// health reports the service with its running and healthy state
+func (s *restartState) health(running bool, healthy bool) types.ServiceHealth {
+	status := s.status()
+	health := types.ServiceHealth{
+		Running:  running,
+		Healthy:  healthy,
+		Ready:    status.Ready,
+		State:    status.State,
+		Failures: s.failures,
+		Restarts: s.restarts,
+	}
+	if !s.lastCheck.IsZero() {
+		last := s.lastCheck
+		health.LastCheck = &last
+	}
+	return health
+}
+
+// restartStates keeps the restart state of the services of an app
+type restartStates map[string]*restartState
+
+// get returns the state of a service, starting it from its policy
+func (r restartStates) get(service string, policies map[string]types.RestartPolicy) *restartState {
+	state, exists := r[service]
+	if !exists {
+		state = newRestartState(policies[service])
+		r[service] = state
+	}
+	return state
+}
+
+// lookup returns the state of a service without recording it
+func (r restartStates) lookup(service string, policies map[string]types.RestartPolicy) *restartState {
+	if state, exists := r[service]; exists {
+		return state
+	}
+	return newRestartState(policies[service])
+}
+
+// statuses reports every service with a policy
+func (r restartStates) statuses(policies map[string]types.RestartPolicy) map[string]types.ServiceStatus {
+	statuses := make(map[string]types.ServiceStatus)
+	for name := range policies {
+		statuses[name] = r.lookup(name, policies).status()
+	}
+	return statuses
+}
+
+// aggregateHealth sums up the health of the services of an app.  An app is
+// Running when every service runs and passes its checks, Degraded when some
+// do, Unhealthy when none does or a service is crash-looping and Stopped when
+// no service runs.
+func aggregateHealth(services map[string]types.ServiceHealth) string {
+	running, ok, crashLooping := 0, 0, false
+	for _, service := range services {
+		if service.Running {
+			running++
+		}
+		if service.Running && service.Healthy && service.Ready && service.State == types.ServiceOk {
+			ok++
+		}
+		crashLooping = crashLooping || service.State == types.ServiceCrashLooping
+	}
+	switch {
+	case running == 0:
+		return types.HealthStopped
+	case crashLooping || ok == 0:
+		return types.HealthUnhealthy
+	case ok == len(services):
+		return types.HealthRunning
+	}
+	return types.HealthDegraded
+}
@@ -0,0 +1,152 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/libcompose/project"
+	"golang.org/x/net/context"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// containerStats returns a single sample of the docker stats of a container,
+// tests replace it
+var containerStats = func(ctx context.Context, containerID string) (io.ReadCloser, error) {
+	cli, err := dockerClient()
+	if err != nil {
+		return nil, err
+	}
+	stats, err := cli.ContainerStats(ctx, containerID, false)
+	if err != nil {
+		return nil, err
+	}
+	return stats.Body, nil
+}
+
+// resourceUsage computes the usage of a container from its docker stats the
+// way docker stats does
+func resourceUsage(stats dockertypes.StatsJSON) types.ResourceUsage {
+	var usage types.ResourceUsage
+
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		usage.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	// The page cache can be reclaimed, so it does not count as used
+	usage.MemoryUsage = stats.MemoryStats.Usage
+	if cache := stats.MemoryStats.Stats["cache"]; cache < usage.MemoryUsage {
+		usage.MemoryUsage -= cache
+	}
+	usage.MemoryLimit = stats.MemoryStats.Limit
+
+	for _, network := range stats.Networks {
+		usage.NetworkRx += network.RxBytes
+		usage.NetworkTx += network.TxBytes
+	}
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			usage.BlockRead += entry.Value
+		case "write":
+			usage.BlockWrite += entry.Value
+		}
+	}
+	usage.MemoryPercent = memoryPercent(usage)
+	return usage
+}
+
+func memoryPercent(usage types.ResourceUsage) float64 {
+	if usage.MemoryLimit == 0 {
+		return 0
+	}
+	return float64(usage.MemoryUsage) / float64(usage.MemoryLimit) * 100
+}
+
+// totalUsage sums the usage of the containers of an app
+func totalUsage(containers []types.ContainerStats) types.ResourceUsage {
+	var total types.ResourceUsage
+	for _, container := range containers {
+		total.CPUPercent += container.CPUPercent
+		total.MemoryUsage += container.MemoryUsage
+		total.MemoryLimit += container.MemoryLimit
+		total.NetworkRx += container.NetworkRx
+		total.NetworkTx += container.NetworkTx
+		total.BlockRead += container.BlockRead
+		total.BlockWrite += container.BlockWrite
+	}
+	total.MemoryPercent = memoryPercent(total)
+	return total
+}
+
+// Stats samples the resource usage of the running containers of an app.
+// Containers are sampled in parallel, as docker takes a second for each.
+func (p *Docker) Stats(ctx context.Context, id string) (*types.AppStats, error) {
+	p.Lock.RLock()
+	app, exists := p.Apps[id]
+	var prj project.APIProject
+	var policies map[string]types.RestartPolicy
+	if exists {
+		prj, policies = app.Client, app.Info.Restart
+	}
+	p.Lock.RUnlock()
+	if !exists {
+		return nil, errors.New(types.InvalidID)
+	}
+	services, _ := logServices("", policies)
+
+	stats := &types.AppStats{UUID: id, Time: time.Now().UTC(), Containers: []types.ContainerStats{}}
+	for _, service := range services {
+		ids, err := serviceContainers(ctx, prj, service, project.Running)
+		if err != nil {
+			return nil, err
+		}
+		for i, containerID := range ids {
+			stats.Containers = append(stats.Containers, types.ContainerStats{
+				ID:      containerID,
+				Name:    service + "_" + strconv.Itoa(i+1),
+				Service: service,
+			})
+		}
+	}
+
+	var wait sync.WaitGroup
+	errs := make(chan error, len(stats.Containers))
+	for i := range stats.Containers {
+		wait.Add(1)
+		go func(container *types.ContainerStats) {
+			defer wait.Done()
+			body, err := containerStats(ctx, container.ID)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer body.Close()
+			var sample dockertypes.StatsJSON
+			if err = json.NewDecoder(body).Decode(&sample); err != nil {
+				errs <- err
+				return
+			}
+			container.ResourceUsage = resourceUsage(sample)
+		}(&stats.Containers[i])
+	}
+	wait.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	stats.Total = totalUsage(stats.Containers)
+	return stats, nil
+}
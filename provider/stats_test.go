@@ -0,0 +1,100 @@
+package provider
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/libcompose/project"
+	"golang.org/x/net/context"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+func TestResourceUsage(t *testing.T) {
+	var stats dockertypes.StatsJSON
+	stats.PreCPUStats.CPUUsage.TotalUsage = 1000
+	stats.PreCPUStats.SystemUsage = 10000
+	stats.CPUStats.CPUUsage.TotalUsage = 1500
+	stats.CPUStats.CPUUsage.PercpuUsage = []uint64{1000, 500}
+	stats.CPUStats.SystemUsage = 12000
+	stats.MemoryStats.Usage = 300
+	stats.MemoryStats.Stats = map[string]uint64{"cache": 100}
+	stats.MemoryStats.Limit = 800
+	stats.Networks = map[string]dockertypes.NetworkStats{
+		"eth0": {RxBytes: 10, TxBytes: 20},
+		"eth1": {RxBytes: 1, TxBytes: 2},
+	}
+	stats.BlkioStats.IoServiceBytesRecursive = []dockertypes.BlkioStatEntry{
+		{Op: "Read", Value: 4096},
+		{Op: "Write", Value: 512},
+		{Op: "Total", Value: 4608},
+	}
+	expected := types.ResourceUsage{
+		CPUPercent:    50,
+		MemoryUsage:   200,
+		MemoryLimit:   800,
+		MemoryPercent: 25,
+		NetworkRx:     11,
+		NetworkTx:     22,
+		BlockRead:     4096,
+		BlockWrite:    512,
+	}
+	if usage := resourceUsage(stats); usage != expected {
+		t.Error("Expected ", expected, " got ", usage)
+	}
+
+	// The first sample of a container has no previous one
+	stats.PreCPUStats = dockertypes.CPUStats{}
+	stats.CPUStats.OnlineCPUs = 4
+	if usage := resourceUsage(stats); usage.CPUPercent != 50 {
+		t.Error("Expected the usage since start on 4 CPUs, got ", usage.CPUPercent)
+	}
+}
+
+func TestDockerStats(t *testing.T) {
+	savedContainers, savedStats := serviceContainers, containerStats
+	defer func() {
+		serviceContainers, containerStats = savedContainers, savedStats
+	}()
+	serviceContainers = func(ctx context.Context, prj project.APIProject, service string, state project.State) ([]string, error) {
+		if state != project.Running {
+			t.Error("Expected running containers, got ", state)
+		}
+		if service == "db" {
+			return nil, nil
+		}
+		return []string{service + "-a", service + "-b"}, nil
+	}
+	containerStats = func(ctx context.Context, containerID string) (io.ReadCloser, error) {
+		if containerID == "queue-a" {
+			return nil, errors.New("No such container")
+		}
+		return ioutil.NopCloser(strings.NewReader(`{"memory_stats":{"usage":100,"limit":400},"networks":{"eth0":{"rx_bytes":5}}}`)), nil
+	}
+	p := &Docker{Apps: map[string]*ComposeApp{
+		"app": {Info: types.App{UUID: "app", Restart: map[string]types.RestartPolicy{"web": {}, "db": {}}}},
+	}}
+
+	stats, err := p.Stats(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Containers) != 2 || stats.Containers[1].Name != "web_2" || stats.Containers[1].ID != "web-b" || stats.Containers[0].MemoryPercent != 25 {
+		t.Error("Expected both web containers, got ", stats.Containers)
+	}
+	if stats.Total.MemoryUsage != 200 || stats.Total.MemoryLimit != 800 || stats.Total.NetworkRx != 10 {
+		t.Error("Expected the total of both containers, got ", stats.Total)
+	}
+
+	p.Apps["app"].Info.Restart["queue"] = types.RestartPolicy{}
+	if _, err = p.Stats(context.Background(), "app"); err == nil {
+		t.Error("Expected a failed sample to fail")
+	}
+	if _, err = p.Stats(context.Background(), "unknown"); err == nil || err.Error() != types.InvalidID {
+		t.Error("Expected unknown app to fail, got ", err)
+	}
+}
@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// DefaultUpgradeGracePeriod is how many seconds an upgraded application must
+// stay up and healthy before the previous version is discarded, used when
+// upgrade_grace_period is not set in ecs.json
+const DefaultUpgradeGracePeriod = 30
+
+// upgradePollInterval is how often services are checked during the grace
+// period
+var upgradePollInterval = time.Second
+
+var upState = regexp.MustCompile("^Up")
+
+// upgradeGracePeriod ...
+func upgradeGracePeriod(c config.Config) time.Duration {
+	if c.UpgradeGracePeriod > 0 {
+		return time.Duration(c.UpgradeGracePeriod) * time.Second
+	}
+	return DefaultUpgradeGracePeriod * time.Second
+}
+
+// upgradePaths returns the directory a new package is unpacked into and the
+// directory the running version is moved to while the upgrade is watched
+func upgradePaths(c config.Config, id string) (string, string) {
+	return c.DataVolume + "/" + id + ".upgrade", c.DataVolume + "/" + id + ".rollback"
+}
+
+// swapIn moves the unpacked application at path aside to backup and puts the
+// staged package in its place, so the application keeps its path and UUID
+func swapIn(path string, staging string, backup string) error {
+	os.RemoveAll(backup)
+	if err := os.Rename(path, backup); err != nil {
+		return err
+	}
+	if err := os.Rename(staging, path); err != nil {
+		os.Rename(backup, path)
+		return err
+	}
+	return nil
+}
+
+// swapBack moves the rejected package at path back to staging and restores
+// the previous one from backup
+func swapBack(path string, backup string, staging string) error {
+	os.RemoveAll(staging)
+	if err := os.Rename(path, staging); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(backup, path)
+}
+
+// recoverUpgrade restores the previous package of an application whose
+// upgrade was interrupted, application.json still describes that version
+func recoverUpgrade(logger *logging.Logger, c config.Config, id string, path string) {
+	staging, backup := upgradePaths(c, id)
+	if _, err := os.Stat(backup); err == nil {
+		logger.Info("Restoring the app after an interrupted upgrade")
+		if err = swapBack(path, backup, staging); err != nil {
+			logger.Error("Failed to restore the app", "error", err)
+		}
+	}
+	os.RemoveAll(staging)
+}
+
+// watchUpgrade calls check until the grace period is over, returning the
+// first error it reports
+func watchUpgrade(grace time.Duration, check func() error) error {
+	deadline := time.Now().Add(grace)
+	for {
+		if err := check(); err != nil {
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			return nil
+		}
+		time.Sleep(upgradePollInterval)
+	}
+}
+
+// checkContainers fails unless every container is up and none reports an
+// unhealthy health check
+func checkContainers(containers []types.Container) error {
+	if len(containers) == 0 {
+		return errors.New("No containers running")
+	}
+	for _, container := range containers {
+		if !upState.MatchString(container.State) {
+			return errors.New("Container " + container.Name + " is not running: " + container.State)
+		}
+		if strings.Contains(container.State, "(unhealthy)") {
+			return errors.New("Container " + container.Name + " is unhealthy")
+		}
+	}
+	return nil
+}
+
+// upgradeFailed describes a failed upgrade after the previous version was
+// restored, or failed to be
+func upgradeFailed(version string, err error, rollbackErr error) error {
+	if rollbackErr != nil {
+		return errors.New("Upgrade failed: " + err.Error() + ", rollback failed: " + rollbackErr.Error())
+	}
+	return errors.New("Upgrade failed, rolled back to version " + version + ": " + err.Error())
+}
@@ -0,0 +1,114 @@
+package types
+
+// Pod is the Kubernetes style application description that may be shipped in
+// a package as pod.json or pod.yaml instead of a docker-compose.yml
+type Pod struct {
+	Kind       string      `json:"kind" yaml:"kind"`
+	APIVersion string      `json:"apiVersion" yaml:"apiVersion"`
+	Metadata   PodMetadata `json:"metadata" yaml:"metadata"`
+	Spec       PodSpec     `json:"spec" yaml:"spec"`
+}
+
+// PodMetadata ...
+type PodMetadata struct {
+	Name         string            `json:"name" yaml:"name"`
+	Labels       map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	GenerateName string            `json:"generateName,omitempty" yaml:"generateName,omitempty"`
+	Namespace    string            `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+}
+
+// PodSpec ...
+type PodSpec struct {
+	Containers    []PodContainer `json:"containers" yaml:"containers"`
+	RestartPolicy string         `json:"restartPolicy,omitempty" yaml:"restartPolicy,omitempty"`
+	Volumes       []PodVolume    `json:"volumes,omitempty" yaml:"volumes,omitempty"`
+}
+
+// PodContainer ...
+type PodContainer struct {
+	Name            string        `json:"name" yaml:"name"`
+	Image           string        `json:"image" yaml:"image"`
+	Command         []string      `json:"command,omitempty" yaml:"command,omitempty"`
+	Args            []string      `json:"args,omitempty" yaml:"args,omitempty"`
+	Env             []EnvVar      `json:"env,omitempty" yaml:"env,omitempty"`
+	ImagePullPolicy string        `json:"imagePullPolicy,omitempty" yaml:"imagePullPolicy,omitempty"`
+	WorkingDir      string        `json:"workingDir,omitempty" yaml:"workingDir,omitempty"`
+	DependsOn       []string      `json:"depends_on,omitempty" yaml:"depends_on,omitempty"`
+	ExternalLinks   []string      `json:"external_links,omitempty" yaml:"external_links,omitempty"`
+	Networks        []string      `json:"networks,omitempty" yaml:"networks,omitempty"`
+	Ports           []PodPort     `json:"ports,omitempty" yaml:"ports,omitempty"`
+	Resources       PodResources  `json:"resources,omitempty" yaml:"resources,omitempty"`
+	VolumeMounts    []VolumeMount `json:"volumeMounts,omitempty" yaml:"volumeMounts,omitempty"`
+	LivenessProbe   *Probe        `json:"livenessProbe,omitempty" yaml:"livenessProbe,omitempty"`
+	ReadinessProbe  *Probe        `json:"readinessProbe,omitempty" yaml:"readinessProbe,omitempty"`
+}
+
+// EnvVar ...
+type EnvVar struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value" yaml:"value"`
+}
+
+// PodPort ...
+type PodPort struct {
+	ContainerPort int    `json:"containerPort" yaml:"containerPort"`
+	HostPort      int    `json:"hostPort,omitempty" yaml:"hostPort,omitempty"`
+	Name          string `json:"name,omitempty" yaml:"name,omitempty"`
+	Protocol      string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+}
+
+// PodResources limits a container, cpu in cores ("0.5" or "500m") and
+// memory in bytes with an optional suffix ("128Mi", "1G")
+type PodResources struct {
+	CPU    string `json:"cpu,omitempty" yaml:"cpu,omitempty"`
+	Memory string `json:"memory,omitempty" yaml:"memory,omitempty"`
+}
+
+// VolumeMount ...
+type VolumeMount struct {
+	Name      string `json:"name" yaml:"name"`
+	MountPath string `json:"mountPath" yaml:"mountPath"`
+	ReadOnly  bool   `json:"readOnly,omitempty" yaml:"readOnly,omitempty"`
+}
+
+// PodVolume is a host directory, relative paths are relative to the unpacked
+// package.  Volumes without a hostPath are created by the runtime.
+type PodVolume struct {
+	Name     string `json:"name" yaml:"name"`
+	HostPath string `json:"hostPath,omitempty" yaml:"hostPath,omitempty"`
+}
+
+// Probe ...
+type Probe struct {
+	HTTPGet             *HTTPGetAction   `json:"httpGet,omitempty" yaml:"httpGet,omitempty"`
+	TCPSocket           *TCPSocketAction `json:"tcpSocket,omitempty" yaml:"tcpSocket,omitempty"`
+	Exec                *ExecAction      `json:"exec,omitempty" yaml:"exec,omitempty"`
+	InitialDelaySeconds int              `json:"initialDelaySeconds,omitempty" yaml:"initialDelaySeconds,omitempty"`
+	TimeoutSeconds      int              `json:"timeoutSeconds,omitempty" yaml:"timeoutSeconds,omitempty"`
+	PeriodSeconds       int              `json:"periodSeconds,omitempty" yaml:"periodSeconds,omitempty"`
+	FailureThreshold    int              `json:"failureThreshold,omitempty" yaml:"failureThreshold,omitempty"`
+}
+
+// HTTPGetAction ...
+type HTTPGetAction struct {
+	Path        string       `json:"path,omitempty" yaml:"path,omitempty"`
+	Port        int          `json:"port" yaml:"port"`
+	HTTPHeaders []HTTPHeader `json:"httpHeaders,omitempty" yaml:"httpHeaders,omitempty"`
+}
+
+// HTTPHeader ...
+type HTTPHeader struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value" yaml:"value"`
+}
+
+// TCPSocketAction ...
+type TCPSocketAction struct {
+	Port int `json:"port" yaml:"port"`
+}
+
+// ExecAction ...
+type ExecAction struct {
+	Command []string `json:"command" yaml:"command"`
+}
@@ -1,14 +1,18 @@
 package types
 
+import "time"
+
 //Constants ...
 const (
-	Ok          = "Ok"
-	Fail        = "Fail"
-	Deployed    = "Deployed"
-	Running     = "Running"
-	Stopped     = "Stopped"
-	InvalidID   = "Application ID not found"
-	InvalidName = "Application Name not found"
+	Ok             = "Ok"
+	Fail           = "Fail"
+	Deployed       = "Deployed"
+	Running        = "Running"
+	Stopped        = "Stopped"
+	InvalidID      = "Application ID not found"
+	InvalidName    = "Application Name not found"
+	InvalidVersion = "Application Version not found"
+	Upgrading      = "Application upgrade in progress"
 )
 
 //PersistentApps ...
@@ -18,10 +22,162 @@ type PersistentApps struct {
 
 //Metadata ...
 type Metadata struct {
-	Name       string `json:"name"`
-	Version    string `json:"version"`
-	Monitor    string `json:"monitor"`
-	DelayStart string `json:"delaystart"`
+	Name       string                   `json:"name"`
+	Version    string                   `json:"version"`
+	Monitor    string                   `json:"monitor"`
+	DelayStart string                   `json:"delaystart"`
+	Restart    map[string]RestartPolicy `json:"restart,omitempty"`
+	Probes     map[string]ServiceProbes `json:"probes,omitempty"`
+}
+
+//ServiceProbes are the probes cappsd runs against a monitored service.  A
+//failing liveness probe restarts the service as its restart policy allows, a
+//failing readiness probe only marks it unhealthy.
+type ServiceProbes struct {
+	Liveness  *Probe `json:"livenessProbe,omitempty"`
+	Readiness *Probe `json:"readinessProbe,omitempty"`
+}
+
+//Restart policies of a monitored service
+const (
+	RestartAlways    = "always"
+	RestartOnFailure = "on-failure"
+	RestartNever     = "never"
+)
+
+//RestartPolicy decides if a monitored service that fails is restarted,
+//MaxRetries of 0 retries forever
+type RestartPolicy struct {
+	Policy     string `json:"policy"`
+	MaxRetries int    `json:"max_retries"`
+}
+
+//States of a monitored service
+const (
+	ServiceOk           = "ok"
+	ServiceRestarting   = "restarting"
+	ServiceCrashLooping = "crash-looping"
+)
+
+//ServiceStatus reports the restarts of a monitored service and if its
+//readiness probe passes
+type ServiceStatus struct {
+	Policy     string `json:"policy"`
+	MaxRetries int    `json:"max_retries"`
+	Restarts   int    `json:"restarts"`
+	State      string `json:"state"`
+	Ready      bool   `json:"ready"`
+}
+
+//Aggregated health of an application
+const (
+	HealthRunning   = "Running"
+	HealthDegraded  = "Degraded"
+	HealthUnhealthy = "Unhealthy"
+	HealthStopped   = "Stopped"
+)
+
+//ServiceHealth reports the health of a service.  LastCheck is the time of
+//its last probe or docker health event and Failures counts the checks that
+//failed since the last one that passed.
+type ServiceHealth struct {
+	Running   bool       `json:"running"`
+	Healthy   bool       `json:"healthy"`
+	Ready     bool       `json:"ready"`
+	State     string     `json:"state"`
+	LastCheck *time.Time `json:"last_check,omitempty"`
+	Failures  int        `json:"failures"`
+	Restarts  int        `json:"restarts"`
+}
+
+//AppHealth reports the health of every service of an application and the
+//health of the application as a whole
+type AppHealth struct {
+	UUID     string                   `json:"uuid"`
+	Status   string                   `json:"status"`
+	Services map[string]ServiceHealth `json:"services"`
+}
+
+//LogOptions select the container logs to read.  Tail is the number of
+//lines to read from the end of each container's log, all of them when
+//negative, and a zero Since reads from the start.
+type LogOptions struct {
+	Service    string
+	Tail       int
+	Since      time.Time
+	Timestamps bool
+	Follow     bool
+}
+
+//ExecRequest is a command to run in a container of a service.  Stdin is
+//written to the command's standard input, TTY runs it on a terminal for an
+//interactive session.
+type ExecRequest struct {
+	Service string   `json:"service"`
+	Command []string `json:"command"`
+	Stdin   string   `json:"stdin,omitempty"`
+	TTY     bool     `json:"tty,omitempty"`
+}
+
+//ExecResult is the outcome of a command run in a container
+type ExecResult struct {
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+}
+
+//ResourceUsage is the resource usage of one or more containers.  CPUPercent
+//is relative to one CPU, so busy containers on a multi-core device exceed
+//100.
+type ResourceUsage struct {
+	CPUPercent    float64 `json:"cpu_percent"`
+	MemoryUsage   uint64  `json:"memory_usage"`
+	MemoryLimit   uint64  `json:"memory_limit"`
+	MemoryPercent float64 `json:"memory_percent"`
+	NetworkRx     uint64  `json:"network_rx"`
+	NetworkTx     uint64  `json:"network_tx"`
+	BlockRead     uint64  `json:"block_read"`
+	BlockWrite    uint64  `json:"block_write"`
+}
+
+//ContainerStats is the resource usage of a container of a service
+type ContainerStats struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Service string `json:"service"`
+	ResourceUsage
+}
+
+//AppStats is the resource usage of the running containers of an app and
+//their total
+type AppStats struct {
+	UUID       string           `json:"uuid"`
+	Time       time.Time        `json:"time"`
+	Containers []ContainerStats `json:"containers"`
+	Total      ResourceUsage    `json:"total"`
+}
+
+//Lifecycle events of applications and their services
+const (
+	EventDeployed     = "deployed"
+	EventDeployFailed = "deploy-failed"
+	EventStarted      = "started"
+	EventStopped      = "stopped"
+	EventUnhealthy    = "unhealthy"
+	EventRestarted    = "restarted"
+	EventPurged       = "purged"
+)
+
+//LifecycleEvent is a change of an application or one of its services.
+//Service is empty for events about the application as a whole.
+type LifecycleEvent struct {
+	Type    string    `json:"type"`
+	UUID    string    `json:"uuid,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	Version string    `json:"version,omitempty"`
+	Service string    `json:"service,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+	Time    time.Time `json:"time"`
 }
 
 //Applications ...
@@ -31,12 +187,48 @@ type Applications struct {
 
 //App ...
 type App struct {
-	UUID    string `json:"uuid"`
-	Name    string `json:"name"`
-	Version string `json:"version"`
-	Path    string `json:"path"`
-	Monitor string `json:"monitor"`
-	Active  string `json:"active"`
+	UUID    string                   `json:"uuid"`
+	Name    string                   `json:"name"`
+	Version string                   `json:"version"`
+	Path    string                   `json:"path"`
+	Monitor string                   `json:"monitor"`
+	Active  string                   `json:"active"`
+	Images  []Image                  `json:"images,omitempty"`
+	Restart map[string]RestartPolicy `json:"restart,omitempty"`
+	Probes  map[string]ServiceProbes `json:"probes,omitempty"`
+}
+
+//Image is a docker image loaded from an application package, Skipped when
+//it was already present and its archive was not loaded
+type Image struct {
+	ID      string   `json:"id"`
+	Tags    []string `json:"tags"`
+	Archive string   `json:"archive"`
+	Skipped bool     `json:"skipped"`
+}
+
+//PruneReport lists the images removed by a prune and the space they took
+type PruneReport struct {
+	Images         []string `json:"images"`
+	SpaceReclaimed int64    `json:"space_reclaimed"`
+}
+
+//AppVersion ...
+type AppVersion struct {
+	Name     string                   `json:"name"`
+	Version  string                   `json:"version"`
+	Monitor  string                   `json:"monitor"`
+	Restart  map[string]RestartPolicy `json:"restart,omitempty"`
+	Probes   map[string]ServiceProbes `json:"probes,omitempty"`
+	Archived time.Time                `json:"archived"`
+}
+
+//AppHistory ...
+type AppHistory struct {
+	UUID     string       `json:"uuid"`
+	Name     string       `json:"name"`
+	Version  string       `json:"version"`
+	Versions []AppVersion `json:"versions"`
 }
 
 //AppDetails ...
@@ -46,6 +238,7 @@ type AppDetails struct {
 	Version    string `json:"version"`
 	Monitor    string `json:"monitor"`
 	Containers []Container
+	Services   map[string]ServiceStatus
 }
 
 //Container ...
@@ -56,3 +249,61 @@ type Container struct {
 	State   string `json:"state"`
 	Ports   string `json:"ports"`
 }
+
+// Phases a deploy goes through, reported to a Progress callback
+const (
+	PhaseUnpacking     = "unpacking"
+	PhaseDecrypting    = "decrypting"
+	PhaseLoadingImages = "loading images"
+	PhaseStarting      = "starting"
+)
+
+//Progress is told when a deploy enters a phase.  While images are loaded
+//current and total count the images, otherwise they are 0.
+type Progress func(phase string, current int, total int)
+
+//Report calls the callback if there is one
+func (p Progress) Report(phase string, current int, total int) {
+	if p != nil {
+		p(phase, current, total)
+	}
+}
+
+// Outcomes of audited operations
+const (
+	AuditSuccess  = "success"
+	AuditFailure  = "failure"
+	AuditAccepted = "accepted"
+)
+
+// Caller is the local process that sent a request over the unix socket
+type Caller struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+	PID int `json:"pid"`
+}
+
+// AuditEntry records a management operation: who asked for it, what it was
+// about and how it ended.  Callers on the TLS listener are identified by the
+// subject of their client certificate, callers with a bearer token by the
+// start of its sha256.  Deploys run as jobs are recorded when they are
+// accepted and again when they finish.
+type AuditEntry struct {
+	Time        time.Time `json:"time"`
+	RequestID   string    `json:"request_id,omitempty"`
+	Caller      *Caller   `json:"caller,omitempty"`
+	Subject     string    `json:"subject,omitempty"`
+	Token       string    `json:"token,omitempty"`
+	Role        string    `json:"role,omitempty"`
+	Remote      string    `json:"remote,omitempty"`
+	Operation   string    `json:"operation"`
+	App         string    `json:"app,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	PackageHash string    `json:"package_hash,omitempty"`
+	Job         string    `json:"job,omitempty"`
+	Detail      string    `json:"detail,omitempty"`
+	Outcome     string    `json:"outcome"`
+	Code        int       `json:"code"`
+	Error       string    `json:"error,omitempty"`
+}
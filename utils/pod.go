@@ -0,0 +1,405 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+// ComposeFile is the name of the compose file every unpacked app must have
+var ComposeFile = "docker-compose.yml"
+
+// PodFiles are the pod spec file names accepted in place of a compose file
+var PodFiles = []string{"pod.json", "pod.yaml", "pod.yml"}
+
+var podNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+var restartPolicies = map[string]string{
+	"":          "",
+	"Always":    "always",
+	"OnFailure": "on-failure",
+	"Never":     "no",
+}
+
+type composeService struct {
+	Image         string   `yaml:"image"`
+	Entrypoint    []string `yaml:"entrypoint,omitempty"`
+	Command       []string `yaml:"command,omitempty"`
+	Environment   []string `yaml:"environment,omitempty"`
+	WorkingDir    string   `yaml:"working_dir,omitempty"`
+	DependsOn     []string `yaml:"depends_on,omitempty"`
+	ExternalLinks []string `yaml:"external_links,omitempty"`
+	Networks      []string `yaml:"networks,omitempty"`
+	Ports         []string `yaml:"ports,omitempty"`
+	Volumes       []string `yaml:"volumes,omitempty"`
+	CPUQuota      int64    `yaml:"cpu_quota,omitempty"`
+	MemLimit      int64    `yaml:"mem_limit,omitempty"`
+	Restart       string   `yaml:"restart,omitempty"`
+}
+
+type composeProject struct {
+	Version  string                       `yaml:"version"`
+	Services map[string]composeService    `yaml:"services"`
+	Networks map[string]map[string]string `yaml:"networks,omitempty"`
+	Volumes  map[string]map[string]string `yaml:"volumes,omitempty"`
+}
+
+// LoadPod reads a pod spec, pod.json is parsed as JSON and anything else as
+// YAML
+func LoadPod(path string) (*types.Pod, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pod types.Pod
+	if filepath.Ext(path) == ".json" {
+		err = json.Unmarshal(data, &pod)
+	} else {
+		err = yaml.Unmarshal(data, &pod)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid pod spec: %v", err)
+	}
+	return &pod, nil
+}
+
+// ParseCPU converts a cpu quantity ("2", "0.5" or "500m") to millicores
+func ParseCPU(quantity string) (int64, error) {
+	if strings.HasSuffix(quantity, "m") {
+		millis, err := strconv.ParseInt(strings.TrimSuffix(quantity, "m"), 10, 64)
+		if err != nil || millis <= 0 {
+			return 0, fmt.Errorf("invalid cpu quantity %q", quantity)
+		}
+		return millis, nil
+	}
+	cores, err := strconv.ParseFloat(quantity, 64)
+	if err != nil || cores <= 0 {
+		return 0, fmt.Errorf("invalid cpu quantity %q", quantity)
+	}
+	return int64(cores * 1000), nil
+}
+
+// ParseMemory converts a memory quantity ("134217728", "128Mi", "1G") to bytes
+func ParseMemory(quantity string) (int64, error) {
+	suffixes := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+		{"K", 1000}, {"M", 1000 * 1000}, {"G", 1000 * 1000 * 1000}, {"T", 1000 * 1000 * 1000 * 1000},
+	}
+	number := quantity
+	multiplier := int64(1)
+	for _, s := range suffixes {
+		if strings.HasSuffix(quantity, s.suffix) {
+			number = strings.TrimSuffix(quantity, s.suffix)
+			multiplier = s.multiplier
+			break
+		}
+	}
+	value, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid memory quantity %q", quantity)
+	}
+	return value * multiplier, nil
+}
+
+// ValidatePod checks a pod spec for everything that would keep it from being
+// translated and run, all problems are reported in a single error
+func ValidatePod(pod *types.Pod) error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if pod.Kind != "" && pod.Kind != "Pod" {
+		add("kind must be Pod, not %q", pod.Kind)
+	}
+	if pod.APIVersion != "" && pod.APIVersion != "v1" {
+		add("apiVersion must be v1, not %q", pod.APIVersion)
+	}
+	if _, ok := restartPolicies[pod.Spec.RestartPolicy]; !ok {
+		add("spec.restartPolicy must be Always, OnFailure or Never, not %q", pod.Spec.RestartPolicy)
+	}
+
+	volumes := make(map[string]bool)
+	for i, volume := range pod.Spec.Volumes {
+		field := fmt.Sprintf("spec.volumes[%d]", i)
+		if !podNameRegexp.MatchString(volume.Name) {
+			add("%s.name %q is not a valid name", field, volume.Name)
+		} else if volumes[volume.Name] {
+			add("%s.name %q is used more than once", field, volume.Name)
+		}
+		volumes[volume.Name] = true
+	}
+
+	if len(pod.Spec.Containers) == 0 {
+		add("spec.containers must contain at least one container")
+	}
+	containers := make(map[string]bool)
+	for _, c := range pod.Spec.Containers {
+		containers[c.Name] = true
+	}
+	seen := make(map[string]bool)
+	for i, c := range pod.Spec.Containers {
+		field := fmt.Sprintf("spec.containers[%d]", i)
+		if !podNameRegexp.MatchString(c.Name) {
+			add("%s.name %q is not a valid name", field, c.Name)
+		} else if seen[c.Name] {
+			add("%s.name %q is used more than once", field, c.Name)
+		}
+		seen[c.Name] = true
+		if c.Image == "" {
+			add("%s.image is required", field)
+		}
+		for j, env := range c.Env {
+			if env.Name == "" || strings.Contains(env.Name, "=") {
+				add("%s.env[%d].name %q is not a valid variable name", field, j, env.Name)
+			}
+		}
+		for j, port := range c.Ports {
+			if port.ContainerPort < 1 || port.ContainerPort > 65535 {
+				add("%s.ports[%d].containerPort %d is out of range", field, j, port.ContainerPort)
+			}
+			if port.HostPort < 0 || port.HostPort > 65535 {
+				add("%s.ports[%d].hostPort %d is out of range", field, j, port.HostPort)
+			}
+			if p := strings.ToUpper(port.Protocol); p != "" && p != "TCP" && p != "UDP" {
+				add("%s.ports[%d].protocol must be TCP or UDP, not %q", field, j, port.Protocol)
+			}
+		}
+		if c.Resources.CPU != "" {
+			if _, err := ParseCPU(c.Resources.CPU); err != nil {
+				add("%s.resources.cpu: %v", field, err)
+			}
+		}
+		if c.Resources.Memory != "" {
+			if _, err := ParseMemory(c.Resources.Memory); err != nil {
+				add("%s.resources.memory: %v", field, err)
+			}
+		}
+		for _, dep := range c.DependsOn {
+			if dep == c.Name {
+				add("%s.depends_on cannot contain the container itself", field)
+			} else if !containers[dep] {
+				add("%s.depends_on refers to unknown container %q", field, dep)
+			}
+		}
+		for j, mount := range c.VolumeMounts {
+			if !volumes[mount.Name] {
+				add("%s.volumeMounts[%d] refers to unknown volume %q", field, j, mount.Name)
+			}
+			if !filepath.IsAbs(mount.MountPath) {
+				add("%s.volumeMounts[%d].mountPath %q must be absolute", field, j, mount.MountPath)
+			}
+		}
+		if c.LivenessProbe != nil {
+			if err := ValidateProbe(c.LivenessProbe); err != nil {
+				add("%s.livenessProbe: %v", field, err)
+			}
+		}
+		if c.ReadinessProbe != nil {
+			if err := ValidateProbe(c.ReadinessProbe); err != nil {
+				add("%s.readinessProbe: %v", field, err)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("Invalid pod spec: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ValidateProbe checks a probe has exactly one valid handler and sane timings
+func ValidateProbe(probe *types.Probe) error {
+	handlers := 0
+	if probe.HTTPGet != nil {
+		handlers++
+		if probe.HTTPGet.Port < 1 || probe.HTTPGet.Port > 65535 {
+			return fmt.Errorf("httpGet.port %d is out of range", probe.HTTPGet.Port)
+		}
+	}
+	if probe.TCPSocket != nil {
+		handlers++
+		if probe.TCPSocket.Port < 1 || probe.TCPSocket.Port > 65535 {
+			return fmt.Errorf("tcpSocket.port %d is out of range", probe.TCPSocket.Port)
+		}
+	}
+	if probe.Exec != nil {
+		handlers++
+		if len(probe.Exec.Command) == 0 {
+			return errors.New("exec.command is required")
+		}
+	}
+	if handlers != 1 {
+		return errors.New("exactly one of httpGet, tcpSocket or exec is required")
+	}
+	if probe.InitialDelaySeconds < 0 || probe.TimeoutSeconds < 0 || probe.PeriodSeconds < 0 || probe.FailureThreshold < 0 {
+		return errors.New("timing values cannot be negative")
+	}
+	return nil
+}
+
+// PodProbes returns the liveness and readiness probes of the containers of
+// a pod, which cappsd runs against the services like the probes of the
+// deploy metadata
+func PodProbes(pod *types.Pod) map[string]types.ServiceProbes {
+	probes := make(map[string]types.ServiceProbes)
+	for _, c := range pod.Spec.Containers {
+		if c.LivenessProbe != nil || c.ReadinessProbe != nil {
+			probes[c.Name] = types.ServiceProbes{Liveness: c.LivenessProbe, Readiness: c.ReadinessProbe}
+		}
+	}
+	return probes
+}
+
+// LoadPodProbes returns the probes of the pod spec of an unpacked payload,
+// none for payloads with a compose file
+func LoadPodProbes(dir string) (map[string]types.ServiceProbes, error) {
+	for _, name := range PodFiles {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		pod, err := LoadPod(path)
+		if err != nil {
+			return nil, err
+		}
+		return PodProbes(pod), nil
+	}
+	return map[string]types.ServiceProbes{}, nil
+}
+
+// PodToCompose translates a validated pod spec into a docker-compose.yml.
+// Every container becomes a service of the same name.  Probes are not part
+// of the compose file, see PodProbes.
+func PodToCompose(pod *types.Pod) ([]byte, error) {
+	if err := ValidatePod(pod); err != nil {
+		return nil, err
+	}
+
+	hostPaths := make(map[string]string)
+	prj := composeProject{
+		Version:  "2",
+		Services: make(map[string]composeService),
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.HostPath != "" {
+			hostPaths[volume.Name] = volume.HostPath
+		} else {
+			if prj.Volumes == nil {
+				prj.Volumes = make(map[string]map[string]string)
+			}
+			prj.Volumes[volume.Name] = map[string]string{}
+		}
+	}
+
+	for _, c := range pod.Spec.Containers {
+		svc := composeService{
+			Image:         c.Image,
+			Entrypoint:    c.Command,
+			Command:       c.Args,
+			WorkingDir:    c.WorkingDir,
+			DependsOn:     c.DependsOn,
+			ExternalLinks: c.ExternalLinks,
+			Networks:      c.Networks,
+			Restart:       restartPolicies[pod.Spec.RestartPolicy],
+		}
+		for _, env := range c.Env {
+			svc.Environment = append(svc.Environment, env.Name+"="+env.Value)
+		}
+		for _, port := range c.Ports {
+			// Like Kubernetes, the container port is published on the same
+			// host port unless another is given
+			hostPort := port.HostPort
+			if hostPort == 0 {
+				hostPort = port.ContainerPort
+			}
+			mapping := strconv.Itoa(hostPort) + ":" + strconv.Itoa(port.ContainerPort)
+			if port.Protocol != "" {
+				mapping += "/" + strings.ToLower(port.Protocol)
+			}
+			svc.Ports = append(svc.Ports, mapping)
+		}
+		for _, mount := range c.VolumeMounts {
+			source := mount.Name
+			if hostPath, ok := hostPaths[mount.Name]; ok {
+				source = hostPath
+				if !filepath.IsAbs(source) && !strings.HasPrefix(source, ".") {
+					source = "./" + source
+				}
+			}
+			volume := source + ":" + mount.MountPath
+			if mount.ReadOnly {
+				volume += ":ro"
+			}
+			svc.Volumes = append(svc.Volumes, volume)
+		}
+		if c.Resources.CPU != "" {
+			millis, _ := ParseCPU(c.Resources.CPU)
+			// Quota against the default 100ms CFS period
+			svc.CPUQuota = millis * 100
+		}
+		if c.Resources.Memory != "" {
+			svc.MemLimit, _ = ParseMemory(c.Resources.Memory)
+		}
+		for _, network := range c.Networks {
+			if prj.Networks == nil {
+				prj.Networks = make(map[string]map[string]string)
+			}
+			prj.Networks[network] = map[string]string{}
+		}
+		prj.Services[c.Name] = svc
+	}
+	return yaml.Marshal(prj)
+}
+
+// ConvertPodSpec writes a docker-compose.yml for an unpacked payload that
+// ships a pod spec instead.  Payloads with a compose file are left alone.
+func ConvertPodSpec(dir string) error {
+	var found []string
+	for _, name := range PodFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			found = append(found, name)
+		}
+	}
+	_, err := os.Stat(filepath.Join(dir, ComposeFile))
+	hasCompose := err == nil
+	if len(found) == 0 {
+		if !hasCompose {
+			return errors.New("Application package malformed: payload contains neither " + ComposeFile + " nor a pod spec")
+		}
+		return nil
+	}
+	if hasCompose || len(found) > 1 {
+		sort.Strings(found)
+		if hasCompose {
+			found = append([]string{ComposeFile}, found...)
+		}
+		return errors.New("Application package malformed: payload contains more than one app description (" + strings.Join(found, ", ") + ")")
+	}
+
+	logging.Info("Translating pod spec", "pod", found[0], "compose", ComposeFile)
+	pod, err := LoadPod(filepath.Join(dir, found[0]))
+	if err != nil {
+		return err
+	}
+	compose, err := PodToCompose(pod)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, ComposeFile), compose, 0644)
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
+)
+
+const testPodYAML = `kind: Pod
+apiVersion: v1
+metadata:
+  name: sample
+spec:
+  restartPolicy: OnFailure
+  volumes:
+    - name: data
+      hostPath: data
+  containers:
+    - name: web
+      image: web:1.0
+      command: ["/bin/web"]
+      args: ["--port", "8080"]
+      env:
+        - name: MODE
+          value: test
+      ports:
+        - containerPort: 8080
+          hostPort: 80
+          protocol: TCP
+      resources:
+        cpu: 500m
+        memory: 128Mi
+      volumeMounts:
+        - name: data
+          mountPath: /data
+          readOnly: true
+      depends_on:
+        - db
+      livenessProbe:
+        httpGet:
+          path: /health
+          port: 8080
+        periodSeconds: 5
+        failureThreshold: 2
+    - name: db
+      image: db:1.0
+      ports:
+        - containerPort: 5432
+      readinessProbe:
+        tcpSocket:
+          port: 5432
+        initialDelaySeconds: 10
+`
+
+func tarGz(files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))})
+		tw.Write(data)
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestPodToCompose(t *testing.T) {
+	var pod types.Pod
+	if err := yaml.Unmarshal([]byte(testPodYAML), &pod); err != nil {
+		t.Fatal(err)
+	}
+	data, err := PodToCompose(&pod)
+	if err != nil {
+		t.Fatal("Expected valid pod, got ", err)
+	}
+
+	var compose composeProject
+	if err = yaml.Unmarshal(data, &compose); err != nil {
+		t.Fatal(err)
+	}
+	web := compose.Services["web"]
+	if web.Image != "web:1.0" || web.Entrypoint[0] != "/bin/web" || web.Command[1] != "8080" {
+		t.Error("Image or command not translated: ", web)
+	}
+	if web.Environment[0] != "MODE=test" || web.Ports[0] != "80:8080/tcp" || web.Volumes[0] != "./data:/data:ro" {
+		t.Error("Environment, ports or volumes not translated: ", web)
+	}
+	if web.CPUQuota != 50000 || web.MemLimit != 128*1024*1024 || web.Restart != "on-failure" {
+		t.Error("Resources or restart policy not translated: ", web)
+	}
+	if strings.Contains(string(data), "healthcheck") {
+		t.Error("Expected probes to be left out of the compose file:\n", string(data))
+	}
+	if _, ok := compose.Services["db"]; !ok || web.DependsOn[0] != "db" {
+		t.Error("Expected db service and dependency")
+	}
+	if db := compose.Services["db"]; len(db.Ports) != 1 || db.Ports[0] != "5432:5432" {
+		t.Error("Expected the host port to default to the container port, got ", db.Ports)
+	}
+
+	probes := PodProbes(&pod)
+	if len(probes) != 2 || probes["web"].Liveness == nil || probes["web"].Liveness.HTTPGet.Path != "/health" ||
+		probes["web"].Readiness != nil || probes["db"].Readiness == nil || probes["db"].Readiness.InitialDelaySeconds != 10 {
+		t.Error("Expected the probes of the pod, got ", probes)
+	}
+}
+
+func TestValidatePod(t *testing.T) {
+	pod := types.Pod{
+		Kind: "Deployment",
+		Spec: types.PodSpec{
+			RestartPolicy: "Sometimes",
+			Containers: []types.PodContainer{
+				{Name: "web", Ports: []types.PodPort{{ContainerPort: 70000}}, DependsOn: []string{"cache"}},
+				{Name: "web", Image: "web", Resources: types.PodResources{Memory: "lots"}},
+			},
+		},
+	}
+	err := ValidatePod(&pod)
+	if err == nil {
+		t.Fatal("Expected invalid pod to fail validation")
+	}
+	for _, expected := range []string{"kind", "restartPolicy", "containers[0].image", "containerPort 70000",
+		"unknown container \"cache\"", "containers[1].name \"web\" is used more than once", "resources.memory"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q in validation error: %s", expected, err)
+		}
+	}
+}
+
+func TestUnpackPodSpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappsd-pod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pkg := tarGz(map[string][]byte{
+		"MANIFEST.JSON":  []byte("{}"),
+		"payload.tar.gz": tarGz(map[string][]byte{"pod.yaml": []byte(testPodYAML)}),
+	})
+	if err = Unpack(bytes.NewReader(pkg), dir, config.Config{}); err != nil {
+		t.Fatal("Unpack failed: ", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, ComposeFile)); err != nil {
+		t.Error("Expected pod spec to be translated to ", ComposeFile)
+	}
+	if probes, err := LoadPodProbes(dir); err != nil || probes["web"].Liveness == nil {
+		t.Error("Expected the probes of the unpacked pod spec, got ", probes, err)
+	}
+	if probes, err := LoadPodProbes(filepath.Join(dir, "data")); err != nil || len(probes) != 0 {
+		t.Error("Expected no probes without a pod spec, got ", probes, err)
+	}
+
+	invalid := tarGz(map[string][]byte{
+		"MANIFEST.JSON":  []byte("{}"),
+		"payload.tar.gz": tarGz(map[string][]byte{"pod.json": []byte(`{"spec": {"containers": []}}`)}),
+	})
+	os.Mkdir(dir+"/invalid", os.ModePerm)
+	err = Unpack(bytes.NewReader(invalid), dir+"/invalid", config.Config{})
+	if err == nil || !strings.Contains(err.Error(), "Invalid pod spec") {
+		t.Error("Expected invalid pod spec to fail unpacking, got ", err)
+	}
+}
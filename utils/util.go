@@ -9,20 +9,20 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/pem"
-	"bytes"
 	"io/ioutil"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"regexp"
 	"time"
 	"strconv"
 	"strings"
 	"os/exec"
 	
 	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/logging"
+	"github.build.ge.com/PredixEdgeOS/container-app-service/types"
 )
 
 // Constants
@@ -37,6 +37,8 @@ var(
 	PadLength = 1
 	DecryptTPMCommandFmt = "openssl rsautl -decrypt -keyform engine -engine tpm2tss -inkey %s"
 	DecryptOpensslCommandFmt = "openssl rsautl -decrypt -inkey %s"
+	// size of the buffer encrypted payloads are decrypted in
+	UnpackChunkSize = 64 * 1024
 )
 
 // NewUUID generates a random UUID according to RFC 4122
@@ -61,7 +63,7 @@ func GetLockKeyName(cfg config.Config) (string, error) {
 	}
 	nameBytes, err := ioutil.ReadFile(cfg.KeyName)
 	if err != nil {
-		fmt.Printf("Could not key name from file (%s): \n%v", cfg.KeyName, err)
+		logging.Warn("Could not read the lock key name", "file", cfg.KeyName, "error", err)
 	}
 	return string(nameBytes), nil
 }
@@ -137,25 +139,119 @@ func HasTPM2() (bool, error) {
 	return hasTPM, nil
 }
 
-//Unpack package tarball
-// Expected Manifest:
-// <application_name>.tar.gz            (top level tarball)
-//   - MANIFEST.JSON                    (LTC parsable package info)
-//   - <lockfile_name 0..n>.lockfile    (RSA encrypted symmetric key files, there are many... 1 per machine)
-//   - <application_name>.tar.gz<.enc>  (application payload - .enc indicates encrypted by symmetric key in .lockfile)
-func Unpack(source io.Reader, target string, cfg config.Config) error {
-	var unencryptedReader io.Reader
-	var lockkeyData, encryptedData []byte
-	//open top level of tarball
-	fmt.Println("Unpacking application pacakge...")
-	topArchive, err := gzip.NewReader(source)
+// DecryptLockKey decrypts the lockkey of this machine with its private key,
+// returning the salt, padding length, AES key and iv.  It is a variable so
+// tests can stand in for the TPM and openssl.
+var DecryptLockKey = func(lockkeyData []byte, cfg config.Config) ([]byte, error) {
+	unlockKeyCommand := fmt.Sprintf(DecryptOpensslCommandFmt, cfg.KeyLocation)
+	hasTPM, err := HasTPM2()
+	if err != nil {
+		logging.Error("Error determining if this device uses TPM2.0", "error", err)
+		return nil, err
+	}
+	if hasTPM {
+		logging.Info("TPM2.0 detected, decrypting using TPM locked private key")
+		unlockKeyCommand = fmt.Sprintf(DecryptTPMCommandFmt, cfg.KeyLocation)
+	} else {
+		logging.Info("No TPM found, decrypting using openssl generated private key")
+	}
+	cmd := exec.Command("sh", "-c", unlockKeyCommand)
+	inPipe, err := cmd.StdinPipe()
+	if err != nil {
+		logging.Error("Error decrypting lock key (get stdin)", "error", err)
+		return nil, err
+	}
+	go func() {
+		defer inPipe.Close()
+		inPipe.Write(lockkeyData)
+		//TODO: catch error from this somehow.  maybe w/ channel?
+	}()
+
+	aesPadKeyIv, err := cmd.Output()
+	if err != nil {
+		logging.Error("Error decrypting lock key (command execution)", "error", err)
+		return nil, err
+	}
+	return aesPadKeyIv, nil
+}
+
+// cbcReader decrypts an AES-CBC encrypted stream a chunk at a time
+type cbcReader struct {
+	source io.Reader
+	mode   cipher.BlockMode
+	chunk  []byte
+	clear  []byte
+	err    error
+}
+
+func (r *cbcReader) Read(p []byte) (int, error) {
+	for len(r.clear) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		n, err := io.ReadFull(r.source, r.chunk)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		if n%aes.BlockSize != 0 {
+			return 0, errors.New("Encrypted payload is not a multiple of the block size")
+		}
+		r.mode.CryptBlocks(r.chunk[:n], r.chunk[:n])
+		r.clear = r.chunk[:n]
+		r.err = err
+	}
+	n := copy(p, r.clear)
+	r.clear = r.clear[n:]
+	return n, nil
+}
+
+// decryptingReader returns the clear payload of an encrypted payload of the
+// given size, using the decrypted lockkey of this machine.  Only one chunk
+// of the payload is held in memory at a time.
+func decryptingReader(encrypted io.Reader, size int64, lockkeyData []byte, cfg config.Config) (io.Reader, error) {
+	aesPadKeyIv, err := DecryptLockKey(lockkeyData, cfg)
+	if err != nil {
+		return nil, err
+	}
+	actualDecryptedLen := len(aesPadKeyIv)
+	if actualDecryptedLen < DecryptedLockKeyLength {
+		errString := fmt.Sprintf("Error, decrypted padding, key, and iv length (%d) are not correct (expected >= %d)",
+			len(aesPadKeyIv), DecryptedLockKeyLength)
+		return nil, errors.New(errString)
+	}
+
+	padding := aesPadKeyIv[actualDecryptedLen-PadLength-AesLength-IvLength]
+	aesKeyBytes := aesPadKeyIv[actualDecryptedLen-AesLength-IvLength : actualDecryptedLen-IvLength]
+	iv := aesPadKeyIv[actualDecryptedLen-IvLength : actualDecryptedLen]
+	aesKey, err := aes.NewCipher(aesKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	if size%aes.BlockSize != 0 {
+		return nil, errors.New("Encrypted payload is not a multiple of the block size")
+	}
+	if int64(padding) > size {
+		return nil, errors.New("Encrypted payload is shorter than its padding")
+	}
+	decrypter := &cbcReader{
+		source: encrypted,
+		mode:   cipher.NewCBCDecrypter(aesKey, iv),
+		chunk:  make([]byte, UnpackChunkSize-UnpackChunkSize%aes.BlockSize),
+	}
+	// The padding is only known from the lockkey, so the clear payload
+	// ends that many bytes before the encrypted one
+	return io.LimitReader(decrypter, size-int64(padding)), nil
+}
+
+// extractPayload extracts the gzipped data payload tarball into target
+func extractPayload(payload io.Reader, target string) error {
+	archive, err := gzip.NewReader(payload)
 	if err != nil {
 		return err
 	}
-	defer topArchive.Close()
-	tarReader := tar.NewReader(topArchive)
-	lockKeyName, getKeyErr := GetLockKeyName(cfg)
-	fmt.Println("  Package contents:")
+	defer archive.Close()
+	logging.Debug("Unpacking data payload")
+	tarReader := tar.NewReader(archive)
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
@@ -163,114 +259,111 @@ func Unpack(source io.Reader, target string, cfg config.Config) error {
 		} else if err != nil {
 			return err
 		}
-		info := header.FileInfo()
-		fmt.Printf("    %s\n", header.Name)
-		if !info.IsDir() {
-			if filepath.Ext(header.Name) == EncryptedExtension {
-				if encryptedData != nil {
-					return errors.New("Application package malformed: multiple encrypted payloads")
-				}
-				encryptedData, err = ioutil.ReadAll(tarReader)
-				if err != nil {
-					return err
-				}
-			} else if filepath.Ext(header.Name) == GzipExtension {
-				if unencryptedReader != nil {
-					return errors.New("Application package malformed: multiple unencrypted payloads.  This may be a deprecated package format.")
-				}
-				data, err := ioutil.ReadAll(tarReader)
-				if err != nil {
-					return err
-				}
-				unencryptedReader = bytes.NewReader(data)
-			} else if lockKeyName != "" && filepath.Base(header.Name) == lockKeyName {
-				if lockkeyData != nil {
-					return errors.New("Application package malformed: multiple machine lockkeys")
-				}
-				lockkeyData, err = ioutil.ReadAll(tarReader)
-				if err != nil {
-					return err
-				}
-			}
-		}
-	}
-	
-	if encryptedData == nil && unencryptedReader == nil {
-		return errors.New("Application package malformed: no package payload found")
-	} else if encryptedData != nil && unencryptedReader != nil {
-		return errors.New("Application package malformed: contains encrypted and clear payloads")
-	} else if encryptedData != nil && getKeyErr != nil {
-		return getKeyErr //TODO: maybe wrap this err message to provide more context
-	} else if encryptedData != nil && lockkeyData == nil {
-			errString := fmt.Sprintf("Application package malformed: encrypted package, but no lockkey for this machine (looking for %s)",
-				lockKeyName)
-		return errors.New(errString)
-	} else if encryptedData != nil && lockkeyData != nil {
-		fmt.Println("  This is an encrypted package, decrypting...")
-		unlockKeyCommand := fmt.Sprintf(DecryptOpensslCommandFmt, cfg.KeyLocation)
-		hasTPM, err := HasTPM2()
+		logging.Debug("Examining payload file", "file", header.Name)
+		path, err := payloadPath(target, header.Name)
 		if err != nil {
-			fmt.Printf("    Error determining if this device uses TPM2.0: %s\n", err.Error())
 			return err
 		}
-		if hasTPM {
-			fmt.Println("  TPM2.0 Detected, decrypting using TPM locked private key")
-			unlockKeyCommand = fmt.Sprintf(DecryptTPMCommandFmt, cfg.KeyLocation)
-		} else {
-			fmt.Println("  NO TPM FOUND, decrypting using openssl generated private key")
-		}
-		cmd := exec.Command("sh", "-c", unlockKeyCommand)
-		inPipe, err := cmd.StdinPipe()
-		if err != nil {
-			fmt.Printf("    Error decrypting lock key (get stdin): %s\n", err.Error)
-			return err
+		info := header.FileInfo()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(path, info.Mode()); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err = extractFile(path, info.Mode(), tarReader); err != nil {
+				return err
+			}
+		default:
+			// Links could point the files after them outside of target
+			return errors.New("Invalid data payload tarball: unsupported entry " + header.Name)
 		}
-		go func() {
-			defer inPipe.Close()
-			inPipe.Write(lockkeyData)
-			//TODO: catch error from this somehow.  maybe w/ channel?
-		}()
+	}
+	logging.Debug("Data payload unpacking complete")
+	return nil
+}
 
-		aesPadKeyIv, err := cmd.Output()
-		if err != nil {
-			fmt.Printf("    Error decrypting lock key (command execution): %s\n", err.Error)
-			return err
-		}
+// payloadPath returns where an entry of a payload is extracted to, which
+// must be inside target
+func payloadPath(target string, name string) (string, error) {
+	target = filepath.Clean(target)
+	path := filepath.Join(target, name)
+	if path != target && !strings.HasPrefix(path, target+string(os.PathSeparator)) {
+		return "", errors.New("Invalid data payload tarball: " + name + " is outside of the package")
+	}
+	return path, nil
+}
+
+func extractFile(path string, mode os.FileMode, source io.Reader) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, source)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
 
-		actualDecryptedLen := len(aesPadKeyIv)
-		if actualDecryptedLen < DecryptedLockKeyLength {
-			errString := fmt.Sprintf("Error, decrypted padding, key, and iv length (%d) are not correct (expected >= %d)",
-				len(aesPadKeyIv), DecryptedLockKeyLength)
-			return errors.New(errString)
+//Unpack package tarball
+// Expected Manifest:
+// <application_name>.tar.gz            (top level tarball)
+//   - MANIFEST.JSON                    (LTC parsable package info)
+//   - <lockfile_name 0..n>.lockfile    (RSA encrypted symmetric key files, there are many... 1 per machine)
+//   - <application_name>.tar.gz<.enc>  (application payload - .enc indicates encrypted by symmetric key in .lockfile)
+// The payload must contain either a docker-compose.yml or a pod spec, which
+// is translated to a docker-compose.yml after unpacking.
+func Unpack(source io.Reader, target string, cfg config.Config) error {
+	return UnpackWithProgress(source, target, cfg, nil)
+}
+
+//UnpackWithProgress unpacks a package like Unpack, reporting when it starts
+//unpacking and decrypting.
+//
+// The package is streamed: payloads are decrypted and extracted while they
+// are read from the top level tarball, so memory use does not depend on the
+// package size.  Only when an encrypted payload comes before the lockkey of
+// this machine is it spooled to a temporary file next to target until the
+// lockkey is found.
+func UnpackWithProgress(source io.Reader, target string, cfg config.Config, progress types.Progress) error {
+	var lockkeyData []byte
+	var encryptedSize int64
+	var spool *os.File
+	hasEncrypted, hasUnencrypted, decrypted := false, false, false
+	progress.Report(types.PhaseUnpacking, 0, 0)
+	defer func() {
+		if spool != nil {
+			spool.Close()
+			os.Remove(spool.Name())
 		}
-		
-		padding := aesPadKeyIv[actualDecryptedLen - PadLength - AesLength - IvLength]
-		aesKeyBytes := aesPadKeyIv[actualDecryptedLen - AesLength - IvLength : actualDecryptedLen - IvLength]
-		iv := aesPadKeyIv[actualDecryptedLen - IvLength : actualDecryptedLen]
-		aesKey, err := aes.NewCipher(aesKeyBytes)
+	}()
+
+	//open top level of tarball
+	logging.Info("Unpacking application package")
+	topArchive, err := gzip.NewReader(source)
+	if err != nil {
+		return err
+	}
+	defer topArchive.Close()
+	tarReader := tar.NewReader(topArchive)
+	lockKeyName, getKeyErr := GetLockKeyName(cfg)
+	decrypt := func(encrypted io.Reader, size int64) error {
+		logging.Info("Decrypting encrypted package")
+		progress.Report(types.PhaseDecrypting, 0, 0)
+		payload, err := decryptingReader(encrypted, size, lockkeyData, cfg)
 		if err != nil {
 			return err
 		}
-		//decrypt payload
-		if len(encryptedData)%aes.BlockSize != 0 {
-			return errors.New("Encrypted payload is not a multiple of the block size")
+		if err = extractPayload(payload, target); err != nil {
+			return err
 		}
-		decrypter := cipher.NewCBCDecrypter(aesKey, iv)
-		clearFilePadded := make([]byte, len(encryptedData))
-		unpaddedLen := len(encryptedData) - int(padding)
-		decrypter.CryptBlocks(clearFilePadded, encryptedData)
-		clearFile := clearFilePadded[0:unpaddedLen]
-		unencryptedReader = bytes.NewReader(clearFile)
-		fmt.Println("  Decryption complete.")
-	}	
-	//handle decrypted (or unencrypted) payload
-	archive, err := gzip.NewReader(unencryptedReader)
-	if err != nil {
-		return err
+		decrypted = true
+		logging.Info("Decryption complete")
+		progress.Report(types.PhaseUnpacking, 0, 0)
+		return nil
 	}
-	defer archive.Close()
-	fmt.Println("  Unpacking data payload...")
-	tarReader = tar.NewReader(archive)
+
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
@@ -278,33 +371,75 @@ func Unpack(source io.Reader, target string, cfg config.Config) error {
 		} else if err != nil {
 			return err
 		}
-		fmt.Printf("    Examining %s\n", header.Name)
-		path := filepath.Join(target, header.Name)
 		info := header.FileInfo()
-		invalid, _ := regexp.MatchString(`^.*\.\.\/.*$`, path)
-		if invalid == false {
-			if info.IsDir() {
-				if err = os.MkdirAll(path, info.Mode()); err != nil {
+		logging.Debug("Package file", "file", header.Name)
+		if info.IsDir() {
+			continue
+		}
+		if filepath.Ext(header.Name) == EncryptedExtension {
+			if hasEncrypted {
+				return errors.New("Application package malformed: multiple encrypted payloads")
+			}
+			hasEncrypted = true
+			if hasUnencrypted || lockKeyName == "" || getKeyErr != nil {
+				// Rejected below once the whole package was checked
+				continue
+			}
+			if lockkeyData != nil {
+				if err = decrypt(tarReader, header.Size); err != nil {
 					return err
 				}
 				continue
 			}
-
-			file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
-			if err != nil {
+			// The lockkey may still follow, keep the payload on disk
+			encryptedSize = header.Size
+			if spool, err = ioutil.TempFile(filepath.Dir(target), ".cappsd-payload"); err != nil {
+				return err
+			}
+			if _, err = io.Copy(spool, tarReader); err != nil {
 				return err
 			}
-			defer file.Close()
-			_, err = io.Copy(file, tarReader)
+		} else if filepath.Ext(header.Name) == GzipExtension {
+			if hasUnencrypted {
+				return errors.New("Application package malformed: multiple unencrypted payloads.  This may be a deprecated package format.")
+			}
+			hasUnencrypted = true
+			if hasEncrypted {
+				continue
+			}
+			if err = extractPayload(tarReader, target); err != nil {
+				return err
+			}
+		} else if lockKeyName != "" && filepath.Base(header.Name) == lockKeyName {
+			if lockkeyData != nil {
+				return errors.New("Application package malformed: multiple machine lockkeys")
+			}
+			lockkeyData, err = ioutil.ReadAll(tarReader)
 			if err != nil {
 				return err
 			}
-		} else {
-			return errors.New("Invalid data payload tarball")
 		}
 	}
-	fmt.Println("  Data payload unpacking complete.")
-	return nil
+
+	if !hasEncrypted && !hasUnencrypted {
+		return errors.New("Application package malformed: no package payload found")
+	} else if hasEncrypted && hasUnencrypted {
+		return errors.New("Application package malformed: contains encrypted and clear payloads")
+	} else if hasEncrypted && getKeyErr != nil {
+		return getKeyErr //TODO: maybe wrap this err message to provide more context
+	} else if hasEncrypted && lockkeyData == nil {
+		errString := fmt.Sprintf("Application package malformed: encrypted package, but no lockkey for this machine (looking for %s)",
+			lockKeyName)
+		return errors.New(errString)
+	} else if hasEncrypted && !decrypted {
+		if _, err = spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err = decrypt(spool, encryptedSize); err != nil {
+			return err
+		}
+	}
+	return ConvertPodSpec(target)
 }
 
 // RetryWithBackoff takes a Backoff and a function to call that returns an error
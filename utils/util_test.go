@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.build.ge.com/PredixEdgeOS/container-app-service/config"
+)
+
+// orderedTarGz builds a gzipped tarball with entries in the given order,
+// names and contents alternate
+func orderedTarGz(entries ...interface{}) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for i := 0; i < len(entries); i += 2 {
+		data := entries[i+1].([]byte)
+		tw.WriteHeader(&tar.Header{Name: entries[i].(string), Mode: 0644, Size: int64(len(data))})
+		tw.Write(data)
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// encryptPayload encrypts a payload the way the packaging instructions do
+// and returns it with the clear lockkey contents
+func encryptPayload(t *testing.T, payload []byte) ([]byte, []byte) {
+	key := make([]byte, AesLength)
+	iv := make([]byte, IvLength)
+	salt := make([]byte, 16)
+	rand.Read(key)
+	rand.Read(iv)
+	rand.Read(salt)
+
+	padding := aes.BlockSize - len(payload)%aes.BlockSize
+	padded := append(append([]byte{}, payload...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+
+	lockkey := append(append(append(salt, byte(padding)), key...), iv...)
+	return encrypted, lockkey
+}
+
+func TestUnpackEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappsd-unpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var cfg config.Config
+	cfg.KeyName = filepath.Join(dir, "key_name")
+	ioutil.WriteFile(cfg.KeyName, []byte("machine1"), 0644)
+
+	// Large enough to span several decryption chunks, and not a multiple
+	// of the block size
+	large := make([]byte, 3*UnpackChunkSize+5)
+	rand.Read(large)
+	compose := []byte("version: '2'\nservices:\n  web:\n    image: web\n")
+	payload := orderedTarGz("docker-compose.yml", compose, "web.tar", large)
+	encrypted, lockkey := encryptPayload(t, payload)
+
+	saved := DecryptLockKey
+	defer func() { DecryptLockKey = saved }()
+	DecryptLockKey = func(data []byte, cfg config.Config) ([]byte, error) {
+		if string(data) != "locked" {
+			t.Error("Expected the lockkey of this machine, got ", string(data))
+		}
+		return lockkey, nil
+	}
+
+	packages := map[string][]byte{
+		"lockkey first": orderedTarGz("MANIFEST.JSON", []byte("{}"), "machine2", []byte("other"),
+			"machine1", []byte("locked"), "app.tar.gz.enc", encrypted),
+		"payload first": orderedTarGz("MANIFEST.JSON", []byte("{}"), "app.tar.gz.enc", encrypted,
+			"machine1", []byte("locked")),
+	}
+	for name, pkg := range packages {
+		target := filepath.Join(dir, strings.Replace(name, " ", "-", -1))
+		os.Mkdir(target, os.ModePerm)
+		if err = Unpack(bytes.NewReader(pkg), target, cfg); err != nil {
+			t.Error(name, ": unpack failed: ", err)
+			continue
+		}
+		if data, _ := ioutil.ReadFile(filepath.Join(target, "web.tar")); !bytes.Equal(data, large) {
+			t.Error(name, ": payload was not decrypted intact")
+		}
+		if data, _ := ioutil.ReadFile(filepath.Join(target, "docker-compose.yml")); !bytes.Equal(data, compose) {
+			t.Error(name, ": compose file was not decrypted intact")
+		}
+	}
+	if spooled, _ := filepath.Glob(filepath.Join(dir, ".cappsd-payload*")); len(spooled) != 0 {
+		t.Error("Expected spooled payload to be removed, got ", spooled)
+	}
+
+	noKey := orderedTarGz("MANIFEST.JSON", []byte("{}"), "app.tar.gz.enc", encrypted)
+	err = Unpack(bytes.NewReader(noKey), filepath.Join(dir, "lockkey-first"), cfg)
+	if err == nil || !strings.Contains(err.Error(), "no lockkey for this machine") {
+		t.Error("Expected missing lockkey to be reported, got ", err)
+	}
+	both := orderedTarGz("machine1", []byte("locked"), "app.tar.gz.enc", encrypted, "app.tar.gz", payload)
+	err = Unpack(bytes.NewReader(both), filepath.Join(dir, "lockkey-first"), cfg)
+	if err == nil || !strings.Contains(err.Error(), "contains encrypted and clear payloads") {
+		t.Error("Expected mixed payloads to be rejected, got ", err)
+	}
+}
+
+func TestUnpackPayloadOutsideTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappsd-unpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "app")
+
+	compose := []byte("version: '2'\n")
+	for name, entry := range map[string]tar.Header{
+		"parent":    {Name: "../escape", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(compose))},
+		"nested":    {Name: "data/../../escape", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(compose))},
+		"sibling":   {Name: "../app-other/escape", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(compose))},
+		"symlink":   {Name: "data", Typeflag: tar.TypeSymlink, Linkname: dir, Mode: 0777},
+		"hard link": {Name: "passwd", Typeflag: tar.TypeLink, Linkname: "/etc/passwd", Mode: 0644},
+		"device":    {Name: "null", Typeflag: tar.TypeChar, Mode: 0666},
+	} {
+		var payload bytes.Buffer
+		gz := gzip.NewWriter(&payload)
+		tw := tar.NewWriter(gz)
+		tw.WriteHeader(&tar.Header{Name: "docker-compose.yml", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(compose))})
+		tw.Write(compose)
+		tw.WriteHeader(&entry)
+		if entry.Size > 0 {
+			tw.Write(compose)
+		}
+		tw.Close()
+		gz.Close()
+
+		os.RemoveAll(target)
+		os.Mkdir(target, os.ModePerm)
+		pkg := orderedTarGz("MANIFEST.JSON", []byte("{}"), "app.tar.gz", payload.Bytes())
+		if err = Unpack(bytes.NewReader(pkg), target, config.Config{}); err == nil || !strings.Contains(err.Error(), "Invalid data payload tarball") {
+			t.Error("Expected the ", name, " entry to be rejected, got ", err)
+		}
+	}
+	for _, escaped := range []string{filepath.Join(dir, "escape"), filepath.Join(dir, "app-other")} {
+		if _, err = os.Stat(escaped); !os.IsNotExist(err) {
+			t.Error("Expected nothing to be written outside of the package, found ", escaped)
+		}
+	}
+}